@@ -0,0 +1,198 @@
+// MIT License
+//
+// # Copyright (c) 2019 Stefan Wichmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package main
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// AwayEvent represents a planned switch of a light by the presence
+// simulation of the away mode.
+type AwayEvent struct {
+	Time     time.Time `json:"time"`
+	On       bool      `json:"on"`
+	Executed bool      `json:"executed"`
+}
+
+func (event AwayEvent) String() string {
+	action := "off"
+	if event.On {
+		action = "on"
+	}
+	return fmt.Sprintf("%s at %s", action, event.Time.Format("15:04"))
+}
+
+// planAwayMode generates the presence simulation for all selected lights
+// on the day of the given time. Pending events of the previous plan are
+// kept.
+func planAwayMode(now time.Time) {
+	if !configuration.AwayMode.Enabled {
+		return
+	}
+	if len(configuration.AwayMode.AssociatedDeviceIDs) == 0 {
+		log.Warningf("🏠 Away mode is enabled but no lights are selected")
+		return
+	}
+
+	now = now.In(configuration.TimeLocation())
+	random := rand.New(rand.NewPCG(uint64(now.UnixNano()), 0))
+	for _, light := range lights {
+		if !configuration.containsDevice(configuration.AwayMode.AssociatedDeviceIDs, light.Device) {
+			continue
+		}
+		// The light is switched on with the light state of its schedule
+		if !light.Scheduled {
+			log.Warningf("🏠 Light %s - Light is not associated to any schedule. Ignoring in away mode...", light.Name)
+			light.Away = nil
+			continue
+		}
+
+		plan, err := configuration.AwayMode.plan(now, random)
+		if err != nil {
+			log.Warningf("🏠 Could not plan away mode: %v", err)
+			return
+		}
+
+		var events []AwayEvent
+		for _, event := range light.Away {
+			if !event.Executed && event.Time.After(now) {
+				events = append(events, event)
+			}
+		}
+		for _, event := range plan {
+			if event.Time.After(now) {
+				events = append(events, event)
+			}
+		}
+		light.Away = events
+		log.Printf("🏠 Light %s - Planned away mode: %s", light.Name, awayEventsToString(light.Away))
+	}
+}
+
+// clearAwayMode removes all planned events.
+func clearAwayMode() {
+	for _, light := range lights {
+		light.Away = nil
+	}
+}
+
+// executeAwayMode switches all lights with due events of the plan.
+func executeAwayMode(now time.Time) {
+	if !configuration.AwayMode.Enabled {
+		return
+	}
+	for _, light := range lights {
+		for index := range light.Away {
+			event := &light.Away[index]
+			if event.Executed || event.Time.After(now) {
+				continue
+			}
+			event.Executed = true
+			if !light.Scheduled {
+				log.Printf("🏠 Light %s - Light is no longer associated to any schedule. Skipping away mode event %s", light.Name, event)
+				continue
+			}
+
+			var err error
+			if event.On {
+				log.Printf("🏠 Light %s - Away mode switching light on at %vK and %v%% brightness", light.Name, light.TargetLightState.ColorTemperature, light.TargetLightState.Brightness)
+				err = light.HueLight.switchOn(light.TargetLightState.ColorTemperature, light.TargetLightState.Brightness, lightTransistionTime)
+			} else {
+				log.Printf("🏠 Light %s - Away mode switching light off", light.Name)
+				err = light.HueLight.setLightState(-1, 0, lightTransistionTime)
+			}
+			if err != nil {
+				log.Warningf("🏠 Light %s - Away mode could not switch light: %v", light.Name, err)
+			}
+		}
+	}
+}
+
+// plan generates one or two randomized periods in which a light is
+// switched on between the configured start and end.
+func (away *AwayMode) plan(date time.Time, random *rand.Rand) ([]AwayEvent, error) {
+	start, err := parseTimeOfDay(away.Start, date)
+	if err != nil {
+		return nil, err
+	}
+	end, err := parseTimeOfDay(away.End, date)
+	if err != nil {
+		return nil, err
+	}
+	if !end.After(start) {
+		// window ends after midnight
+		end = end.AddDate(0, 0, 1)
+	}
+
+	jitter := time.Duration(away.Jitter) * time.Minute
+	periods := 1 + random.IntN(2)
+	segment := end.Sub(start) / time.Duration(periods)
+
+	var events []AwayEvent
+	for period := 0; period < periods; period++ {
+		segmentStart := start.Add(time.Duration(period) * segment)
+		on := segmentStart.Add(randomDuration(random, segment/4)).Add(randomJitter(random, jitter))
+		off := segmentStart.Add(segment).Add(-randomDuration(random, segment/4)).Add(randomJitter(random, jitter))
+		if len(events) > 0 && !on.After(events[len(events)-1].Time) {
+			// keep the light off for a while between two periods
+			on = events[len(events)-1].Time.Add(segment / 8)
+		}
+		if !off.After(on) {
+			off = on.Add(segment / 2)
+		}
+		events = append(events, AwayEvent{on, true, false}, AwayEvent{off, false, false})
+	}
+	return events, nil
+}
+
+func randomDuration(random *rand.Rand, max time.Duration) time.Duration {
+	if max <= 0 {
+		return 0
+	}
+	return time.Duration(random.Int64N(int64(max)))
+}
+
+func randomJitter(random *rand.Rand, jitter time.Duration) time.Duration {
+	return randomDuration(random, 2*jitter) - jitter
+}
+
+func parseTimeOfDay(value string, date time.Time) (time.Time, error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return t, err
+	}
+	yr, mth, day := date.Date()
+	return localTime(yr, mth, day, t.Hour(), t.Minute(), 0, date.Location()), nil
+}
+
+func awayEventsToString(events []AwayEvent) string {
+	var s []string
+	for _, event := range events {
+		s = append(s, event.String())
+	}
+	return strings.Join(s, ", ")
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"math/rand/v2"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAwayModePlan(t *testing.T) {
+	tests := []AwayMode{
+		{Start: "18:00", End: "23:00", Jitter: 30},
+		{Start: "20:00", End: "01:30", Jitter: 15},
+		{Start: "19:00", End: "19:30", Jitter: 0},
+	}
+
+	date := time.Date(2023, time.November, 6, 12, 0, 0, 0, time.UTC)
+	for _, away := range tests {
+		start, _ := parseTimeOfDay(away.Start, date)
+		end, _ := parseTimeOfDay(away.End, date)
+		if !end.After(start) {
+			end = end.AddDate(0, 0, 1)
+		}
+		jitter := time.Duration(away.Jitter) * time.Minute
+
+		for seed := uint64(0); seed < 50; seed++ {
+			events, err := away.plan(date, rand.New(rand.NewPCG(seed, 0)))
+			if err != nil {
+				t.Fatalf("plan(%+v) returned error: %v", away, err)
+			}
+			if len(events) != 2 && len(events) != 4 {
+				t.Fatalf("plan(%+v) returned %d events; want 2 or 4", away, len(events))
+			}
+			for index, event := range events {
+				if event.On != (index%2 == 0) {
+					t.Errorf("plan(%+v) event %d = %v; want alternating on and off", away, index, event)
+				}
+				if event.Time.Before(start.Add(-jitter)) || event.Time.After(end.Add(jitter)) {
+					t.Errorf("plan(%+v) event %v outside of %v - %v", away, event, start, end)
+				}
+				if index > 0 && !event.Time.After(events[index-1].Time) {
+					t.Errorf("plan(%+v) event %v not after previous event %v", away, event, events[index-1])
+				}
+			}
+		}
+	}
+
+	invalid := AwayMode{Start: "evening", End: "23:00"}
+	if _, err := invalid.plan(date, rand.New(rand.NewPCG(0, 0))); err == nil {
+		t.Errorf("plan(%+v) should return an error", invalid)
+	}
+}
+
+// runMainLoop serves the requests of the web interface like the main loop
+// until the test ends.
+func runMainLoop(t *testing.T) {
+	stop := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case request := <-mainLoopRequests:
+				request()
+			case <-stop:
+				return
+			}
+		}
+	}()
+	mainLoopRunning.Store(true)
+	t.Cleanup(func() {
+		mainLoopRunning.Store(false)
+		close(stop)
+	})
+}
+
+func TestAwayModeHandler(t *testing.T) {
+	previousConfiguration, previousLights := configuration, lights
+	t.Cleanup(func() { configuration, lights = previousConfiguration, previousLights })
+	configuration = &Configuration{ConfigurationFile: copyTestFile(t, "testdata/config-example.json")}
+	configuration.initializeDefaults()
+	configuration.AwayMode = AwayMode{AssociatedDeviceIDs: deviceIDs(1, 2), Start: "00:00", End: "23:59"}
+	scheduled := &Light{ID: 1, Device: newDeviceID("", 1), Name: "Scheduled", Scheduled: true}
+	unscheduled := &Light{ID: 2, Device: newDeviceID("", 2), Name: "Unscheduled"}
+	lights = []*Light{scheduled, unscheduled}
+
+	enable := func() int {
+		recorder := httptest.NewRecorder()
+		updateAwayModeHandler(recorder, httptest.NewRequest("PUT", "/away", strings.NewReader(`{"enabled": true}`)))
+		return recorder.Code
+	}
+
+	// Requests are rejected until the main loop is running
+	if code := enable(); code != http.StatusServiceUnavailable {
+		t.Errorf("away mode should not be changed before the main loop runs but got status %d", code)
+	}
+	if configuration.AwayMode.Enabled {
+		t.Errorf("away mode should still be disabled")
+	}
+
+	runMainLoop(t)
+	if code := enable(); code != http.StatusOK {
+		t.Fatalf("enabling the away mode failed with status %d", code)
+	}
+	if !configuration.AwayMode.Enabled {
+		t.Errorf("away mode should be enabled")
+	}
+
+	planAwayMode(time.Date(2023, time.November, 6, 0, 1, 0, 0, configuration.TimeLocation()))
+	if len(scheduled.Away) == 0 {
+		t.Errorf("away mode should be planned for the scheduled light")
+	}
+	if len(unscheduled.Away) != 0 {
+		t.Errorf("lights without a schedule should not be switched by the away mode but have events %v", unscheduled.Away)
+	}
+}
@@ -0,0 +1,61 @@
+// MIT License
+//
+// # Copyright (c) 2019 Stefan Wichmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package main
+
+import hue "github.com/stefanwichmann/go.hue"
+
+// LightBackend provides access to the lights and scenes of a bridge.
+// HueBridge implements it on top of the hue API.
+type LightBackend interface {
+	// String returns a human readable name of the backend.
+	String() string
+	// Lights returns all lights known to the backend.
+	Lights() ([]*Light, error)
+	// LightStates returns the current state of all lights by their ID.
+	LightStates() (map[int]hue.LightAttributes, error)
+	// Scenes returns all scenes stored on the backend.
+	Scenes() ([]*hue.Scene, error)
+
+	// qualifier returns the bridge part of the device IDs of all lights.
+	qualifier() string
+	// scheduler returns the scheduler of all requests to the backend.
+	scheduler() *RequestScheduler
+}
+
+// GroupBackend is implemented by backends which can send one command to a
+// group of lights.
+type GroupBackend interface {
+	LightBackend
+	// Groups returns all rooms, zones and light groups of the backend.
+	Groups() ([]HueGroup, error)
+	// SetGroupState sends the given state to all lights of the group.
+	SetGroupState(group HueGroup, state hue.SetLightState) error
+
+	// groupCommands reports whether group actions are enabled.
+	groupCommands() bool
+}
+
+// LightController sends a new state to a single light.
+// It is implemented by hue.Light.
+type LightController interface {
+	SetState(state hue.SetLightState) ([]hue.Result, error)
+}
@@ -29,6 +29,8 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	log "github.com/sirupsen/logrus"
@@ -40,48 +42,194 @@ import (
 // It is used to communicate with all devices.
 type HueBridge struct {
 	bridge   hue.Bridge
+	Name     string
+	Main     bool
 	BridgeIP string
 	Username string
+	ID       string
 	Version  int
+	// Fingerprint of the pinned HTTPS certificate
+	Fingerprint string
+	// GroupCommands enables group actions for lights of the same group
+	GroupCommands bool
+	events        *LightEventStream
+
+	// ready is set once the bridge is initialized. Until then the main
+	// loop ignores the bridge.
+	ready atomic.Bool
+
+	connection      BridgeConnectionState
+	connectionLock  sync.Mutex
+	lastRediscovery time.Time
+	// rediscovering is set while the bridge is searched in the background
+	rediscovering atomic.Bool
+
+	// https is set if go.hue talks to the bridge via HTTPS
+	https bool
+	// client sends all requests which aren't covered by go.hue
+	client   *http.Client
+	groups   hueGroups
+	requests *RequestScheduler
 }
 
 const hueBridgeAppName = "kelvin"
 
+// newHueBridges returns a HueBridge for every bridge of the
+// configuration. The main bridge is always first.
+func newHueBridges(configuration *Configuration) []*HueBridge {
+	var bridges []*HueBridge
+	for _, config := range configuration.configuredBridges() {
+		bridges = append(bridges, &HueBridge{Name: config.Name, Main: config == &configuration.Bridge})
+	}
+	return bridges
+}
+
 // InitializeBridge creates and returns an initialized HueBridge.
 // If you have a valid configuration this will be used. Otherwise a local
 // discovery will be started, followed by a user registration on your bridge.
 func (bridge *HueBridge) InitializeBridge(configuration *Configuration) error {
-	err := bridge.discover(configuration.Bridge.IP)
-	if err != nil {
-		return err
+	config := bridge.configuration(configuration)
+	if config == nil {
+		return fmt.Errorf("bridge %s is not configured", bridge)
 	}
-	configuration.Bridge.IP = bridge.BridgeIP
+	bridge.ID = config.ID
+	bridge.Fingerprint = config.CertificateFingerprint
+	bridge.GroupCommands = config.GroupCommands
 
-	if configuration.Bridge.Username != "" {
-		log.Debugf("⌘ Found bridge username in configuration: %s", configuration.Bridge.Username)
-		bridge.Username = configuration.Bridge.Username
+	if config.Username != "" {
+		err := bridge.discover(config.IP)
+		if err != nil {
+			return err
+		}
+		log.Debugf("⌘ Found bridge username in configuration: %s", redact(config.Username))
+		bridge.Username = config.Username
 	} else {
 		log.Debugf("⌘ No username found in bridge configuration. Starting registration...")
-		err := bridge.register()
+		err := bridge.register(config.IP, configuration.WebInterface)
 		if err != nil {
 			return err
 		}
-		log.Debugf("⌘ Saving new username in bridge configuration: %s", bridge.Username)
-		configuration.Bridge.Username = bridge.Username
+		log.Debugf("⌘ Saving new username in bridge configuration: %s", redact(bridge.Username))
+		config.Username = bridge.Username
 	}
+	config.IP = bridge.BridgeIP
 
-	log.Debugf("⌘ Connecting to bridge %s with username %s", bridge.BridgeIP, bridge.Username)
-	err = bridge.connect()
+	log.Debugf("⌘ Connecting to bridge %s with username %s", bridge.BridgeIP, redact(bridge.Username))
+	err := bridge.connect()
 	if err != nil {
 		return err
 	}
-	log.Println("⌘ Connection to bridge established")
+	config.ID = bridge.ID
+	config.CertificateFingerprint = bridge.Fingerprint
+	log.Printf("⌘ Connection to bridge %s established", bridge)
 	bridge.validateSofwareVersion()
+	bridge.startEventStream()
 
+	if !bridge.Main {
+		return nil
+	}
 	err = bridge.populateSchedule(configuration)
 	return err
 }
 
+// initializeInBackground connects to an additional bridge until it can be
+// reached. The connection is established on a private bridge, which the
+// main loop adopts together with the connection details and the lights.
+func (bridge *HueBridge) initializeInBackground(current *Configuration) {
+	// Work on a copy as the main loop may change the configuration meanwhile
+	initial := *current
+	initial.AdditionalBridges = append([]Bridge(nil), current.AdditionalBridges...)
+	candidate := &HueBridge{Name: bridge.Name, Main: bridge.Main}
+	go func() {
+		for {
+			err := candidate.InitializeBridge(&initial)
+			if err == nil {
+				break
+			}
+			log.Errorf("Could not initialize bridge %s: %v - Retrying...", candidate, err)
+			time.Sleep(10 * time.Second)
+		}
+		connected := *candidate.configuration(&initial)
+		mainLoopRequests <- func() { bridge.attach(candidate, connected) }
+	}()
+}
+
+// attach takes over the connection of the initialized candidate, saves its
+// connection details and adds its lights. It runs on the main loop.
+func (bridge *HueBridge) attach(candidate *HueBridge, connected Bridge) {
+	bridge.adopt(candidate)
+	config := bridge.configuration(configuration)
+	if config != nil {
+		config.IP = connected.IP
+		config.Username = connected.Username
+		config.ID = connected.ID
+		config.CertificateFingerprint = connected.CertificateFingerprint
+		err := configuration.Write()
+		if err != nil {
+			log.Warningf("⚙ Could not save the configuration of bridge %s: %v", bridge, err)
+		}
+		configuration.logHistory(historySourceBridge)
+	}
+	bridge.ready.Store(true)
+
+	// Away mode includes these lights from the next day on
+	attachLights(bridge)
+	updateScenesOfBackend(bridge)
+}
+
+// adopt takes over the connection of a bridge initialized outside of the
+// main loop. The candidate must not be used afterwards.
+func (bridge *HueBridge) adopt(candidate *HueBridge) {
+	bridge.bridge = candidate.bridge
+	bridge.BridgeIP = candidate.BridgeIP
+	bridge.Username = candidate.Username
+	bridge.ID = candidate.ID
+	bridge.Version = candidate.Version
+	bridge.Fingerprint = candidate.Fingerprint
+	bridge.GroupCommands = candidate.GroupCommands
+	bridge.events = candidate.events
+	bridge.https = candidate.https
+	bridge.client = candidate.client
+	bridge.requests = candidate.requests
+
+	state := candidate.connectionState()
+	bridge.connectionLock.Lock()
+	bridge.connection = state
+	bridge.connectionLock.Unlock()
+}
+
+// configuration returns the configuration of the bridge.
+func (bridge *HueBridge) configuration(configuration *Configuration) *Bridge {
+	if bridge.Main {
+		return &configuration.Bridge
+	}
+	for index := range configuration.AdditionalBridges {
+		if configuration.AdditionalBridges[index].Name == bridge.Name {
+			return &configuration.AdditionalBridges[index]
+		}
+	}
+	return nil
+}
+
+// qualifier returns the name used in the light references of the
+// configuration. It is empty for the main bridge.
+func (bridge *HueBridge) qualifier() string {
+	if bridge.Main {
+		return ""
+	}
+	return bridge.Name
+}
+
+func (bridge *HueBridge) String() string {
+	if bridge.Name != "" {
+		return bridge.Name
+	}
+	if bridge.BridgeIP != "" {
+		return bridge.BridgeIP
+	}
+	return "main bridge"
+}
+
 // Lights return all known lights on your bridge.
 func (bridge *HueBridge) Lights() ([]*Light, error) {
 	var lights []*Light
@@ -97,7 +245,11 @@ func (bridge *HueBridge) Lights() ([]*Light, error) {
 			return lights, err
 		}
 
-		light.HueLight.HueLight = *hueLight
+		light.Device = newDeviceID(bridge.qualifier(), light.ID)
+		light.Bridge = bridge.Name
+		light.backend = bridge
+		light.HueLight.controller = hueLight
+		light.HueLight.scheduler = bridge.requests
 		light.HueLight.initialize(hueLight.Attributes)
 		light.Name = light.HueLight.Name
 		light.Reachable = light.HueLight.Reachable
@@ -110,10 +262,23 @@ func (bridge *HueBridge) Lights() ([]*Light, error) {
 	return lights, nil
 }
 
-// LightStates returns the current state for lights on the bridge
+// LightStates returns the current state for lights on the bridge. The
+// states are taken from the event stream if it is connected.
 func (bridge *HueBridge) LightStates() (map[int]hue.LightAttributes, error) {
+	if bridge.events != nil {
+		if states, connected := bridge.events.LightStates(); connected {
+			bridge.recordContact(nil)
+			return states, nil
+		}
+	}
+
 	var states = make(map[int]hue.LightAttributes)
-	hueLights, err := bridge.bridge.GetAllLights()
+	var hueLights []*hue.Light
+	err := bridge.requests.do(priorityUpdate, func() (err error) {
+		hueLights, err = bridge.bridge.GetAllLights()
+		return err
+	})
+	bridge.recordContact(err)
 	if err != nil {
 		return states, err
 	}
@@ -130,63 +295,90 @@ func (bridge *HueBridge) LightStates() (map[int]hue.LightAttributes, error) {
 	return states, nil
 }
 
+// Scenes returns all scenes stored on the bridge.
+func (bridge *HueBridge) Scenes() ([]*hue.Scene, error) {
+	var scenes []*hue.Scene
+	err := bridge.requests.do(priorityHousekeeping, func() (err error) {
+		scenes, err = bridge.bridge.AllScenes()
+		return err
+	})
+	return scenes, err
+}
+
+func (bridge *HueBridge) scheduler() *RequestScheduler {
+	return bridge.requests
+}
+
+// startEventStream subscribes to the light events of the hue API v2.
+// Older bridges don't support it, their light states will be polled.
+func (bridge *HueBridge) startEventStream() {
+	if bridge.events != nil {
+		bridge.events.close()
+		bridge.events = nil
+	}
+	if *flagDisableEventStream {
+		log.Debugf("⌘ Event stream disabled. Polling light states...")
+		return
+	}
+	if bridge.Version < 2 {
+		log.Debugf("⌘ Bridge does not support the hue API v2. Polling light states...")
+		return
+	}
+
+	if bridge.Fingerprint == "" {
+		log.Debugf("⌘ No certificate pinned for the hue API v2. Polling light states...")
+		return
+	}
+
+	client := newClipV2Client(bridge.BridgeIP, bridge.Username, bridge.Fingerprint)
+	_, err := client.resources("light")
+	if err != nil {
+		log.Printf("⌘ Bridge does not support the hue API v2 (%v). Polling light states...", err)
+		return
+	}
+	bridge.events = newLightEventStream(client, lightEvents)
+	bridge.events.start()
+}
+
 func (bridge *HueBridge) discover(ip string) error {
 	if ip != "" {
 		// we have a known IP address. Validate if it points to a reachable bridge
 		bridge.BridgeIP = ip
 		err := bridge.validateBridge()
-		if err != nil {
-			return err
+		if err != nil && bridge.ID != "" {
+			// The bridge might have a new address
+			log.Printf("⌘ Bridge not found at %s (%v). Starting rediscovery...", ip, err)
+			bridge.BridgeIP, err = bridge.rediscover()
+			if err == nil {
+				err = bridge.validateBridge()
+			}
 		}
-		return nil
-	}
-	log.Debugf("⌘ Starting bridge discovery")
-	bridges, err := hue.DiscoverBridges(false)
-	if err != nil {
-		bridge.BridgeIP = ""
 		return err
 	}
-	if len(bridges) == 0 {
-		bridge.BridgeIP = ""
-		return errors.New("Bridge discovery failed. Please configure manually in config.json")
-	}
-	for _, candidate := range bridges {
-		bridge.BridgeIP = candidate.IpAddr
-		err := bridge.validateBridge()
-		if err == nil {
-			log.Printf("⌘ Found bridge at %s", bridge.BridgeIP)
-			return nil
-		}
-	}
-	bridge.BridgeIP = ""
-	return errors.New("Bridge discovery failed. Please configure manually in config.json")
+	log.Debugf("⌘ Starting bridge discovery")
+	address, err := findBridge(func(address string) bool {
+		bridge.BridgeIP = address
+		return bridge.validateBridge() == nil
+	})
+	bridge.BridgeIP = address
+	return err
 }
 
-func (bridge *HueBridge) register() error {
-	if bridge.BridgeIP == "" {
-		return errors.New("registration at bridge not possible because no IP is configured. Start discovery first or enter manually")
+// register pairs Kelvin with the bridge at the given or a discovered
+// address. The pairing can be driven by the web interface. Without it
+// every attempt waiting for the link button is restarted after a timeout.
+func (bridge *HueBridge) register(ip string, webInterface WebInterface) error {
+	if webInterface.Enabled {
+		log.Printf("⌘ Open the web interface on port %d to choose the bridge to pair with.", webInterface.Port)
 	}
-
-	bridge.bridge = *hue.NewBridge(bridge.BridgeIP, "")
-	log.Printf("⌘ Starting user registration.")
-	log.Warningf("⌘ PLEASE PUSH THE BLUE BUTTON ON YOUR HUE BRIDGE")
-	for {
-		time.Sleep(5 * time.Second)
-
-		// try user creation, will fail if the button wasn't pressed.
-		err := bridge.bridge.CreateUser(hueBridgeAppName)
-		if err != nil {
-			log.Debugf("⌘ Button wasn't pressed yet. Waiting...")
-			continue
-		}
-
-		if bridge.bridge.Username != "" {
-			// registration successful
-			bridge.Username = bridge.bridge.Username
-			log.Printf("⌘ User registration successful.")
-			return nil
-		}
+	address, username, err := pairing.run(bridge.String(), ip, !webInterface.Enabled)
+	if err != nil {
+		return err
 	}
+	bridge.BridgeIP = address
+	bridge.Username = username
+	log.Printf("⌘ Paired with bridge at %s", address)
+	return bridge.validateBridge()
 }
 
 func (bridge *HueBridge) connect() error {
@@ -198,12 +390,23 @@ func (bridge *HueBridge) connect() error {
 		return errors.New("no username on bridge configured")
 	}
 	bridge.bridge = *hue.NewBridge(bridge.BridgeIP, bridge.Username)
+	bridge.https = false
+
+	// The ID reported by the bridge can't be trusted before its
+	// certificate is verified. Unknown IDs are trusted on first use.
+	configuredID := bridge.ID
 
 	// Test bridge
 	configuration, err := bridge.bridge.Configuration()
 	if err != nil {
+		bridge.recordContact(err)
 		return err
 	}
+	bridge.ID = configuration.BridgeId
+	bridge.recordContact(nil)
+	if configuredID == "" {
+		configuredID = bridge.ID
+	}
 
 	// Enable HTTPS if supported
 	// TODO HTTPS supported on Model BSB001?
@@ -211,15 +414,31 @@ func (bridge *HueBridge) connect() error {
 	if err != nil {
 		return err
 	}
-	if configuration.ModelId == "BSB002" && swversion >= 1802201122 && !*flagDisableHTTPS {
+	httpsSupported := configuration.ModelId == "BSB002" && swversion >= 1802201122 && !*flagDisableHTTPS
+	if httpsSupported {
+		err = bridge.pinCertificate(configuredID)
+		if err != nil {
+			return err
+		}
+	}
+	bridge.client = newBridgeClient(bridge.Fingerprint)
+	bridge.bridge.SetClient(bridge.client)
+	if httpsSupported {
 		bridge.bridge.EnableHTTPS(true)
+		bridge.https = true
 		log.Debugf("⌘ Enabled HTTPS for the bridge connection")
 	}
 
-	if !*flagDisableRateLimiting {
-		bridge.bridge.EnableRateLimiting(timeBetweenHueAPICalls)
+	interval := timeBetweenHueAPICalls
+	if *flagDisableRateLimiting {
+		interval = 0
+	} else {
 		log.Debugf("⌘ Enabled rate limiting with %s between API calls", timeBetweenHueAPICalls)
 	}
+	if bridge.requests == nil {
+		bridge.requests = newRequestScheduler(bridge.String(), interval)
+	}
+	bridge.requests.setInterval(interval)
 
 	log.Debugf("⌘ Connected to bridge \"%s\" (Model: %s, API version: %s)", configuration.Name, configuration.ModelId, configuration.APIVersion)
 	return nil
@@ -244,9 +463,9 @@ func (bridge *HueBridge) populateSchedule(configuration *Configuration) error {
 	if err != nil {
 		return err
 	}
-	var lightIDs []int
+	var lightIDs []DeviceID
 	for _, light := range lights {
-		lightIDs = append(lightIDs, light.ID)
+		lightIDs = append(lightIDs, light.Device)
 	}
 	configuration.Schedules[0].AssociatedDeviceIDs = lightIDs
 	return nil
@@ -0,0 +1,207 @@
+// MIT License
+//
+// # Copyright (c) 2019 Stefan Wichmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	hue "github.com/stefanwichmann/go.hue"
+)
+
+// connectionLostThreshold is the number of consecutive failed requests
+// after which the bridge is considered lost and will be rediscovered.
+const connectionLostThreshold = 10
+
+// rediscoveryInterval limits how often a lost bridge is searched for.
+const rediscoveryInterval = 1 * time.Minute
+
+// BridgeConnectionState describes the connection to a bridge as reported
+// by the health endpoint.
+type BridgeConnectionState struct {
+	Name                string    `json:"name,omitempty"`
+	Main                bool      `json:"main"`
+	ID                  string    `json:"id,omitempty"`
+	IP                  string    `json:"ip"`
+	Connected           bool      `json:"connected"`
+	ConsecutiveFailures int       `json:"consecutiveFailures"`
+	LastContact         time.Time `json:"lastContact"`
+	LastError           string    `json:"lastError,omitempty"`
+}
+
+// recordContact updates the connection state with the result of a
+// request to the bridge.
+func (bridge *HueBridge) recordContact(err error) {
+	bridge.connectionLock.Lock()
+	defer bridge.connectionLock.Unlock()
+	if err != nil {
+		bridge.connection.ConsecutiveFailures++
+		bridge.connection.LastError = err.Error()
+		if bridge.connection.ConsecutiveFailures >= connectionLostThreshold {
+			bridge.connection.Connected = false
+		}
+		return
+	}
+	bridge.connection.ID = bridge.ID
+	bridge.connection.IP = bridge.BridgeIP
+	bridge.connection.Connected = true
+	bridge.connection.ConsecutiveFailures = 0
+	bridge.connection.LastContact = time.Now()
+	bridge.connection.LastError = ""
+}
+
+// connectionState returns the current state of the bridge connection.
+func (bridge *HueBridge) connectionState() BridgeConnectionState {
+	bridge.connectionLock.Lock()
+	defer bridge.connectionLock.Unlock()
+	state := bridge.connection
+	state.Name = bridge.Name
+	state.Main = bridge.Main
+	return state
+}
+
+// String returns the name of the bridge or its last known address.
+func (state BridgeConnectionState) String() string {
+	if state.Name != "" {
+		return state.Name
+	}
+	if state.IP != "" {
+		return state.IP
+	}
+	return "main bridge"
+}
+
+// connectionLost reports whether the bridge stopped responding.
+func (bridge *HueBridge) connectionLost() bool {
+	bridge.connectionLock.Lock()
+	defer bridge.connectionLock.Unlock()
+	return bridge.connection.ConsecutiveFailures >= connectionLostThreshold
+}
+
+// reconnect searches a lost bridge in the local network. The search takes
+// several seconds and runs in the background. If the bridge is found at a
+// new address, the main loop connects to it and saves the address in the
+// configuration.
+func (bridge *HueBridge) reconnect() {
+	if bridge.rediscovering.Load() || time.Since(bridge.lastRediscovery) < rediscoveryInterval {
+		return
+	}
+	bridge.lastRediscovery = time.Now()
+	bridge.rediscovering.Store(true)
+
+	log.Printf("⌘ Lost connection to bridge %s. Starting rediscovery...", bridge)
+	// The main loop may change the bridge while it is searched
+	lost := &HueBridge{Name: bridge.Name, BridgeIP: bridge.BridgeIP, ID: bridge.ID, Username: bridge.Username}
+	go func() {
+		defer bridge.rediscovering.Store(false)
+		address, err := lost.rediscover()
+		if err != nil {
+			log.Warningf("⌘ Rediscovery of bridge %s failed: %v", lost, err)
+			return
+		}
+		mainLoopRequests <- func() { bridge.moveTo(address) }
+	}()
+}
+
+// moveTo connects to the bridge at the given address and saves the address
+// in the configuration. It runs on the main loop.
+func (bridge *HueBridge) moveTo(address string) error {
+	if address == bridge.BridgeIP {
+		log.Printf("⌘ Bridge %s did not change its address. Waiting for it to respond...", bridge)
+		return nil
+	}
+
+	previous := bridge.BridgeIP
+	bridge.BridgeIP = address
+	err := bridge.connect()
+	if err != nil {
+		log.Warningf("⌘ Could not connect to bridge at %s: %v", address, err)
+		bridge.BridgeIP = previous
+		return err
+	}
+	bridge.startEventStream()
+	log.Printf("⌘ Bridge %s moved from %s to %s. Connection reestablished", bridge, previous, address)
+
+	config := bridge.configuration(configuration)
+	if config == nil || config.IP == address {
+		return nil
+	}
+	config.IP = address
+	config.ID = bridge.ID
+	config.CertificateFingerprint = bridge.Fingerprint
+	err = configuration.Write()
+	if err != nil {
+		log.Warningf("⚙ Could not save the new address of bridge %s: %v", bridge, err)
+		return err
+	}
+	configuration.logHistory(historySourceRediscovery)
+	return nil
+}
+
+// rediscover returns the current address of the bridge. The bridge is
+// identified by its ID or, if the ID is unknown, by accepting the username.
+func (bridge *HueBridge) rediscover() (string, error) {
+	address, err := findBridge(func(address string) bool {
+		if bridge.ID == "" {
+			candidate := hue.NewBridge(address, bridge.Username)
+			_, err := candidate.Configuration()
+			return err == nil
+		}
+		id, err := bridgeID(address)
+		if err != nil {
+			log.Debugf("⌘ Could not read the ID of the bridge at %s: %v", address, err)
+			return false
+		}
+		return strings.EqualFold(id, bridge.ID)
+	})
+	if err != nil {
+		return "", fmt.Errorf("bridge %s not found in the local network", bridge)
+	}
+	return address, nil
+}
+
+// bridgeID returns the ID of the bridge at the given address. The short
+// configuration of a bridge is available without a username.
+func bridgeID(address string) (string, error) {
+	client := http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Get("http://" + address + "/api/config")
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var config struct {
+		BridgeID string `json:"bridgeid"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&config)
+	if err != nil {
+		return "", err
+	}
+	if config.BridgeID == "" {
+		return "", fmt.Errorf("no bridge ID reported by %s", address)
+	}
+	return config.BridgeID, nil
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func stubBridgeDiscovery(t *testing.T, addresses ...string) {
+	previous := discoveryMethods
+	discoveryMethods = []discoveryMethod{{name: "test", discover: func(time.Duration) ([]string, error) { return addresses, nil }}}
+	t.Cleanup(func() { discoveryMethods = previous })
+}
+
+func loseConnection(t *testing.T, bridge *HueBridge) {
+	t.Helper()
+	_, err := bridge.LightStates()
+	if err == nil {
+		t.Fatal("requests to a lost bridge should fail")
+	}
+	// The scheduler backs off after every error, so the remaining failures
+	// are recorded without waiting for further requests
+	for i := 1; i < connectionLostThreshold; i++ {
+		bridge.recordContact(err)
+	}
+	if !bridge.connectionLost() || bridge.connectionState().Connected {
+		t.Fatalf("bridge should be lost after %d failed requests", connectionLostThreshold)
+	}
+}
+
+// waitForRediscovery waits until the background search for the bridge is
+// finished and its result was passed to the main loop.
+func waitForRediscovery(t *testing.T, bridge *HueBridge) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for bridge.rediscovering.Load() {
+		if time.Now().After(deadline) {
+			t.Fatalf("rediscovery of bridge %s did not finish", bridge)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRediscoverBridgeAfterAddressChange(t *testing.T) {
+	old := newFakeBridge(t)
+	bridge := old.connect(t)
+	if bridge.ID != old.id {
+		t.Errorf("bridge ID should be %s but is %s", old.id, bridge.ID)
+	}
+
+	previous := configuration
+	t.Cleanup(func() { configuration = previous })
+	c := Configuration{ConfigurationFile: copyTestFile(t, "testdata/config-example.json")}
+	if err := c.Read(); err != nil {
+		t.Fatal(err)
+	}
+	c.Bridge.IP = old.address()
+	c.Bridge.ID = old.id
+	configuration = &c
+	runMainLoop(t)
+
+	// The bridge gets a new address, another bridge is found in the network as well
+	old.server.Close()
+	other := newFakeBridge(t)
+	other.id = "001788FFFE000000"
+	moved := newFakeBridge(t)
+	moved.addLight("1", "Desk", "Extended color light")
+	stubBridgeDiscovery(t, other.address(), moved.address())
+
+	loseConnection(t, bridge)
+	bridge.reconnect()
+	waitForRediscovery(t, bridge)
+	onMainLoop(func(http.ResponseWriter, *http.Request) {
+		if bridge.BridgeIP != moved.address() {
+			t.Errorf("bridge should have been found at %s but uses %s", moved.address(), bridge.BridgeIP)
+		}
+	})(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	if states, err := bridge.LightStates(); err != nil || len(states) != 1 {
+		t.Errorf("light states should be read from the new address (States: %v, Error: %v)", states, err)
+	}
+	if state := bridge.connectionState(); !state.Connected || state.IP != moved.address() {
+		t.Errorf("connection state should report the new address: %+v", state)
+	}
+
+	saved := Configuration{ConfigurationFile: c.ConfigurationFile}
+	if err := saved.Read(); err != nil {
+		t.Fatal(err)
+	}
+	if saved.Bridge.IP != moved.address() {
+		t.Errorf("new bridge address should be saved but configuration contains %s", saved.Bridge.IP)
+	}
+}
+
+func TestRediscoverBridgeWithoutID(t *testing.T) {
+	other := newFakeBridge(t)
+	moved := newFakeBridge(t)
+	other.username = "another-username"
+	stubBridgeDiscovery(t, other.address(), moved.address())
+
+	// Only the lost bridge accepts the username
+	bridge := &HueBridge{Username: fakeBridgeUsername}
+	address, err := bridge.rediscover()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if address != moved.address() {
+		t.Errorf("bridge accepting the username should be found at %s but was found at %s", moved.address(), address)
+	}
+}
+
+func TestReconnectIsRateLimited(t *testing.T) {
+	fake := newFakeBridge(t)
+	bridge := fake.connect(t)
+	fake.server.Close()
+	stubBridgeDiscovery(t)
+
+	var searches atomic.Int32
+	previous := discoveryMethods
+	discoveryMethods = []discoveryMethod{{name: "test", discover: func(time.Duration) ([]string, error) {
+		searches.Add(1)
+		return nil, nil
+	}}}
+	t.Cleanup(func() { discoveryMethods = previous })
+
+	loseConnection(t, bridge)
+	bridge.reconnect()
+	waitForRediscovery(t, bridge)
+	bridge.reconnect()
+	waitForRediscovery(t, bridge)
+	if searches.Load() != 1 {
+		t.Errorf("rediscovery should not be repeated within %v but searched %d times", rediscoveryInterval, searches.Load())
+	}
+}
+
+func TestReconnectDoesNotBlock(t *testing.T) {
+	fake := newFakeBridge(t)
+	bridge := fake.connect(t)
+	fake.server.Close()
+	release := make(chan struct{})
+	previous := discoveryMethods
+	discoveryMethods = []discoveryMethod{{name: "test", discover: func(time.Duration) ([]string, error) {
+		<-release
+		return nil, nil
+	}}}
+	t.Cleanup(func() { discoveryMethods = previous })
+
+	loseConnection(t, bridge)
+	returned := make(chan struct{})
+	go func() {
+		bridge.reconnect()
+		close(returned)
+	}()
+	select {
+	case <-returned:
+	case <-time.After(time.Second):
+		t.Errorf("reconnect should search the bridge in the background")
+	}
+	close(release)
+	waitForRediscovery(t, bridge)
+}
+
+func TestHealthReportsBridgeConnection(t *testing.T) {
+	fake := newFakeBridge(t)
+	bridge := fake.connect(t)
+	previous := bridges
+	bridges = []*HueBridge{bridge}
+	t.Cleanup(func() { bridges = previous })
+
+	health := func() (status string, connected bool) {
+		recorder := httptest.NewRecorder()
+		healthHandler(recorder, httptest.NewRequest("GET", "/health", nil))
+		if recorder.Code != http.StatusOK {
+			t.Errorf("health endpoint returned %d", recorder.Code)
+		}
+		var response struct {
+			Status  string                  `json:"status"`
+			Bridges []BridgeConnectionState `json:"bridges"`
+		}
+		if err := json.NewDecoder(strings.NewReader(recorder.Body.String())).Decode(&response); err != nil {
+			t.Fatal(err)
+		}
+		if len(response.Bridges) != 1 {
+			t.Fatalf("health should report one bridge but reports %d", len(response.Bridges))
+		}
+		return response.Status, response.Bridges[0].Connected
+	}
+
+	if status, connected := health(); status != "ok" || !connected {
+		t.Errorf("connected bridge should be healthy (Status: %s, Connected: %v)", status, connected)
+	}
+	fake.server.Close()
+	loseConnection(t, bridge)
+	if status, connected := health(); status != "degraded" || connected {
+		t.Errorf("lost bridge should be reported (Status: %s, Connected: %v)", status, connected)
+	}
+}
+
+// waitForAttachment waits until the main loop attached the lights of the
+// given bridge.
+func waitForAttachment(t *testing.T, bridge *HueBridge) {
+	t.Helper()
+	attached := func() (attached bool) {
+		done := make(chan struct{})
+		mainLoopRequests <- func() {
+			attached = len(lights) > 0 && bridge.ready.Load()
+			close(done)
+		}
+		<-done
+		return attached
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for !attached() {
+		if time.Now().After(deadline) {
+			t.Fatalf("lights of bridge %s were not attached", bridge.Name)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestAdditionalBridgeIsAttachedInBackground(t *testing.T) {
+	disableRateLimiting := *flagDisableRateLimiting
+	*flagDisableRateLimiting = true
+	previousConfiguration, previousLights, previousBridges := configuration, lights, bridges
+	t.Cleanup(func() {
+		*flagDisableRateLimiting = disableRateLimiting
+		configuration, lights, bridges = previousConfiguration, previousLights, previousBridges
+	})
+	fake := newFakeBridge(t)
+	fake.addLight("3", "Garage", "Color temperature light")
+
+	configuration = &Configuration{ConfigurationFile: copyTestFile(t, "testdata/config-example.json")}
+	if err := configuration.parse(); err != nil {
+		t.Fatal(err)
+	}
+	configuration.AdditionalBridges = []Bridge{{Name: "garage", IP: fake.address(), Username: fakeBridgeUsername}}
+	garage := &HueBridge{Name: "garage"}
+	lights, bridges = nil, []*HueBridge{garage}
+	runMainLoop(t)
+
+	garage.initializeInBackground(configuration)
+	waitForAttachment(t, garage)
+
+	done := make(chan struct{})
+	mainLoopRequests <- func() {
+		if lights[0].Device != newDeviceID("garage", 3) || lights[0].backend != garage {
+			t.Errorf("unexpected light %s of bridge %v", lights[0].Device, lights[0].backend)
+		}
+		if configuration.AdditionalBridges[0].ID != fake.id {
+			t.Errorf("the bridge ID should be saved in the configuration but got %q", configuration.AdditionalBridges[0].ID)
+		}
+		close(done)
+	}
+	<-done
+}
+
+func TestHealthDuringBackgroundInitialization(t *testing.T) {
+	disableRateLimiting := *flagDisableRateLimiting
+	*flagDisableRateLimiting = true
+	previousConfiguration, previousLights, previousBridges := configuration, lights, bridges
+	t.Cleanup(func() {
+		*flagDisableRateLimiting = disableRateLimiting
+		configuration, lights, bridges = previousConfiguration, previousLights, previousBridges
+	})
+	fake := newFakeBridge(t)
+	fake.addLight("3", "Garage", "Color temperature light")
+	fake.setDelay(20 * time.Millisecond)
+
+	configuration = &Configuration{ConfigurationFile: copyTestFile(t, "testdata/config-example.json")}
+	if err := configuration.parse(); err != nil {
+		t.Fatal(err)
+	}
+	configuration.AdditionalBridges = []Bridge{{Name: "garage", IP: fake.address(), Username: fakeBridgeUsername}}
+	garage := &HueBridge{Name: "garage"}
+	lights, bridges = nil, []*HueBridge{garage}
+	runMainLoop(t)
+
+	// Run with -race to check the endpoints and the main loop against the
+	// initialization
+	stop := make(chan struct{})
+	polled := make(chan int)
+	go func() {
+		requests := 0
+		for {
+			select {
+			case <-stop:
+				polled <- requests
+				return
+			default:
+			}
+			healthHandler(httptest.NewRecorder(), httptest.NewRequest("GET", "/health", nil))
+			metricsHandler(httptest.NewRecorder(), httptest.NewRequest("GET", "/metrics", nil))
+			done := make(chan struct{})
+			mainLoopRequests <- func() {
+				_ = garage.BridgeIP + garage.ID + garage.Username
+				close(done)
+			}
+			<-done
+			requests++
+		}
+	}()
+	garage.initializeInBackground(configuration)
+	waitForAttachment(t, garage)
+	close(stop)
+	if requests := <-polled; requests == 0 {
+		t.Error("health endpoint should be polled during the initialization")
+	}
+
+	state := garage.connectionState()
+	if !state.Connected || state.IP != fake.address() || state.ID != fake.id {
+		t.Errorf("health should report the attached bridge but reports %+v", state)
+	}
+}
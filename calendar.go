@@ -0,0 +1,380 @@
+// MIT License
+//
+// # Copyright (c) 2019 Stefan Wichmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const calendarUpdateInterval = 1 * time.Minute
+const upcomingCalendarEventsDuration = 7 * 24 * time.Hour
+
+// CalendarEvent represents a single event of an iCalendar file.
+type CalendarEvent struct {
+	Summary string
+	Start   time.Time
+	End     time.Time
+}
+
+// ScheduledCalendarEvent represents a calendar event matching a rule.
+type ScheduledCalendarEvent struct {
+	CalendarEvent
+	Rule CalendarRule
+}
+
+func (event *CalendarEvent) activeAt(timestamp time.Time) bool {
+	return !timestamp.Before(event.Start) && timestamp.Before(event.End)
+}
+
+// parseICalendar reads all events of an iCalendar (RFC 5545) stream.
+// Times without time zone are interpreted in the given location.
+// Recurrence rules are not expanded.
+func parseICalendar(reader io.Reader, location *time.Location) ([]CalendarEvent, error) {
+	lines, err := unfoldICalendarLines(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	var events []CalendarEvent
+	var event *CalendarEvent
+	var duration time.Duration
+	var allDay bool
+	for number, line := range lines {
+		name, params, value, ok := parseICalendarLine(line)
+		if !ok {
+			continue
+		}
+
+		switch {
+		case name == "BEGIN" && value == "VEVENT":
+			event = &CalendarEvent{}
+			duration = 0
+			allDay = false
+		case event == nil:
+			continue
+		case name == "END" && value == "VEVENT":
+			if event.Start.IsZero() {
+				log.Debugf("📅 Ignoring calendar event %q without start", event.Summary)
+				event = nil
+				continue
+			}
+			if event.End.IsZero() {
+				event.End = event.Start.Add(duration)
+				if duration == 0 && allDay {
+					event.End = startOfNextDay(event.Start)
+				}
+			}
+			events = append(events, *event)
+			event = nil
+		case name == "SUMMARY":
+			event.Summary = unescapeICalendarText(value)
+		case name == "DTSTART" || name == "DTEND":
+			t, err := parseICalendarTime(value, params, location)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", number+1, err)
+			}
+			if name == "DTSTART" {
+				event.Start = t
+				allDay = params["VALUE"] == "DATE" || len(value) == 8
+			} else {
+				event.End = t
+			}
+		case name == "DURATION":
+			duration, err = parseICalendarDuration(value)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", number+1, err)
+			}
+		}
+	}
+
+	sort.Slice(events, func(i, j int) bool { return events[i].Start.Before(events[j].Start) })
+	return events, nil
+}
+
+func unfoldICalendarLines(reader io.Reader) ([]string, error) {
+	var lines []string
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines, scanner.Err()
+}
+
+func parseICalendarLine(line string) (name string, params map[string]string, value string, ok bool) {
+	separator := strings.Index(line, ":")
+	if separator == -1 {
+		return "", nil, "", false
+	}
+	tokens := strings.Split(line[:separator], ";")
+	params = make(map[string]string)
+	for _, param := range tokens[1:] {
+		key, val, _ := strings.Cut(param, "=")
+		params[strings.ToUpper(key)] = strings.Trim(val, "\"")
+	}
+	return strings.ToUpper(tokens[0]), params, line[separator+1:], true
+}
+
+func parseICalendarTime(value string, params map[string]string, location *time.Location) (time.Time, error) {
+	if params["VALUE"] == "DATE" || len(value) == 8 {
+		t, err := time.Parse("20060102", value)
+		if err != nil {
+			return t, err
+		}
+		return localTime(t.Year(), t.Month(), t.Day(), 0, 0, 0, location), nil
+	}
+	if strings.HasSuffix(value, "Z") {
+		return time.Parse("20060102T150405Z", value)
+	}
+	if tzid, found := params["TZID"]; found {
+		l, err := time.LoadLocation(tzid)
+		if err != nil {
+			log.Debugf("📅 Unknown time zone %q in calendar. Using %s...", tzid, location)
+		} else {
+			location = l
+		}
+	}
+	t, err := time.Parse("20060102T150405", value)
+	if err != nil {
+		return t, err
+	}
+	return localTime(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), location), nil
+}
+
+func parseICalendarDuration(value string) (time.Duration, error) {
+	// e.g. PT8H30M, P1D, -PT15M
+	raw := value
+	sign := time.Duration(1)
+	if strings.HasPrefix(raw, "-") {
+		sign = -1
+	}
+	raw = strings.TrimLeft(raw, "+-")
+	if !strings.HasPrefix(raw, "P") {
+		return 0, fmt.Errorf("invalid duration %q", value)
+	}
+	raw = raw[1:]
+
+	var duration time.Duration
+	units := map[byte]time.Duration{'W': 7 * 24 * time.Hour, 'D': 24 * time.Hour, 'H': time.Hour, 'M': time.Minute, 'S': time.Second}
+	number := 0
+	digits := false
+	for i := 0; i < len(raw); i++ {
+		c := raw[i]
+		switch {
+		case c == 'T':
+			continue
+		case c >= '0' && c <= '9':
+			number = number*10 + int(c-'0')
+			digits = true
+		case units[c] != 0 && digits:
+			duration += time.Duration(number) * units[c]
+			number = 0
+			digits = false
+		default:
+			return 0, fmt.Errorf("invalid duration %q", value)
+		}
+	}
+	return sign * duration, nil
+}
+
+func unescapeICalendarText(text string) string {
+	replacer := strings.NewReplacer(`\n`, " ", `\N`, " ", `\,`, ",", `\;`, ";", `\\`, `\`)
+	return replacer.Replace(text)
+}
+
+// matchingRule returns the first rule which applies to the given schedule
+// for one of the given events.
+func (calendar *Calendar) matchingRule(schedule string, events []CalendarEvent) (CalendarRule, bool) {
+	for _, event := range events {
+		for _, rule := range calendar.Rules {
+			if rule.matches(event) && (len(rule.Schedules) == 0 || containsString(rule.Schedules, schedule)) {
+				return rule, true
+			}
+		}
+	}
+	return CalendarRule{}, false
+}
+
+func (rule *CalendarRule) matches(event CalendarEvent) bool {
+	return rule.Keyword != "" && strings.Contains(strings.ToLower(event.Summary), strings.ToLower(rule.Keyword))
+}
+
+// CalendarWatcher keeps the events of an iCalendar file up to date and
+// reports whenever the set of active events changes.
+type CalendarWatcher struct {
+	file     string
+	location *time.Location
+	rules    []CalendarRule
+	running  bool
+	lock     sync.RWMutex
+	modified time.Time
+	events   []CalendarEvent
+	active   string
+	updates  chan struct{}
+}
+
+var calendarWatcher = &CalendarWatcher{updates: make(chan struct{}, 1)}
+
+// start watches the given calendar file in the background. Calling start
+// again replaces the calendar, an empty file disables the calendar.
+func (watcher *CalendarWatcher) start(calendar Calendar, location *time.Location, interval time.Duration) {
+	watcher.lock.Lock()
+	watcher.file = calendar.File
+	watcher.rules = calendar.Rules
+	watcher.location = location
+	watcher.modified = time.Time{}
+	watcher.events = nil
+	running := watcher.running
+	watcher.running = true
+	watcher.lock.Unlock()
+
+	watcher.refresh(time.Now())
+	if running {
+		return
+	}
+	go func() {
+		for {
+			time.Sleep(interval)
+			watcher.refresh(time.Now())
+		}
+	}()
+}
+
+func (watcher *CalendarWatcher) refresh(now time.Time) {
+	watcher.lock.RLock()
+	file := watcher.file
+	rules := watcher.rules
+	watcher.lock.RUnlock()
+
+	if file != "" {
+		err := watcher.reload(file)
+		if err != nil {
+			log.Warningf("📅 Could not read calendar %s: %v", file, err)
+		}
+	}
+
+	// Did the active events change?
+	var active []string
+	for _, event := range watcher.activeEvents(now) {
+		for _, rule := range rules {
+			if rule.matches(event) {
+				active = append(active, fmt.Sprintf("%s@%v", event.Summary, event.Start))
+				break
+			}
+		}
+	}
+	signature := strings.Join(active, "|")
+
+	watcher.lock.Lock()
+	changed := signature != watcher.active
+	watcher.active = signature
+	watcher.lock.Unlock()
+
+	if changed {
+		log.Printf("📅 Active calendar events changed: %v", active)
+		select {
+		case watcher.updates <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (watcher *CalendarWatcher) reload(filename string) error {
+	info, err := os.Stat(filename)
+	if err != nil {
+		return err
+	}
+
+	watcher.lock.RLock()
+	unchanged := info.ModTime().Equal(watcher.modified)
+	location := watcher.location
+	watcher.lock.RUnlock()
+	if unchanged {
+		return nil
+	}
+
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	events, err := parseICalendar(file, location)
+	if err != nil {
+		return err
+	}
+
+	watcher.lock.Lock()
+	watcher.events = events
+	watcher.modified = info.ModTime()
+	watcher.lock.Unlock()
+	log.Printf("📅 Loaded %d events from calendar %s", len(events), filename)
+	return nil
+}
+
+// activeEvents returns all events active at the given time.
+func (watcher *CalendarWatcher) activeEvents(timestamp time.Time) []CalendarEvent {
+	watcher.lock.RLock()
+	defer watcher.lock.RUnlock()
+	var active []CalendarEvent
+	for _, event := range watcher.events {
+		if event.activeAt(timestamp) {
+			active = append(active, event)
+		}
+	}
+	return active
+}
+
+// upcomingEvents returns all events matching a rule which are active or
+// start within the given duration.
+func (watcher *CalendarWatcher) upcomingEvents(timestamp time.Time, duration time.Duration) []ScheduledCalendarEvent {
+	watcher.lock.RLock()
+	defer watcher.lock.RUnlock()
+	var upcoming []ScheduledCalendarEvent
+	for _, event := range watcher.events {
+		if !event.End.After(timestamp) || event.Start.After(timestamp.Add(duration)) {
+			continue
+		}
+		event.Start = event.Start.In(watcher.location)
+		event.End = event.End.In(watcher.location)
+		for _, rule := range watcher.rules {
+			if rule.matches(event) {
+				upcoming = append(upcoming, ScheduledCalendarEvent{event, rule})
+				break
+			}
+		}
+	}
+	return upcoming
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestParseICalendar(t *testing.T) {
+	location := loadLocation(t, "Europe/Berlin")
+	file, err := os.Open("testdata/calendar.ics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	events, err := parseICalendar(file, location)
+	if err != nil {
+		t.Fatalf("parseICalendar returned error: %v", err)
+	}
+
+	expected := []struct {
+		summary string
+		start   string
+		end     string
+	}{
+		{"Early shift", "2023-11-04 06:00 +0100", "2023-11-04 14:30 +0100"},
+		{"Day off with a very long description which is folded according to RFC 5545", "2023-11-05 00:00 +0100", "2023-11-06 00:00 +0100"},
+		{"Late shift", "2023-11-06 14:00 +0100", "2023-11-06 23:00 +0100"},
+		{"Night shift, ward 3", "2023-11-07 22:00 +0100", "2023-11-08 06:30 +0100"},
+	}
+	if len(events) != len(expected) {
+		t.Fatalf("parseICalendar returned %d events; want %d", len(events), len(expected))
+	}
+	for index, event := range events {
+		start := event.Start.In(location).Format("2006-01-02 15:04 -0700")
+		end := event.End.In(location).Format("2006-01-02 15:04 -0700")
+		if event.Summary != expected[index].summary || start != expected[index].start || end != expected[index].end {
+			t.Errorf("event %d = %q %s - %s; want %q %s - %s", index, event.Summary, start, end, expected[index].summary, expected[index].start, expected[index].end)
+		}
+	}
+}
+
+func TestParseICalendarDuration(t *testing.T) {
+	tests := map[string]time.Duration{
+		"PT8H30M": 8*time.Hour + 30*time.Minute,
+		"P1D":     24 * time.Hour,
+		"P1W":     7 * 24 * time.Hour,
+		"-PT15M":  -15 * time.Minute,
+		"P1DT2H":  26 * time.Hour,
+	}
+	for value, want := range tests {
+		duration, err := parseICalendarDuration(value)
+		if err != nil || duration != want {
+			t.Errorf("parseICalendarDuration(%s) = %v, %v; want %v", value, duration, err, want)
+		}
+	}
+	for _, value := range []string{"", "8H", "PTH", "PT8X"} {
+		if _, err := parseICalendarDuration(value); err == nil {
+			t.Errorf("parseICalendarDuration(%s) should return an error", value)
+		}
+	}
+}
+
+func TestCalendarRuleSelectsSchedule(t *testing.T) {
+	location := loadLocation(t, "Europe/Berlin")
+	c := Configuration{}
+	c.initializeDefaults()
+	c.Timezone = "Europe/Berlin"
+	c.Schedules[0].AssociatedDeviceIDs = deviceIDs(1)
+	late := c.Schedules[0]
+	late.Name = "late"
+	late.AssociatedDeviceIDs = deviceIDs()
+	late.AfterSunset = []TimedColorTemperature{{"23:30", 2000, 40}}
+	c.Schedules = append(c.Schedules, late)
+	c.Calendar.Rules = []CalendarRule{
+		{Keyword: "late shift", Schedule: "late"},
+		{Keyword: "early", Offset: -60},
+	}
+
+	watcher := calendarWatcher
+	defer func() { calendarWatcher = watcher }()
+	calendarWatcher = &CalendarWatcher{file: "testdata/calendar.ics", location: location, rules: c.Calendar.Rules, updates: make(chan struct{}, 1)}
+	calendarWatcher.refresh(time.Date(2023, time.November, 6, 15, 0, 0, 0, location))
+	select {
+	case <-calendarWatcher.updates:
+	default:
+		t.Errorf("refresh() did not report the active event")
+	}
+
+	// During the late shift the late schedule applies
+	schedule, _ := c.lightScheduleForDay("1", time.Date(2023, time.November, 6, 15, 0, 0, 0, location))
+	if len(schedule.afterSunset) != 1 || schedule.afterSunset[0].Time.Hour() != 23 {
+		t.Errorf("schedule during late shift = %+v; want schedule late", schedule.afterSunset)
+	}
+
+	// Outside of any event the regular schedule applies
+	schedule, _ = c.lightScheduleForDay("1", time.Date(2023, time.November, 6, 12, 0, 0, 0, location))
+	if len(schedule.afterSunset) != 2 {
+		t.Errorf("schedule outside of events = %+v; want default schedule", schedule.afterSunset)
+	}
+
+	// During the early shift the schedule is shifted
+	schedule, _ = c.lightScheduleForDay("1", time.Date(2023, time.November, 4, 7, 0, 0, 0, location))
+	if schedule.beforeSunrise[0].Time.Hour() != 3 {
+		t.Errorf("schedule during early shift starts at %v; want 03:00", schedule.beforeSunrise[0].Time)
+	}
+
+	upcoming := calendarWatcher.upcomingEvents(time.Date(2023, time.November, 4, 0, 0, 0, 0, location), upcomingCalendarEventsDuration)
+	if len(upcoming) != 2 || upcoming[0].Rule.Keyword != "early" || upcoming[1].Rule.Schedule != "late" {
+		t.Errorf("upcomingEvents() = %+v; want early and late shift", upcoming)
+	}
+}
@@ -0,0 +1,145 @@
+// MIT License
+//
+// # Copyright (c) 2019 Stefan Wichmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// certificateFingerprint returns the SHA-256 fingerprint of the given certificate.
+func certificateFingerprint(certificate *x509.Certificate) string {
+	sum := sha256.Sum256(certificate.Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// verifyBridgeCertificate checks that the self-signed certificate of a
+// bridge was issued for the given bridge ID and matches the pinned
+// fingerprint. Empty values are not checked.
+func verifyBridgeCertificate(certificate *x509.Certificate, bridgeID string, fingerprint string) error {
+	if bridgeID != "" && !strings.EqualFold(certificate.Subject.CommonName, bridgeID) {
+		return fmt.Errorf("the certificate was issued for %q instead of bridge %s", certificate.Subject.CommonName, bridgeID)
+	}
+	if fingerprint != "" && !strings.EqualFold(certificateFingerprint(certificate), fingerprint) {
+		return fmt.Errorf("the certificate of the bridge changed (pinned fingerprint %s, presented fingerprint %s). If you replaced or reset your bridge, run \"kelvin config repin\" and restart Kelvin to trust the new certificate", fingerprint, certificateFingerprint(certificate))
+	}
+	return nil
+}
+
+// pinnedTLSConfig returns a TLS configuration accepting only the
+// certificate with the given fingerprint. The bridge uses a self-signed
+// certificate, so it can't be verified by the system roots. Without a
+// pinned fingerprint all connections are refused.
+func pinnedTLSConfig(fingerprint string) *tls.Config {
+	return &tls.Config{
+		InsecureSkipVerify: true,
+		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			if fingerprint == "" {
+				return errors.New("no certificate pinned for the bridge")
+			}
+			if len(rawCerts) == 0 {
+				return errors.New("bridge presented no certificate")
+			}
+			certificate, err := x509.ParseCertificate(rawCerts[0])
+			if err != nil {
+				return err
+			}
+			return verifyBridgeCertificate(certificate, "", fingerprint)
+		},
+	}
+}
+
+// bridgeRequestTimeout matches the timeout go.hue uses for its requests.
+const bridgeRequestTimeout = 2 * time.Second
+
+// newBridgeClient returns the HTTP client for all requests to a bridge.
+// HTTPS connections are only accepted with the pinned certificate and
+// responses of an overloaded bridge are returned as errors.
+func newBridgeClient(fingerprint string) *http.Client {
+	transport := &http.Transport{
+		TLSClientConfig:       pinnedTLSConfig(fingerprint),
+		TLSHandshakeTimeout:   bridgeRequestTimeout,
+		ResponseHeaderTimeout: bridgeRequestTimeout,
+		MaxIdleConns:          10,
+		MaxConnsPerHost:       10,
+	}
+	return &http.Client{Transport: overloadTransport{next: transport}, Timeout: bridgeRequestTimeout}
+}
+
+// pinCertificate verifies the HTTPS certificate of the bridge with the
+// given ID. The certificate of a bridge without a pinned fingerprint is
+// trusted on first use and pinned.
+func (bridge *HueBridge) pinCertificate(bridgeID string) error {
+	address := bridge.BridgeIP
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(address, "443")
+	}
+	dialer := net.Dialer{Timeout: 5 * time.Second}
+	conn, err := tls.DialWithDialer(&dialer, "tcp", address, &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		return fmt.Errorf("could not read the certificate of bridge %s: %v", bridge, err)
+	}
+	defer conn.Close()
+
+	certificates := conn.ConnectionState().PeerCertificates
+	if len(certificates) == 0 {
+		return fmt.Errorf("bridge %s presented no certificate", bridge)
+	}
+	err = verifyBridgeCertificate(certificates[0], bridgeID, bridge.Fingerprint)
+	if err != nil {
+		return fmt.Errorf("refusing to connect to bridge %s: %v", bridge, err)
+	}
+
+	if bridge.Fingerprint == "" {
+		bridge.Fingerprint = certificateFingerprint(certificates[0])
+		log.Printf("⌘ Pinned the certificate of bridge %s (SHA-256 fingerprint %s)", bridge, bridge.Fingerprint)
+	}
+	return nil
+}
+
+// repinCertificates removes the pinned certificate and the ID of the bridge
+// with the given name, or of all bridges if no name is given. Both will be
+// taken from the bridge again on the next start.
+func (configuration *Configuration) repinCertificates(name string) error {
+	found := false
+	for _, bridge := range configuration.configuredBridges() {
+		if name == "" || bridge.Name == name {
+			bridge.CertificateFingerprint = ""
+			bridge.ID = ""
+			found = true
+		}
+	}
+	if !found {
+		return fmt.Errorf("no bridge named %q configured", name)
+	}
+	return nil
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	hue "github.com/stefanwichmann/go.hue"
+)
+
+// newBridgeCertificate returns a self-signed certificate like the one of
+// a hue bridge with the given ID.
+func newBridgeCertificate(t *testing.T, bridgeID string) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: bridgeID, Organization: []string{"Philips Hue"}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	raw, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{raw}, PrivateKey: key, Leaf: leaf}
+}
+
+func newTLSBridge(t *testing.T, certificate tls.Certificate) *httptest.Server {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"errors": [], "data": []}`))
+	}))
+	server.TLS = &tls.Config{Certificates: []tls.Certificate{certificate}}
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestVerifyBridgeCertificate(t *testing.T) {
+	certificate := newBridgeCertificate(t, "001788fffe23bfc2").Leaf
+	fingerprint := certificateFingerprint(certificate)
+
+	if err := verifyBridgeCertificate(certificate, "001788FFFE23BFC2", fingerprint); err != nil {
+		t.Errorf("certificate of the bridge should be accepted: %v", err)
+	}
+	if err := verifyBridgeCertificate(certificate, "", ""); err != nil {
+		t.Errorf("certificate should be accepted without ID and fingerprint: %v", err)
+	}
+	if err := verifyBridgeCertificate(certificate, "001788FFFE000000", ""); err == nil {
+		t.Errorf("certificate of another bridge should be rejected")
+	}
+	other := newBridgeCertificate(t, "001788fffe23bfc2").Leaf
+	err := verifyBridgeCertificate(other, "001788FFFE23BFC2", fingerprint)
+	if err == nil || !strings.Contains(err.Error(), "kelvin config repin") {
+		t.Errorf("changed certificate should be rejected with a hint to repin: %v", err)
+	}
+}
+
+func TestPinCertificate(t *testing.T) {
+	certificate := newBridgeCertificate(t, "001788fffe23bfc2")
+	server := newTLSBridge(t, certificate)
+	bridge := &HueBridge{ID: "001788FFFE23BFC2", BridgeIP: strings.TrimPrefix(server.URL, "https://")}
+
+	// Trust on first use
+	if err := bridge.pinCertificate(bridge.ID); err != nil {
+		t.Fatal(err)
+	}
+	if bridge.Fingerprint != certificateFingerprint(certificate.Leaf) {
+		t.Errorf("certificate should be pinned with fingerprint %s but got %s", certificateFingerprint(certificate.Leaf), bridge.Fingerprint)
+	}
+	if err := bridge.pinCertificate(bridge.ID); err != nil {
+		t.Errorf("pinned certificate should be accepted: %v", err)
+	}
+	if err := bridge.pinCertificate("001788FFFE000000"); err == nil {
+		t.Errorf("certificate issued for another bridge than the configured one should be rejected")
+	}
+
+	// Another device with the same address
+	impostor := newTLSBridge(t, newBridgeCertificate(t, "001788fffe23bfc2"))
+	bridge.BridgeIP = strings.TrimPrefix(impostor.URL, "https://")
+	if err := bridge.pinCertificate(bridge.ID); err == nil {
+		t.Errorf("changed certificate should be rejected")
+	}
+}
+
+func TestClipV2ClientChecksPinnedCertificate(t *testing.T) {
+	certificate := newBridgeCertificate(t, "001788fffe23bfc2")
+	server := newTLSBridge(t, certificate)
+	address := strings.TrimPrefix(server.URL, "https://")
+
+	client := newClipV2Client(address, fakeApplicationKey, certificateFingerprint(certificate.Leaf))
+	if _, err := client.resources("light"); err != nil {
+		t.Errorf("pinned certificate should be accepted: %v", err)
+	}
+	client = newClipV2Client(address, fakeApplicationKey, strings.Repeat("0", 64))
+	if _, err := client.resources("light"); err == nil {
+		t.Errorf("certificate not matching the pinned fingerprint should be rejected")
+	}
+	client = newClipV2Client(address, fakeApplicationKey, "")
+	if _, err := client.resources("light"); err == nil {
+		t.Errorf("certificate should be rejected without a pinned fingerprint")
+	}
+}
+
+func TestGoHueChecksPinnedCertificate(t *testing.T) {
+	certificate := newBridgeCertificate(t, "001788fffe23bfc2")
+	server := newTLSBridge(t, certificate)
+	address := strings.TrimPrefix(server.URL, "https://")
+
+	bridge := hue.NewBridge(address, fakeBridgeUsername)
+	bridge.SetClient(newBridgeClient(certificateFingerprint(certificate.Leaf)))
+	bridge.EnableHTTPS(true)
+	if _, err := bridge.Configuration(); err != nil {
+		t.Errorf("pinned certificate should be accepted: %v", err)
+	}
+
+	bridge = hue.NewBridge(address, fakeBridgeUsername)
+	bridge.SetClient(newBridgeClient(strings.Repeat("0", 64)))
+	bridge.EnableHTTPS(true)
+	if _, err := bridge.Configuration(); err == nil {
+		t.Errorf("certificate not matching the pinned fingerprint should be rejected")
+	}
+}
+
+func TestRepinCertificates(t *testing.T) {
+	c := Configuration{
+		Bridge:            Bridge{CertificateFingerprint: "aa"},
+		AdditionalBridges: []Bridge{{Name: "garage", ID: "001788FFFE000000", CertificateFingerprint: "bb"}},
+	}
+	if err := c.repinCertificates("garage"); err != nil {
+		t.Fatal(err)
+	}
+	if c.Bridge.CertificateFingerprint != "aa" || c.AdditionalBridges[0].CertificateFingerprint != "" || c.AdditionalBridges[0].ID != "" {
+		t.Errorf("only the certificate of the garage bridge should be removed: %+v", c.configuredBridges())
+	}
+	if err := c.repinCertificates(""); err != nil || c.Bridge.CertificateFingerprint != "" {
+		t.Errorf("all certificates should be removed (Error: %v)", err)
+	}
+	if err := c.repinCertificates("attic"); err == nil {
+		t.Errorf("unknown bridge should be reported")
+	}
+}
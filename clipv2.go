@@ -0,0 +1,401 @@
+// MIT License
+//
+// # Copyright (c) 2019 Stefan Wichmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	hue "github.com/stefanwichmann/go.hue"
+)
+
+const clipV2RequestTimeout = 10 * time.Second
+const clipV2MinimumRetryDelay = 1 * time.Second
+const clipV2MaximumRetryDelay = 1 * time.Minute
+
+var errClipV2Unsupported = errors.New("bridge does not support the hue API v2")
+
+// ClipV2Client communicates with the hue API v2 (CLIP v2) of the bridge.
+// It is only used to receive light events, all commands are still sent
+// through the v1 API.
+type ClipV2Client struct {
+	address        string
+	applicationKey string
+	client         *http.Client
+}
+
+// ClipV2Resource represents a resource or an event of the hue API v2.
+// Events only contain the changed attributes.
+type ClipV2Resource struct {
+	ID               string                  `json:"id"`
+	IDV1             string                  `json:"id_v1"`
+	Type             string                  `json:"type"`
+	Owner            *clipV2Reference        `json:"owner"`
+	On               *clipV2On               `json:"on"`
+	Dimming          *clipV2Dimming          `json:"dimming"`
+	ColorTemperature *clipV2ColorTemperature `json:"color_temperature"`
+	Color            *clipV2Color            `json:"color"`
+	Status           string                  `json:"status"`
+}
+
+type clipV2Reference struct {
+	RID   string `json:"rid"`
+	RType string `json:"rtype"`
+}
+
+type clipV2On struct {
+	On bool `json:"on"`
+}
+
+type clipV2Dimming struct {
+	Brightness float64 `json:"brightness"`
+}
+
+type clipV2ColorTemperature struct {
+	Mirek      *int `json:"mirek"`
+	MirekValid bool `json:"mirek_valid"`
+}
+
+type clipV2Color struct {
+	XY struct {
+		X float64 `json:"x"`
+		Y float64 `json:"y"`
+	} `json:"xy"`
+}
+
+func newClipV2Client(address string, applicationKey string, fingerprint string) *ClipV2Client {
+	transport := &http.Transport{TLSClientConfig: pinnedTLSConfig(fingerprint)}
+	return &ClipV2Client{address, applicationKey, &http.Client{Transport: transport}}
+}
+
+func (client *ClipV2Client) request(ctx context.Context, path string) (*http.Response, error) {
+	request, err := http.NewRequestWithContext(ctx, "GET", "https://"+client.address+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("hue-application-key", client.applicationKey)
+	return client.client.Do(request)
+}
+
+// resources returns all resources of the given type, e.g. light.
+func (client *ClipV2Client) resources(resourceType string) ([]ClipV2Resource, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), clipV2RequestTimeout)
+	defer cancel()
+	response, err := client.request(ctx, "/clip/v2/resource/"+resourceType)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode == http.StatusNotFound {
+		return nil, errClipV2Unsupported
+	}
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("bridge returned status %s for %s resources", response.Status, resourceType)
+	}
+
+	var result struct {
+		Errors []struct {
+			Description string `json:"description"`
+		} `json:"errors"`
+		Data []ClipV2Resource `json:"data"`
+	}
+	err = json.NewDecoder(response.Body).Decode(&result)
+	if err != nil {
+		return nil, err
+	}
+	if len(result.Errors) > 0 {
+		return nil, errors.New(result.Errors[0].Description)
+	}
+	return result.Data, nil
+}
+
+// subscribe reads the event stream of the bridge until the connection
+// fails or stop is closed. connected is called once the stream is
+// established, handler for every received event.
+func (client *ClipV2Client) subscribe(stop <-chan struct{}, connected func(), handler func([]ClipV2Resource)) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	request, err := http.NewRequestWithContext(ctx, "GET", "https://"+client.address+"/eventstream/clip/v2", nil)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("hue-application-key", client.applicationKey)
+	request.Header.Set("Accept", "text/event-stream")
+	response, err := client.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode == http.StatusNotFound {
+		return errClipV2Unsupported
+	}
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("bridge returned status %s for the event stream", response.Status)
+	}
+	connected()
+
+	// Events are sent as server-sent events with JSON data lines
+	var data bytes.Buffer
+	scanner := bufio.NewScanner(response.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "data:") {
+			data.WriteString(strings.TrimSpace(strings.TrimPrefix(line, "data:")))
+			continue
+		}
+		if line != "" || data.Len() == 0 {
+			continue
+		}
+
+		var events []struct {
+			Type string           `json:"type"`
+			Data []ClipV2Resource `json:"data"`
+		}
+		err := json.Unmarshal(data.Bytes(), &events)
+		data.Reset()
+		if err != nil {
+			log.Debugf("⌘ Ignoring invalid event from bridge: %v", err)
+			continue
+		}
+		for _, event := range events {
+			if event.Type == "update" || event.Type == "add" {
+				handler(event.Data)
+			}
+		}
+	}
+	if ctx.Err() != nil {
+		return nil // stopped
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return errors.New("event stream closed by bridge")
+}
+
+// LightEventStream keeps the states of all lights up to date with the
+// events of the bridge, so they don't have to be polled.
+type LightEventStream struct {
+	client    *ClipV2Client
+	lock      sync.Mutex
+	connected bool
+	states    map[int]hue.LightAttributes
+	lights    map[string]int
+	devices   map[string][]int
+	updates   chan struct{}
+	stop      chan struct{}
+}
+
+// lightEvents receives a notification whenever a light event of any
+// bridge arrives.
+var lightEvents = make(chan struct{}, 1)
+
+// newLightEventStream creates an event stream which notifies the given
+// channel about changed light states.
+func newLightEventStream(client *ClipV2Client, updates chan struct{}) *LightEventStream {
+	return &LightEventStream{client: client, updates: updates, stop: make(chan struct{})}
+}
+
+// start connects to the event stream in the background and reconnects
+// after failures.
+func (stream *LightEventStream) start() {
+	go func() {
+		delay := clipV2MinimumRetryDelay
+		for {
+			started := time.Now()
+			err := stream.client.subscribe(stream.stop, stream.synchronize, stream.apply)
+			stream.lock.Lock()
+			wasConnected := stream.connected
+			stream.connected = false
+			stream.lock.Unlock()
+
+			select {
+			case <-stream.stop:
+				return
+			default:
+			}
+			if wasConnected {
+				log.Warningf("⌘ Lost connection to the event stream of the bridge: %v. Polling light states...", err)
+			} else {
+				log.Debugf("⌘ Could not connect to the event stream of the bridge: %v", err)
+			}
+
+			if time.Since(started) > clipV2MaximumRetryDelay {
+				delay = clipV2MinimumRetryDelay
+			}
+			select {
+			case <-stream.stop:
+				return
+			case <-time.After(delay):
+			}
+			delay = min(2*delay, clipV2MaximumRetryDelay)
+		}
+	}()
+}
+
+// close disconnects from the event stream.
+func (stream *LightEventStream) close() {
+	close(stream.stop)
+}
+
+// synchronize reads the current state of all lights after the event
+// stream was (re)connected.
+func (stream *LightEventStream) synchronize() {
+	resources, err := stream.client.resources("light")
+	if err != nil {
+		log.Warningf("⌘ Could not read light states from the bridge: %v", err)
+		return
+	}
+	connectivity, err := stream.client.resources("zigbee_connectivity")
+	if err != nil {
+		log.Debugf("⌘ Could not read the connectivity of the lights: %v", err)
+	}
+
+	states := make(map[int]hue.LightAttributes)
+	lights := make(map[string]int)
+	devices := make(map[string][]int)
+	for _, resource := range resources {
+		lightID, err := strconv.Atoi(strings.TrimPrefix(resource.IDV1, "/lights/"))
+		if err != nil {
+			continue
+		}
+		lights[resource.ID] = lightID
+		if resource.Owner != nil {
+			devices[resource.Owner.RID] = append(devices[resource.Owner.RID], lightID)
+		}
+		var attributes hue.LightAttributes
+		attributes.State.Reachable = true
+		applyClipV2Resource(&attributes, resource)
+		states[lightID] = attributes
+	}
+
+	stream.lock.Lock()
+	stream.states = states
+	stream.lights = lights
+	stream.devices = devices
+	stream.connected = true
+	stream.update(connectivity)
+	stream.lock.Unlock()
+	log.Printf("⌘ Receiving light events from the bridge")
+	stream.notify()
+}
+
+// apply updates the light states with the given events.
+func (stream *LightEventStream) apply(resources []ClipV2Resource) {
+	stream.lock.Lock()
+	changed := stream.update(resources)
+	stream.lock.Unlock()
+	if changed {
+		stream.notify()
+	}
+}
+
+// update applies the events to the light states. The caller must hold the lock.
+func (stream *LightEventStream) update(resources []ClipV2Resource) bool {
+	changed := false
+	for _, resource := range resources {
+		switch resource.Type {
+		case "light":
+			lightID, found := stream.lights[resource.ID]
+			if !found {
+				continue
+			}
+			attributes := stream.states[lightID]
+			applyClipV2Resource(&attributes, resource)
+			stream.states[lightID] = attributes
+			changed = true
+		case "zigbee_connectivity":
+			if resource.Owner == nil || resource.Status == "" {
+				continue
+			}
+			for _, lightID := range stream.devices[resource.Owner.RID] {
+				attributes := stream.states[lightID]
+				attributes.State.Reachable = resource.Status == "connected"
+				stream.states[lightID] = attributes
+				changed = true
+			}
+		}
+	}
+	return changed
+}
+
+func (stream *LightEventStream) notify() {
+	// notify without blocking
+	select {
+	case stream.updates <- struct{}{}:
+	default:
+	}
+}
+
+// LightStates returns the current state of all lights. The result is
+// only valid if the stream is connected.
+func (stream *LightEventStream) LightStates() (map[int]hue.LightAttributes, bool) {
+	stream.lock.Lock()
+	defer stream.lock.Unlock()
+	states := make(map[int]hue.LightAttributes, len(stream.states))
+	for lightID, attributes := range stream.states {
+		states[lightID] = attributes
+	}
+	return states, stream.connected
+}
+
+// applyClipV2Resource maps the attributes of a v2 light resource to the
+// state of the v1 API used by Kelvin.
+func applyClipV2Resource(attributes *hue.LightAttributes, resource ClipV2Resource) {
+	if resource.On != nil {
+		attributes.State.On = resource.On.On
+	}
+	if resource.Dimming != nil {
+		attributes.State.Bri = max(int(math.Round(resource.Dimming.Brightness*254/100)), 1)
+	}
+	if resource.Color != nil {
+		attributes.State.Xy = []float32{float32(resource.Color.XY.X), float32(resource.Color.XY.Y)}
+		attributes.State.ColorMode = "xy"
+	}
+	if resource.ColorTemperature != nil {
+		if resource.ColorTemperature.MirekValid && resource.ColorTemperature.Mirek != nil {
+			attributes.State.Ct = *resource.ColorTemperature.Mirek
+			attributes.State.ColorMode = "ct"
+		} else if resource.Color == nil {
+			attributes.State.ColorMode = "xy"
+		}
+	}
+}
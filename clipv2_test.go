@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const fakeApplicationKey = "fake-application-key"
+
+// fakeClipV2Bridge serves the light resources and the event stream of
+// the hue API v2. Events written to the events channel are sent to all
+// connected clients.
+type fakeClipV2Bridge struct {
+	server *httptest.Server
+	events chan string
+}
+
+func newFakeClipV2Bridge(t *testing.T) *fakeClipV2Bridge {
+	bridge := &fakeClipV2Bridge{events: make(chan string)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/clip/v2/resource/light", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"errors": [], "data": [
+			{"id": "a1", "id_v1": "/lights/1", "type": "light", "owner": {"rid": "d1", "rtype": "device"}, "on": {"on": true}, "dimming": {"brightness": 100}, "color_temperature": {"mirek": 366, "mirek_valid": true}, "color": {"xy": {"x": 0.4573, "y": 0.41}}},
+			{"id": "a2", "id_v1": "/lights/2", "type": "light", "owner": {"rid": "d2", "rtype": "device"}, "on": {"on": false}, "dimming": {"brightness": 50}}
+		]}`))
+	})
+	mux.HandleFunc("/clip/v2/resource/zigbee_connectivity", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"errors": [], "data": [
+			{"id": "z1", "type": "zigbee_connectivity", "owner": {"rid": "d1", "rtype": "device"}, "status": "connected"},
+			{"id": "z2", "type": "zigbee_connectivity", "owner": {"rid": "d2", "rtype": "device"}, "status": "connectivity_issue"}
+		]}`))
+	})
+	mux.HandleFunc("/eventstream/clip/v2", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte(": hi\n\n"))
+		w.(http.Flusher).Flush()
+		for id := 1; ; id++ {
+			select {
+			case <-r.Context().Done():
+				return
+			case event := <-bridge.events:
+				fmt.Fprintf(w, "id: %d:0\ndata: %s\n\n", id, event)
+				w.(http.Flusher).Flush()
+			}
+		}
+	})
+	bridge.server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("hue-application-key") != fakeApplicationKey {
+			http.Error(w, `{"errors": [{"description": "unauthorized user"}]}`, http.StatusForbidden)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	}))
+	t.Cleanup(bridge.server.Close)
+	return bridge
+}
+
+func (bridge *fakeClipV2Bridge) address() string {
+	return strings.TrimPrefix(bridge.server.URL, "https://")
+}
+
+func serverFingerprint(server *httptest.Server) string {
+	return certificateFingerprint(server.Certificate())
+}
+
+func waitForLightEvent(t *testing.T, stream *LightEventStream) {
+	t.Helper()
+	select {
+	case <-stream.updates:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no light event received")
+	}
+}
+
+func TestLightEventStream(t *testing.T) {
+	bridge := newFakeClipV2Bridge(t)
+	stream := newLightEventStream(newClipV2Client(bridge.address(), fakeApplicationKey, serverFingerprint(bridge.server)), make(chan struct{}, 1))
+	stream.start()
+	defer stream.close()
+	waitForLightEvent(t, stream)
+
+	states, connected := stream.LightStates()
+	if !connected {
+		t.Fatal("stream should be connected")
+	}
+	light := states[1].State
+	if !light.On || !light.Reachable || light.Bri != 254 || light.Ct != 366 || light.ColorMode != "ct" {
+		t.Errorf("unexpected state of light 1: %+v", light)
+	}
+	light = states[2].State
+	if light.On || light.Reachable || light.Bri != 127 {
+		t.Errorf("unexpected state of light 2: %+v", light)
+	}
+
+	// Light turned off
+	bridge.events <- `[{"creationtime": "2026-10-18T10:00:00Z", "id": "e1", "type": "update", "data": [{"id": "a1", "id_v1": "/lights/1", "type": "light", "on": {"on": false}}]}]`
+	waitForLightEvent(t, stream)
+	states, _ = stream.LightStates()
+	if states[1].State.On {
+		t.Errorf("light 1 should be turned off")
+	}
+
+	// Color changed manually
+	bridge.events <- `[{"creationtime": "2026-10-18T10:00:01Z", "id": "e2", "type": "update", "data": [{"id": "a1", "id_v1": "/lights/1", "type": "light", "color": {"xy": {"x": 0.2, "y": 0.3}}, "color_temperature": {"mirek": null, "mirek_valid": false}}]}]`
+	waitForLightEvent(t, stream)
+	states, _ = stream.LightStates()
+	if states[1].State.ColorMode != "xy" || states[1].State.Xy[0] != 0.2 {
+		t.Errorf("light 1 should have changed its color: %+v", states[1].State)
+	}
+
+	// Light became reachable
+	bridge.events <- `[{"creationtime": "2026-10-18T10:00:02Z", "id": "e3", "type": "update", "data": [{"id": "z2", "type": "zigbee_connectivity", "owner": {"rid": "d2", "rtype": "device"}, "status": "connected"}]}]`
+	waitForLightEvent(t, stream)
+	states, _ = stream.LightStates()
+	if !states[2].State.Reachable {
+		t.Errorf("light 2 should be reachable")
+	}
+}
+
+func TestClipV2Unsupported(t *testing.T) {
+	server := httptest.NewTLSServer(http.NotFoundHandler())
+	defer server.Close()
+	client := newClipV2Client(strings.TrimPrefix(server.URL, "https://"), fakeApplicationKey, serverFingerprint(server))
+	_, err := client.resources("light")
+	if err != errClipV2Unsupported {
+		t.Errorf("expected unsupported API but got %v", err)
+	}
+
+	bridge := newFakeClipV2Bridge(t)
+	client = newClipV2Client(bridge.address(), "wrong", serverFingerprint(bridge.server))
+	_, err = client.resources("light")
+	if err == nil {
+		t.Errorf("request with an invalid application key should fail")
+	}
+}
@@ -0,0 +1,155 @@
+// MIT License
+//
+// # Copyright (c) 2019 Stefan Wichmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+const commandUsage = `Usage: kelvin [flags] [command]
+
+Commands:
+  schema                  Print the JSON Schema of the configuration file
+  config sources          Show which layer provides each configuration value
+  config migrate [-n]     Migrate the configuration file to the latest version
+                          (-n only prints the changes of every migration)
+  config backups          List all backups of the configuration file
+  config restore [name]   Restore the given or latest configuration backup
+  config repin [bridge]   Trust the current certificate and ID of all or the
+                          named bridge on the next start
+`
+
+// runCommand executes the given command line tool and returns the exit code.
+func runCommand(args []string) int {
+	if len(args) == 1 && args[0] == "schema" {
+		raw, err := json.MarshalIndent(ConfigurationSchema(), "", "  ")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Could not generate schema: %v\n", err)
+			return 1
+		}
+		fmt.Println(string(raw))
+		return 0
+	}
+
+	if len(args) < 2 || args[0] != "config" {
+		fmt.Fprint(os.Stderr, commandUsage)
+		return 2
+	}
+
+	configuration := newConfiguration(*flagConfigurationFile)
+	switch args[1] {
+	case "sources":
+		err := configuration.parse()
+		if err != nil && !os.IsNotExist(err) {
+			fmt.Fprintf(os.Stderr, "Could not read configuration: %v\n", err)
+			return 1
+		}
+		overrides, err := startupOverrides(*flagEnableWebInterface)
+		if err == nil {
+			err = configuration.applyOverrides(overrides)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Could not apply overrides: %v\n", err)
+			return 1
+		}
+		for _, source := range configuration.SourceList() {
+			fmt.Printf("%-40s %s\n", source[0], source[1])
+		}
+		return 0
+	case "migrate":
+		err := configuration.parse()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Could not read configuration: %v\n", err)
+			return 1
+		}
+		steps, err := configuration.PlanMigrations()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Could not plan migrations: %v\n", err)
+			return 1
+		}
+		if len(steps) == 0 {
+			fmt.Printf("Configuration is already at version %d\n", configuration.Version)
+			return 0
+		}
+		for _, step := range steps {
+			fmt.Printf("Version %d to %d: %s\n%s\n", step.From, step.To, step.Description, step.Diff)
+		}
+		if len(args) > 2 && (args[2] == "-n" || args[2] == "--dry-run") {
+			return 0
+		}
+		configuration.migrateToLatestVersion()
+		err = configuration.Write()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Could not write configuration: %v\n", err)
+			return 1
+		}
+		configuration.logHistory(historySourceMigration)
+		fmt.Printf("Migrated %s to version %d\n", configuration.ConfigurationFile, configuration.Version)
+		return 0
+	case "backups":
+		backups, err := configuration.Backups()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Could not list backups: %v\n", err)
+			return 1
+		}
+		for _, backup := range backups {
+			fmt.Printf("%s\t%s\t%d bytes\n", backup.Name, backup.Time.Format("2006-01-02 15:04:05"), backup.Size)
+		}
+		return 0
+	case "restore":
+		name := ""
+		if len(args) > 2 {
+			name = args[2]
+		}
+		backup, err := configuration.RestoreBackup(name)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Could not restore backup: %v\n", err)
+			return 1
+		}
+		fmt.Printf("Restored %s from %s\n", configuration.ConfigurationFile, backup.Name)
+		return 0
+	case "repin":
+		name := ""
+		if len(args) > 2 {
+			name = args[2]
+		}
+		err := configuration.Read()
+		if err == nil {
+			err = configuration.repinCertificates(name)
+		}
+		if err == nil {
+			err = configuration.Write()
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Could not remove the pinned certificate: %v\n", err)
+			return 1
+		}
+		configuration.logHistory(historySourceRepin)
+		fmt.Println("Removed the pinned certificate. Restart Kelvin to trust the current certificate of the bridge.")
+		return 0
+	}
+
+	fmt.Fprint(os.Stderr, commandUsage)
+	return 2
+}
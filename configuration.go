@@ -27,16 +27,29 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"strings"
 	"time"
 
-	"github.com/ghodss/yaml"
 	log "github.com/sirupsen/logrus"
 )
 
 // Bridge respresents the hue bridge in your system.
 type Bridge struct {
+	// Name identifies additional bridges in the light references of
+	// schedules, e.g. garage:3.
+	Name string `json:"name,omitempty"`
+	// ID is reported by the bridge and used to find it again after its
+	// IP address changed.
+	ID       string `json:"id,omitempty"`
 	IP       string `json:"ip"`
 	Username string `json:"username"`
+	// UsernameFile stores the username outside of the configuration.
+	UsernameFile string `json:"usernameFile,omitempty"`
+	// CertificateFingerprint pins the HTTPS certificate of the bridge.
+	CertificateFingerprint string `json:"certificateFingerprint,omitempty"`
+	// GroupCommands updates all lights of a room or group with one
+	// command if they need the same light state.
+	GroupCommands bool `json:"groupCommands,omitempty"`
 }
 
 // Location represents the geolocation for which sunrise and sunset will be calculated.
@@ -51,10 +64,48 @@ type WebInterface struct {
 	Port    int  `json:"port"`
 }
 
+// Weather configures the adjustment of the daylight on overcast days.
+// The adjustment is disabled if no source is configured.
+type Weather struct {
+	Source                 string `json:"source"`
+	CloudCoverThreshold    int    `json:"cloudCoverThreshold"`
+	ColorTemperatureOffset int    `json:"colorTemperatureOffset"`
+	BrightnessOffset       int    `json:"brightnessOffset"`
+	SunsetOffset           int    `json:"sunsetOffset"`
+}
+
+// Calendar configures the selection of schedules by the events of an
+// iCalendar file.
+type Calendar struct {
+	File  string         `json:"file"`
+	Rules []CalendarRule `json:"rules"`
+}
+
+// CalendarRule applies another schedule or an offset to the given
+// schedules (or all schedules if empty) while an event containing the
+// keyword in its summary is active.
+type CalendarRule struct {
+	Keyword   string   `json:"keyword"`
+	Schedules []string `json:"schedules"`
+	Schedule  string   `json:"schedule"`
+	Offset    int      `json:"offset"`
+}
+
+// AwayMode configures the presence simulation while you are away. The
+// associated lights will be switched on and off at random times between
+// start and end.
+type AwayMode struct {
+	Enabled             bool       `json:"enabled"`
+	AssociatedDeviceIDs []DeviceID `json:"associatedDeviceIDs"`
+	Start               string     `json:"start"`
+	End                 string     `json:"end"`
+	Jitter              int        `json:"jitter"`
+}
+
 // LightSchedule represents the schedule for any given day for the associated lights.
 type LightSchedule struct {
 	Name                    string                  `json:"name"`
-	AssociatedDeviceIDs     []int                   `json:"associatedDeviceIDs"`
+	AssociatedDeviceIDs     []DeviceID              `json:"associatedDeviceIDs"`
 	EnableWhenLightsAppear  bool                    `json:"enableWhenLightsAppear"`
 	DefaultColorTemperature int                     `json:"defaultColorTemperature"`
 	DefaultBrightness       int                     `json:"defaultBrightness"`
@@ -72,13 +123,29 @@ type TimedColorTemperature struct {
 
 // Configuration encapsulates all relevant parameters for Kelvin to operate.
 type Configuration struct {
-	ConfigurationFile string          `json:"-"`
-	Hash              string          `json:"-"`
-	Version           int             `json:"version"`
-	Bridge            Bridge          `json:"bridge"`
-	Location          Location        `json:"location"`
-	WebInterface      WebInterface    `json:"webinterface"`
-	Schedules         []LightSchedule `json:"schedules"`
+	ConfigurationFile string            `json:"-"`
+	Hash              string            `json:"-"`
+	Sources           map[string]string `json:"-"`
+	Version           int               `json:"version"`
+	Bridge            Bridge            `json:"bridge"`
+	AdditionalBridges []Bridge          `json:"additionalBridges,omitempty"`
+	Location          Location          `json:"location"`
+	Timezone          string            `json:"timezone,omitempty"`
+	WebInterface      WebInterface      `json:"webinterface"`
+	Weather           Weather           `json:"weather,omitzero"`
+	Calendar          Calendar          `json:"calendar,omitzero"`
+	AwayMode          AwayMode          `json:"awayMode,omitzero"`
+	Include           []string          `json:"include,omitempty"`
+	Schedules         []LightSchedule   `json:"schedules"`
+
+	overrides        []configurationOverride
+	plaintextSecrets bool
+	implicitIncludes []string
+	scheduleFiles    map[string]string
+
+	// location caches the time zone of locationName
+	location     *time.Location
+	locationName string
 }
 
 // TimeStamp represents a parsed and validated TimedColorTemperature.
@@ -88,8 +155,6 @@ type TimeStamp struct {
 	Brightness       int
 }
 
-var latestConfigurationVersion = 0
-
 func (configuration *Configuration) initializeDefaults() {
 	configuration.Version = latestConfigurationVersion
 
@@ -110,7 +175,7 @@ func (configuration *Configuration) initializeDefaults() {
 
 	var defaultSchedule LightSchedule
 	defaultSchedule.Name = "default"
-	defaultSchedule.AssociatedDeviceIDs = []int{}
+	defaultSchedule.AssociatedDeviceIDs = []DeviceID{}
 	defaultSchedule.DefaultColorTemperature = 2750
 	defaultSchedule.DefaultBrightness = 100
 	defaultSchedule.AfterSunset = []TimedColorTemperature{tvTime, bedTime}
@@ -122,6 +187,20 @@ func (configuration *Configuration) initializeDefaults() {
 	webinterface.Enabled = false
 	webinterface.Port = 8080
 	configuration.WebInterface = webinterface
+
+	var weather Weather
+	weather.CloudCoverThreshold = 70
+	weather.ColorTemperatureOffset = 1000
+	weather.BrightnessOffset = 20
+	weather.SunsetOffset = 60
+	configuration.Weather = weather
+
+	var awayMode AwayMode
+	awayMode.AssociatedDeviceIDs = []DeviceID{}
+	awayMode.Start = "18:00"
+	awayMode.End = "23:00"
+	awayMode.Jitter = 30
+	configuration.AwayMode = awayMode
 }
 
 // InitializeConfiguration creates and returns an initialized
@@ -129,8 +208,7 @@ func (configuration *Configuration) initializeDefaults() {
 // If no configuration can be found on disk, one with default values
 // will be created.
 func InitializeConfiguration(configurationFile string, enableWebInterface bool) (Configuration, error) {
-	var configuration Configuration
-	configuration.ConfigurationFile = configurationFile
+	configuration := newConfiguration(configurationFile)
 	if configuration.Exists() {
 		err := configuration.Read()
 		if err != nil {
@@ -140,21 +218,32 @@ func InitializeConfiguration(configurationFile string, enableWebInterface bool)
 	} else {
 		// write default config to disk
 		configuration.initializeDefaults()
+		configuration.Bridge.UsernameFile = defaultBridgeUsernameFile
 		err := configuration.Write()
 		if err != nil {
 			return configuration, err
 		}
+		configuration.logHistory(historySourceDefault)
 		log.Println("⚙ Default configuration generated")
 	}
 
-	// Overwrite interface configuration with startup parameter
-	if enableWebInterface {
-		configuration.WebInterface.Enabled = true
-		err := configuration.Write()
-		if err != nil {
-			return configuration, err
-		}
+	// Apply environment variables and startup parameters
+	overrides, err := startupOverrides(enableWebInterface)
+	if err != nil {
+		return configuration, err
+	}
+	err = configuration.applyOverrides(overrides)
+	if err != nil {
+		return configuration, err
+	}
+	for _, override := range configuration.overrides {
+		log.Printf("⚙ Using %s from %s", override.Path, override.Source)
+	}
+	err = configuration.validateDevices()
+	if err != nil {
+		return configuration, err
 	}
+	configuration.Hash = configuration.HashValue()
 	return configuration, nil
 }
 
@@ -169,20 +258,36 @@ func (configuration *Configuration) Write() error {
 		return nil
 	}
 	log.Debugf("⚙ Configuration changed. Saving to %v", configuration.ConfigurationFile)
-	raw, err := json.MarshalIndent(configuration, "", "  ")
+
+	// Values from the environment or command line are not saved
+	persisted, err := configuration.persisted()
+	if err != nil {
+		return err
+	}
+	err = configuration.saveSecrets(&persisted)
+	if err != nil {
+		return err
+	}
+
+	// Schedules of included files are saved to the file they came from
+	err = configuration.saveIncludedSchedules(&persisted)
+	if err != nil {
+		return err
+	}
+
+	raw, err := encodeConfigurationFile(configuration.ConfigurationFile, persisted)
 	if err != nil {
 		return err
 	}
 
-	// Convert JSON to YAML if needed
-	if isYAMLFile(configuration.ConfigurationFile) {
-		raw, err = yaml.JSONToYAML(raw)
+	if configuration.Exists() {
+		err = configuration.backup()
 		if err != nil {
-			return err
+			log.Warningf("⚙ Could not create backup: %v", err)
 		}
 	}
 
-	err = os.WriteFile(configuration.ConfigurationFile, raw, 0644)
+	err = writeFileAtomic(configuration.ConfigurationFile, raw, 0644)
 	if err != nil {
 		return err
 	}
@@ -192,8 +297,79 @@ func (configuration *Configuration) Write() error {
 	return nil
 }
 
+// encodeConfigurationFile converts the given value to the format of the
+// file. The comments and order of an existing file are kept if the
+// format supports it.
+func encodeConfigurationFile(filename string, value interface{}) ([]byte, error) {
+	raw, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return raw, err
+	}
+
+	// Convert JSON to the format of the file
+	format := configurationFormatForFile(filename)
+	raw, err = format.FromJSON(raw)
+	if err != nil {
+		return raw, err
+	}
+
+	// Keep comments and order of an existing file
+	if format.Update != nil {
+		if current, err := os.ReadFile(filename); err == nil {
+			updated, err := format.Update(current, raw)
+			if err != nil {
+				log.Warningf("⚙ Could not update %s in place: %v. Rewriting file...", filename, err)
+			} else {
+				raw = updated
+			}
+		}
+	}
+	return raw, nil
+}
+
 // Read loads a configuration from disk.
 func (configuration *Configuration) Read() error {
+	err := configuration.parse()
+	if err != nil {
+		return err
+	}
+
+	if len(configuration.Schedules) == 0 {
+		log.Warningf("⚙ Your current configuration doesn't contain any schedules! Generating default schedule...")
+		configuration.initializeDefaults()
+		log.Printf("⚙ Default schedule created.")
+		configuration.Write()
+	}
+	configuration.Hash = configuration.HashValue()
+	log.Debugf("⚙ Updated configuration hash.")
+
+	// Remove secrets from the configuration file
+	if configuration.plaintextSecrets {
+		configuration.Hash = ""
+	}
+
+	if configuration.Timezone != "" {
+		_, err := time.LoadLocation(configuration.Timezone)
+		if err != nil {
+			log.Warningf("⚙ Invalid time zone %q in configuration: %v. Using local time zone...", configuration.Timezone, err)
+		}
+	}
+
+	// Record changes made while Kelvin wasn't running
+	configuration.logHistory(historySourceFile)
+
+	// Write keeps a backup of the original file before it is migrated
+	migrations := configuration.pendingMigrations()
+	configuration.migrateToLatestVersion()
+	configuration.Write()
+	if len(migrations) > 0 {
+		configuration.logHistory(historySourceMigration)
+	}
+	return nil
+}
+
+// parse reads the configuration file without modifying it.
+func (configuration *Configuration) parse() error {
 	if configuration.ConfigurationFile == "" {
 		return errors.New("no configuration filename configured")
 	}
@@ -202,50 +378,108 @@ func (configuration *Configuration) Read() error {
 	if err != nil {
 		return err
 	}
+	err = configuration.decode(raw)
+	if err != nil {
+		return err
+	}
+	return configuration.loadIncludes()
+}
 
-	// Convert YAML to JSON if needed
-	if isYAMLFile(configuration.ConfigurationFile) {
-		raw, err = yaml.YAMLToJSON(raw)
-		if err != nil {
-			return err
+// decode parses the given file content in the format of the configuration file.
+func (configuration *Configuration) decode(raw []byte) error {
+	// Convert the format of the file to JSON
+	raw, err := configurationFormatForFile(configuration.ConfigurationFile).ToJSON(raw)
+	if err != nil {
+		return err
+	}
+
+	// Detect typos and outdated fields
+	unknown, err := unknownFields(raw)
+	if err != nil {
+		return err
+	}
+	if len(unknown) > 0 {
+		if *flagStrictConfiguration {
+			return fmt.Errorf("unknown fields in configuration: %s", strings.Join(unknown, ", "))
+		}
+		for _, path := range unknown {
+			log.Warningf("⚙ Ignoring unknown field %s in configuration %s", path, configuration.ConfigurationFile)
 		}
 	}
+	configuration.recordFileSources(raw)
 
 	err = json.Unmarshal(raw, configuration)
 	if err != nil {
 		return err
 	}
+	for _, bridge := range configuration.configuredBridges() {
+		if bridge.UsernameFile != "" && bridge.Username != "" {
+			configuration.plaintextSecrets = true
+		}
+	}
+	return configuration.loadSecrets()
+}
 
+// Validate checks the configuration for invalid values.
+func (configuration *Configuration) Validate() error {
 	if len(configuration.Schedules) == 0 {
-		log.Warningf("⚙ Your current configuration doesn't contain any schedules! Generating default schedule...")
-		err := configuration.backup()
+		return errors.New("configuration does not contain any schedules")
+	}
+	if configuration.Timezone != "" {
+		if _, err := time.LoadLocation(configuration.Timezone); err != nil {
+			return fmt.Errorf("invalid time zone %q: %v", configuration.Timezone, err)
+		}
+	}
+	if configuration.WebInterface.Port < 0 || configuration.WebInterface.Port > 65535 {
+		return fmt.Errorf("invalid webinterface port %d", configuration.WebInterface.Port)
+	}
+	if configuration.Weather.CloudCoverThreshold < 0 || configuration.Weather.CloudCoverThreshold > 100 {
+		return fmt.Errorf("invalid cloud cover threshold %d%%", configuration.Weather.CloudCoverThreshold)
+	}
+	if configuration.AwayMode.Enabled {
+		for _, value := range []string{configuration.AwayMode.Start, configuration.AwayMode.End} {
+			if _, err := time.Parse("15:04", value); err != nil {
+				return fmt.Errorf("invalid away mode time %q", value)
+			}
+		}
+	}
+
+	for _, schedule := range configuration.Schedules {
+		err := schedule.validate()
 		if err != nil {
-			log.Warningf("⚙ Could not create backup: %v", err)
-		} else {
-			log.Printf("⚙ Configuration backup created.")
-			configuration.initializeDefaults()
-			log.Printf("⚙ Default schedule created.")
-			configuration.Write()
+			return fmt.Errorf("schedule %q: %v", schedule.Name, err)
 		}
 	}
-	configuration.Hash = configuration.HashValue()
-	log.Debugf("⚙ Updated configuration hash.")
+	return configuration.validateDevices()
+}
 
-	configuration.migrateToLatestVersion()
-	configuration.Write()
+func (schedule *LightSchedule) validate() error {
+	defaultState := LightState{schedule.DefaultColorTemperature, schedule.DefaultBrightness}
+	if !defaultState.isValid() {
+		return fmt.Errorf("invalid default light state %+v", defaultState)
+	}
+	for _, entry := range append(append([]TimedColorTemperature{}, schedule.BeforeSunrise...), schedule.AfterSunset...) {
+		if _, err := time.Parse("15:04", entry.Time); err != nil {
+			return fmt.Errorf("invalid time %q", entry.Time)
+		}
+		state := LightState{entry.ColorTemperature, entry.Brightness}
+		if !state.isValid() {
+			return fmt.Errorf("invalid light state %+v at %s", state, entry.Time)
+		}
+	}
 	return nil
 }
 
-func (configuration *Configuration) lightScheduleForDay(light int, date time.Time) (Schedule, error) {
+func (configuration *Configuration) lightScheduleForDay(light DeviceID, date time.Time) (Schedule, error) {
 	// initialize schedule with end of day
 	var schedule Schedule
-	yr, mth, dy := date.Date()
-	schedule.endOfDay = time.Date(yr, mth, dy, 23, 59, 59, 59, date.Location())
+	date = date.In(configuration.TimeLocation())
+	schedule.endOfDay = endOfDay(date)
 
 	var lightSchedule LightSchedule
 	found := false
 	for _, candidate := range configuration.Schedules {
-		if containsInt(candidate.AssociatedDeviceIDs, light) {
+		if configuration.containsDevice(candidate.AssociatedDeviceIDs, light) {
 			lightSchedule = candidate
 			found = true
 			break
@@ -253,12 +487,30 @@ func (configuration *Configuration) lightScheduleForDay(light int, date time.Tim
 	}
 
 	if !found {
-		return schedule, fmt.Errorf("Light %d is not associated with any schedule in configuration", light)
+		return schedule, fmt.Errorf("Light %s is not associated with any schedule in configuration", light)
+	}
+
+	// Active calendar events may select another schedule or an offset
+	rule, ruleFound := configuration.Calendar.matchingRule(lightSchedule.Name, calendarWatcher.activeEvents(date))
+	if ruleFound && rule.Schedule != "" {
+		replacement, err := configuration.scheduleByName(rule.Schedule)
+		if err != nil {
+			log.Warningf("📅 Calendar rule for %q: %v", rule.Keyword, err)
+		} else {
+			log.Debugf("📅 Using schedule %q instead of %q due to calendar event %q", replacement.Name, lightSchedule.Name, rule.Keyword)
+			replacement.EnableWhenLightsAppear = lightSchedule.EnableWhenLightsAppear
+			lightSchedule = replacement
+		}
 	}
 
 	schedule.sunrise = TimeStamp{CalculateSunrise(date, configuration.Location.Latitude, configuration.Location.Longitude), lightSchedule.DefaultColorTemperature, lightSchedule.DefaultBrightness}
 	schedule.sunset = TimeStamp{CalculateSunset(date, configuration.Location.Latitude, configuration.Location.Longitude), lightSchedule.DefaultColorTemperature, lightSchedule.DefaultBrightness}
 
+	// Brighter and cooler daylight on overcast days
+	if cloudCover, ok := weatherCache.cloudCover(); ok {
+		configuration.Weather.adjustSchedule(&schedule, cloudCover)
+	}
+
 	// Before sunrise candidates
 	schedule.beforeSunrise = []TimeStamp{}
 	for _, candidate := range lightSchedule.BeforeSunrise {
@@ -281,10 +533,24 @@ func (configuration *Configuration) lightScheduleForDay(light int, date time.Tim
 		schedule.afterSunset = append(schedule.afterSunset, timestamp)
 	}
 
+	if ruleFound && rule.Offset != 0 {
+		log.Debugf("📅 Shifting schedule %q by %d minutes due to calendar event %q", lightSchedule.Name, rule.Offset, rule.Keyword)
+		schedule.shift(time.Duration(rule.Offset) * time.Minute)
+	}
+
 	schedule.enableWhenLightsAppear = lightSchedule.EnableWhenLightsAppear
 	return schedule, nil
 }
 
+func (configuration *Configuration) scheduleByName(name string) (LightSchedule, error) {
+	for _, candidate := range configuration.Schedules {
+		if strings.EqualFold(candidate.Name, name) {
+			return candidate, nil
+		}
+	}
+	return LightSchedule{}, fmt.Errorf("no schedule named %q in configuration", name)
+}
+
 // Exists return true if a configuration file is found on disk.
 // False otherwise.
 func (configuration *Configuration) Exists() bool {
@@ -312,8 +578,29 @@ func (configuration *Configuration) HashValue() string {
 	return fmt.Sprintf("%x", sha256.Sum256(json))
 }
 
+// TimeLocation returns the time zone all schedules are calculated in.
+// If no valid time zone is configured the local time zone of the host
+// will be used.
+func (configuration *Configuration) TimeLocation() *time.Location {
+	if configuration.location != nil && configuration.locationName == configuration.Timezone {
+		return configuration.location
+	}
+	location := time.Local
+	if configuration.Timezone != "" {
+		loaded, err := time.LoadLocation(configuration.Timezone)
+		if err == nil {
+			location = loaded
+		}
+	}
+	configuration.location = location
+	configuration.locationName = configuration.Timezone
+	return location
+}
+
 // AsTimestamp parses and validates a TimedColorTemperature and returns
-// a corresponding TimeStamp.
+// a corresponding TimeStamp on the day and in the time zone of the given
+// reference time. Times in a skipped hour will be moved forward, times
+// in a repeated hour resolve to their first occurrence.
 func (color *TimedColorTemperature) AsTimestamp(referenceTime time.Time) (TimeStamp, error) {
 	layout := "15:04"
 	t, err := time.Parse(layout, color.Time)
@@ -321,13 +608,13 @@ func (color *TimedColorTemperature) AsTimestamp(referenceTime time.Time) (TimeSt
 		return TimeStamp{time.Now(), color.ColorTemperature, color.Brightness}, err
 	}
 	yr, mth, day := referenceTime.Date()
-	targetTime := time.Date(yr, mth, day, t.Hour(), t.Minute(), t.Second(), 0, referenceTime.Location())
+	targetTime, resolution := resolveWallClock(yr, mth, day, t.Hour(), t.Minute(), t.Second(), referenceTime.Location())
+	switch resolution {
+	case wallClockSkipped:
+		log.Printf("⚙ Time %s does not exist on %v due to a daylight saving time transition. Using %s instead.", color.Time, referenceTime.Format("Jan 2 2006"), targetTime.Format("15:04 MST"))
+	case wallClockRepeated:
+		log.Printf("⚙ Time %s occurs twice on %v due to a daylight saving time transition. Using first occurrence %s.", color.Time, referenceTime.Format("Jan 2 2006"), targetTime.Format("15:04 MST"))
+	}
 
 	return TimeStamp{targetTime, color.ColorTemperature, color.Brightness}, nil
 }
-
-func (configuration *Configuration) backup() error {
-	backupFilename := configuration.ConfigurationFile + "_" + time.Now().Format("01022006")
-	log.Debugf("⚙ Moving configuration to %s.", backupFilename)
-	return os.Rename(configuration.ConfigurationFile, backupFilename)
-}
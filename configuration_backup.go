@@ -0,0 +1,219 @@
+// MIT License
+//
+// # Copyright (c) 2019 Stefan Wichmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const configurationBackupSuffix = ".backup-"
+const configurationBackupTimeFormat = "20060102-150405.000000000"
+
+// ConfigurationBackup represents a previous version of the configuration file.
+type ConfigurationBackup struct {
+	Name string    `json:"name"`
+	Time time.Time `json:"time"`
+	Size int64     `json:"size"`
+}
+
+// backup copies the current configuration file to a timestamped backup
+// and removes the oldest backups exceeding the configured limit.
+func (configuration *Configuration) backup() error {
+	raw, err := os.ReadFile(configuration.ConfigurationFile)
+	if err != nil {
+		return err
+	}
+
+	backupFilename := configuration.ConfigurationFile + configurationBackupSuffix + time.Now().Format(configurationBackupTimeFormat)
+	log.Debugf("⚙ Saving configuration backup %s.", backupFilename)
+	err = writeFileAtomic(backupFilename, raw, 0600) // may contain the bridge username
+	if err != nil {
+		return err
+	}
+
+	return configuration.pruneBackups(*flagConfigurationBackups)
+}
+
+// Backups returns all backups of the configuration file, newest first.
+func (configuration *Configuration) Backups() ([]ConfigurationBackup, error) {
+	var backups []ConfigurationBackup
+	if configuration.ConfigurationFile == "" {
+		return backups, errors.New("no configuration filename configured")
+	}
+
+	prefix := filepath.Base(configuration.ConfigurationFile) + configurationBackupSuffix
+	entries, err := os.ReadDir(filepath.Dir(configuration.ConfigurationFile))
+	if err != nil {
+		return backups, err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasPrefix(entry.Name(), prefix) {
+			continue
+		}
+		timestamp, err := time.ParseInLocation(configurationBackupTimeFormat, strings.TrimPrefix(entry.Name(), prefix), time.Local)
+		if err != nil {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		backups = append(backups, ConfigurationBackup{Name: entry.Name(), Time: timestamp, Size: info.Size()})
+	}
+
+	sort.Slice(backups, func(i, j int) bool { return backups[i].Time.After(backups[j].Time) })
+	return backups, nil
+}
+
+func (configuration *Configuration) pruneBackups(keep int) error {
+	backups, err := configuration.Backups()
+	if err != nil {
+		return err
+	}
+	if keep < 0 {
+		keep = 0
+	}
+	for index := keep; index < len(backups); index++ {
+		log.Debugf("⚙ Removing old configuration backup %s.", backups[index].Name)
+		err := os.Remove(filepath.Join(filepath.Dir(configuration.ConfigurationFile), backups[index].Name))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RestoreBackup replaces the configuration file with the given backup. If
+// name is empty the latest backup will be restored. The current file is
+// backed up first, so a restore can be reverted.
+func (configuration *Configuration) RestoreBackup(name string) (ConfigurationBackup, error) {
+	backups, err := configuration.Backups()
+	if err != nil {
+		return ConfigurationBackup{}, err
+	}
+	if len(backups) == 0 {
+		return ConfigurationBackup{}, errors.New("no configuration backups found")
+	}
+
+	backup := backups[0]
+	if name != "" {
+		found := false
+		for _, candidate := range backups {
+			if candidate.Name == name {
+				backup = candidate
+				found = true
+				break
+			}
+		}
+		if !found {
+			return backup, fmt.Errorf("unknown configuration backup %q", name)
+		}
+	}
+
+	raw, err := os.ReadFile(filepath.Join(filepath.Dir(configuration.ConfigurationFile), backup.Name))
+	if err != nil {
+		return backup, err
+	}
+
+	// Only restore valid configurations
+	restored := Configuration{ConfigurationFile: configuration.ConfigurationFile}
+	err = restored.decode(raw)
+	if err != nil {
+		return backup, fmt.Errorf("backup %s is invalid: %v", backup.Name, err)
+	}
+	restored.migrateToLatestVersion()
+	err = restored.Validate()
+	if err != nil {
+		return backup, fmt.Errorf("backup %s is invalid: %v", backup.Name, err)
+	}
+
+	if configuration.Exists() {
+		err = configuration.backup()
+		if err != nil {
+			return backup, err
+		}
+	}
+	err = writeFileAtomic(configuration.ConfigurationFile, raw, 0644)
+	if err != nil {
+		return backup, err
+	}
+	log.Printf("⚙ Restored configuration backup %s", backup.Name)
+	return backup, nil
+}
+
+// writeFileAtomic writes data to a temporary file in the same directory
+// and renames it to filename once the content is on disk. Readers will
+// either see the old or the new content but never a partial file. If
+// filename is a symlink, the file it points to is replaced. The mode of an
+// existing file is kept, perm only applies to new files.
+func writeFileAtomic(filename string, data []byte, perm os.FileMode) error {
+	if target, err := filepath.EvalSymlinks(filename); err == nil {
+		filename = target
+	}
+	if info, err := os.Stat(filename); err == nil {
+		perm = info.Mode().Perm()
+	}
+
+	file, err := os.CreateTemp(filepath.Dir(filename), "."+filepath.Base(filename)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	temporary := file.Name()
+	defer os.Remove(temporary) // no-op after successful rename
+
+	_, err = file.Write(data)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	err = os.Chmod(temporary, perm)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(temporary, filename)
+	if err != nil {
+		return err
+	}
+
+	// Persist the rename itself
+	directory, err := os.Open(filepath.Dir(filename))
+	if err != nil {
+		return nil
+	}
+	defer directory.Close()
+	directory.Sync()
+	return nil
+}
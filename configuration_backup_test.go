@@ -0,0 +1,128 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteFileAtomic(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config.json")
+	err := writeFileAtomic(file, []byte("{}"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := os.ReadFile(file)
+	if err != nil || string(raw) != "{}" {
+		t.Errorf("file should contain new content but contains %q (error: %v)", raw, err)
+	}
+	info, err := os.Stat(file)
+	if err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("file should have permissions 0600 (error: %v)", err)
+	}
+}
+
+func TestWriteFileAtomicKeepsMode(t *testing.T) {
+	file := copyTestFile(t, "testdata/config-example.json")
+	err := os.Chmod(file, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = writeFileAtomic(file, []byte("{}"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(file)
+	if err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("existing file should keep its permissions 0600 but has %v (error: %v)", info.Mode().Perm(), err)
+	}
+}
+
+func TestWriteFileAtomicThroughSymlink(t *testing.T) {
+	target := copyTestFile(t, "testdata/config-example.json")
+	link := filepath.Join(t.TempDir(), "config.json")
+	err := os.Symlink(target, link)
+	if err != nil {
+		t.Skipf("symlinks not supported: %v", err)
+	}
+	err = writeFileAtomic(link, []byte("{}"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Lstat(link)
+	if err != nil || info.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("symlink should be kept (error: %v)", err)
+	}
+	raw, err := os.ReadFile(target)
+	if err != nil || string(raw) != "{}" {
+		t.Errorf("target of the symlink should contain new content but contains %q (error: %v)", raw, err)
+	}
+}
+
+func TestBackupRotation(t *testing.T) {
+	previous := *flagConfigurationBackups
+	*flagConfigurationBackups = 2
+	defer func() { *flagConfigurationBackups = previous }()
+
+	c := Configuration{ConfigurationFile: copyTestFile(t, "testdata/config-example.json")}
+	for i := 0; i < 4; i++ {
+		err := c.backup()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	backups, err := c.Backups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 2 {
+		t.Fatalf("expected 2 backups but found %d", len(backups))
+	}
+	if !backups[0].Time.After(backups[1].Time) {
+		t.Errorf("backups should be sorted newest first: %+v", backups)
+	}
+}
+
+func TestRestoreBackup(t *testing.T) {
+	c := Configuration{ConfigurationFile: copyTestFile(t, "testdata/config-example.json")}
+	original, err := os.ReadFile(c.ConfigurationFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.backup()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// An invalid backup must not be restored
+	err = writeFileAtomic(c.ConfigurationFile, []byte(`{"schedules": []}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.backup()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.RestoreBackup("")
+	if err == nil {
+		t.Errorf("restoring an invalid backup should fail")
+	}
+	_, err = c.RestoreBackup("../config-example.json")
+	if err == nil {
+		t.Errorf("restoring an unknown backup should fail")
+	}
+
+	backups, err := c.Backups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.RestoreBackup(backups[len(backups)-1].Name)
+	if err != nil {
+		t.Fatalf("could not restore backup: %v", err)
+	}
+	restored, err := os.ReadFile(c.ConfigurationFile)
+	if err != nil || string(restored) != string(original) {
+		t.Errorf("restored configuration should match the original file")
+	}
+}
@@ -0,0 +1,148 @@
+// MIT License
+//
+// # Copyright (c) 2019 Stefan Wichmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/ghodss/yaml"
+)
+
+// ConfigurationFormat converts a configuration file format from and to
+// JSON, which is used internally for all configurations.
+type ConfigurationFormat struct {
+	Name       string
+	Extensions []string
+	ToJSON     func(raw []byte) ([]byte, error)
+	FromJSON   func(raw []byte) ([]byte, error)
+	// Update optionally merges the new content into the existing file,
+	// e.g. to preserve comments.
+	Update func(current []byte, updated []byte) ([]byte, error)
+}
+
+// configurationFormats contains all supported formats. The first entry is
+// used for unknown file extensions.
+var configurationFormats = []ConfigurationFormat{
+	{
+		Name:       "json",
+		Extensions: []string{".json"},
+		ToJSON:     func(raw []byte) ([]byte, error) { return raw, nil },
+		FromJSON:   func(raw []byte) ([]byte, error) { return raw, nil },
+	},
+	{
+		Name:       "yaml",
+		Extensions: []string{".yaml", ".yml"},
+		ToJSON:     yaml.YAMLToJSON,
+		FromJSON:   yaml.JSONToYAML,
+		Update:     updateYAML,
+	},
+	{
+		Name:       "toml",
+		Extensions: []string{".toml"},
+		ToJSON:     tomlToJSON,
+		FromJSON:   jsonToTOML,
+	},
+}
+
+// configurationFormatForFile returns the format matching the extension of
+// the given filename.
+func configurationFormatForFile(filename string) ConfigurationFormat {
+	extension := strings.ToLower(filepath.Ext(filename))
+	for _, format := range configurationFormats {
+		for _, candidate := range format.Extensions {
+			if candidate == extension {
+				return format
+			}
+		}
+	}
+	return configurationFormats[0]
+}
+
+func tomlToJSON(raw []byte) ([]byte, error) {
+	var document map[string]interface{}
+	err := toml.Unmarshal(raw, &document)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(document)
+}
+
+func jsonToTOML(raw []byte) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	var document map[string]interface{}
+	err := decoder.Decode(&document)
+	if err != nil {
+		return nil, err
+	}
+
+	var buffer bytes.Buffer
+	err = toml.NewEncoder(&buffer).Encode(tomlValue(document))
+	if err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// tomlValue prepares a decoded JSON value for the TOML encoder. Numbers
+// keep their integer type and null values are omitted as TOML has no
+// representation for them.
+func tomlValue(value interface{}) interface{} {
+	switch value := value.(type) {
+	case json.Number:
+		if integer, err := value.Int64(); err == nil {
+			return integer
+		}
+		float, _ := value.Float64()
+		return float
+	case map[string]interface{}:
+		table := make(map[string]interface{})
+		for key, element := range value {
+			if element != nil {
+				table[key] = tomlValue(element)
+			}
+		}
+		return table
+	case []interface{}:
+		list := make([]interface{}, 0, len(value))
+		for _, element := range value {
+			list = append(list, tomlValue(element))
+		}
+		if len(list) > 0 {
+			if _, tables := list[0].(map[string]interface{}); tables {
+				// Encode lists of tables as [[array]]
+				result := make([]map[string]interface{}, 0, len(list))
+				for _, element := range list {
+					table, _ := element.(map[string]interface{})
+					result = append(result, table)
+				}
+				return result
+			}
+		}
+		return list
+	}
+	return value
+}
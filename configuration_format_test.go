@@ -0,0 +1,55 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestConfigurationFormatForFile(t *testing.T) {
+	tests := map[string]string{
+		"config.json": "json",
+		"config.yaml": "yaml",
+		"config.YML":  "yaml",
+		"config.toml": "toml",
+		"config":      "json",
+	}
+	for filename, expected := range tests {
+		if format := configurationFormatForFile(filename); format.Name != expected {
+			t.Errorf("format of %s should be %s but is %s", filename, expected, format.Name)
+		}
+	}
+}
+
+func TestFormatsRoundTrip(t *testing.T) {
+	reference := Configuration{ConfigurationFile: "testdata/config-example.json"}
+	err := reference.parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, testFile := range []string{"testdata/config-example.yaml", "testdata/config-example.toml"} {
+		c := Configuration{ConfigurationFile: copyTestFile(t, testFile)}
+		err := c.parse()
+		if err != nil {
+			t.Fatalf("could not read %s: %v", testFile, err)
+		}
+		if c.HashValue() != reference.HashValue() {
+			t.Errorf("%s should match the JSON example:\n%+v\n%+v", testFile, c, reference)
+		}
+
+		// Write all values and read them again
+		c.initializeDefaults()
+		c.Hash = ""
+		err = c.Write()
+		if err != nil {
+			t.Fatalf("could not write %s: %v", testFile, err)
+		}
+		written := Configuration{ConfigurationFile: c.ConfigurationFile}
+		err = written.parse()
+		if err != nil {
+			t.Fatalf("could not read written %s: %v", testFile, err)
+		}
+		if written.HashValue() != c.HashValue() {
+			t.Errorf("written %s differs:\n%+v\n%+v", testFile, written, c)
+		}
+	}
+}
@@ -0,0 +1,290 @@
+// MIT License
+//
+// # Copyright (c) 2019 Stefan Wichmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const configurationHistorySuffix = ".history"
+
+// Sources of configuration changes recorded in the history
+const (
+	historySourceDefault     = "default configuration"
+	historySourceFile        = "configuration file"
+	historySourceReload      = "file reload"
+	historySourceMigration   = "migration"
+	historySourceBridge      = "bridge setup"
+	historySourceRediscovery = "bridge rediscovery"
+	historySourceRepin       = "certificate repin"
+)
+
+var configurationHistoryLock sync.Mutex
+
+// ConfigurationHistoryEntry represents a recorded version of the configuration.
+type ConfigurationHistoryEntry struct {
+	ID            int                   `json:"id"`
+	Time          time.Time             `json:"time"`
+	Source        string                `json:"source"`
+	Changes       []ConfigurationChange `json:"changes"`
+	Configuration json.RawMessage       `json:"configuration,omitempty"`
+}
+
+// ConfigurationChange describes the modification of a single value.
+// Old is empty for added and New is empty for removed values.
+type ConfigurationChange struct {
+	Path string      `json:"path"`
+	Old  interface{} `json:"old,omitempty"`
+	New  interface{} `json:"new,omitempty"`
+}
+
+func (configuration *Configuration) historyFile() string {
+	return configuration.ConfigurationFile + configurationHistorySuffix
+}
+
+// History returns all recorded versions of the configuration, newest first.
+func (configuration *Configuration) History() ([]ConfigurationHistoryEntry, error) {
+	configurationHistoryLock.Lock()
+	defer configurationHistoryLock.Unlock()
+	history, err := configuration.readHistory()
+	if err != nil {
+		return history, err
+	}
+	sort.Slice(history, func(i, j int) bool { return history[i].ID > history[j].ID })
+	return history, nil
+}
+
+// HistoryEntry returns the recorded version with the given id.
+func (configuration *Configuration) HistoryEntry(id int) (ConfigurationHistoryEntry, error) {
+	history, err := configuration.History()
+	if err != nil {
+		return ConfigurationHistoryEntry{}, err
+	}
+	for _, entry := range history {
+		if entry.ID == id {
+			return entry, nil
+		}
+	}
+	return ConfigurationHistoryEntry{}, fmt.Errorf("unknown configuration version %d", id)
+}
+
+func (configuration *Configuration) readHistory() ([]ConfigurationHistoryEntry, error) {
+	var history []ConfigurationHistoryEntry
+	if configuration.ConfigurationFile == "" {
+		return history, errors.New("no configuration filename configured")
+	}
+	raw, err := os.ReadFile(configuration.historyFile())
+	if os.IsNotExist(err) {
+		return history, nil
+	}
+	if err != nil {
+		return history, err
+	}
+	err = json.Unmarshal(raw, &history)
+	return history, err
+}
+
+// recordHistory adds the current configuration to the history if it
+// differs from the last recorded version. Values from the environment
+// or command line are not recorded and the bridge username is redacted.
+func (configuration *Configuration) recordHistory(source string) error {
+	snapshot, err := configuration.historySnapshot()
+	if err != nil {
+		return err
+	}
+
+	configurationHistoryLock.Lock()
+	defer configurationHistoryLock.Unlock()
+	history, err := configuration.readHistory()
+	if err != nil {
+		return err
+	}
+
+	entry := ConfigurationHistoryEntry{ID: 1, Time: time.Now(), Source: source, Configuration: snapshot}
+	if len(history) > 0 {
+		last := history[len(history)-1]
+		var previous bytes.Buffer
+		err = json.Compact(&previous, last.Configuration)
+		if err != nil {
+			return err
+		}
+		if bytes.Equal(previous.Bytes(), snapshot) {
+			return nil
+		}
+		entry.ID = last.ID + 1
+		entry.Changes, err = configurationChanges(last.Configuration, snapshot)
+		if err != nil {
+			return err
+		}
+	}
+	log.Debugf("⚙ Recording configuration version %d from %s with %d changes.", entry.ID, source, len(entry.Changes))
+	history = append(history, entry)
+
+	keep := *flagConfigurationHistory
+	if keep < 1 {
+		keep = 1
+	}
+	if len(history) > keep {
+		history = history[len(history)-keep:]
+	}
+
+	raw, err := json.MarshalIndent(history, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(configuration.historyFile(), raw, 0600)
+}
+
+// logHistory records the current configuration and logs failures, as
+// the history must never prevent Kelvin from running.
+func (configuration *Configuration) logHistory(source string) {
+	err := configuration.recordHistory(source)
+	if err != nil {
+		log.Warningf("⚙ Could not record configuration history: %v", err)
+	}
+}
+
+func (configuration *Configuration) historySnapshot() (json.RawMessage, error) {
+	persisted, err := configuration.persisted()
+	if err != nil {
+		return nil, err
+	}
+	for _, bridge := range persisted.configuredBridges() {
+		bridge.Username = redact(bridge.Username)
+	}
+	return json.Marshal(persisted)
+}
+
+// RevertToVersion saves the recorded version with the given id as
+// current configuration. The bridge usernames are not reverted. The
+// running configuration has to be reloaded afterwards.
+func (configuration *Configuration) RevertToVersion(id int, source string) error {
+	entry, err := configuration.HistoryEntry(id)
+	if err != nil {
+		return err
+	}
+	current, err := configuration.persisted()
+	if err != nil {
+		return err
+	}
+
+	reverted := Configuration{
+		ConfigurationFile: configuration.ConfigurationFile,
+		implicitIncludes:  configuration.implicitIncludes,
+		scheduleFiles:     configuration.scheduleFiles,
+	}
+	err = json.Unmarshal(entry.Configuration, &reverted)
+	if err != nil {
+		return err
+	}
+	reverted.Bridge.Username = current.Bridge.Username
+	reverted.Bridge.UsernameFile = current.Bridge.UsernameFile
+	reverted.Bridge.CertificateFingerprint = current.Bridge.CertificateFingerprint
+	for index := range reverted.AdditionalBridges {
+		for _, bridge := range current.AdditionalBridges {
+			if bridge.Name == reverted.AdditionalBridges[index].Name {
+				reverted.AdditionalBridges[index].Username = bridge.Username
+				reverted.AdditionalBridges[index].UsernameFile = bridge.UsernameFile
+				reverted.AdditionalBridges[index].CertificateFingerprint = bridge.CertificateFingerprint
+			}
+		}
+	}
+	err = reverted.Validate()
+	if err != nil {
+		return fmt.Errorf("version %d is invalid: %v", id, err)
+	}
+
+	err = reverted.Write()
+	if err != nil {
+		return err
+	}
+	log.Printf("⚙ Reverted configuration to version %d", id)
+	return reverted.recordHistory(fmt.Sprintf("revert to version %d by %s", id, source))
+}
+
+// configurationChanges compares two configurations and returns the
+// modified values sorted by path.
+func configurationChanges(old json.RawMessage, new json.RawMessage) ([]ConfigurationChange, error) {
+	var oldValue, newValue interface{}
+	err := json.Unmarshal(old, &oldValue)
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(new, &newValue)
+	if err != nil {
+		return nil, err
+	}
+
+	oldValues := make(map[string]interface{})
+	newValues := make(map[string]interface{})
+	flattenConfiguration("", oldValue, oldValues)
+	flattenConfiguration("", newValue, newValues)
+
+	changes := []ConfigurationChange{}
+	for path, value := range oldValues {
+		if updated, found := newValues[path]; !found || !reflect.DeepEqual(value, updated) {
+			changes = append(changes, ConfigurationChange{Path: path, Old: value, New: updated})
+		}
+	}
+	for path, value := range newValues {
+		if _, found := oldValues[path]; !found {
+			changes = append(changes, ConfigurationChange{Path: path, New: value})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	return changes, nil
+}
+
+// flattenConfiguration collects all values by their path, e.g.
+// schedules[0].defaultBrightness. Lists of plain values like
+// associatedDeviceIDs are kept as single value.
+func flattenConfiguration(path string, value interface{}, values map[string]interface{}) {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		for key, child := range value {
+			flattenConfiguration(joinPath(path, key), child, values)
+		}
+	case []interface{}:
+		for _, child := range value {
+			switch child.(type) {
+			case map[string]interface{}, []interface{}:
+				for index, child := range value {
+					flattenConfiguration(fmt.Sprintf("%s[%d]", path, index), child, values)
+				}
+				return
+			}
+		}
+		values[path] = value
+	default:
+		values[path] = value
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestConfigurationHistory(t *testing.T) {
+	file := copyTestFile(t, "testdata/config-example.json")
+	username := "lbCDGagZZ7JEYQX5iGxrjMIx2jIROgpXfsSjHmCv"
+	c := Configuration{ConfigurationFile: file}
+	err := c.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	initial, err := c.History()
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := initial[0].ID
+	c.logHistory(historySourceFile) // unchanged, should not be recorded
+
+	brightness := c.Schedules[0].DefaultBrightness
+	c.Schedules[0].DefaultBrightness = 42
+	c.Bridge.IP = "192.168.10.99"
+	err = c.Write()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.recordHistory("web client 127.0.0.1:1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	history, err := c.History()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != len(initial)+1 {
+		t.Fatalf("history should contain %d versions but contains %d", len(initial)+1, len(history))
+	}
+	latest := history[0]
+	if latest.ID != first+1 || latest.Source != "web client 127.0.0.1:1234" {
+		t.Errorf("unexpected latest version: %d from %s", latest.ID, latest.Source)
+	}
+	var paths []string
+	for _, change := range latest.Changes {
+		paths = append(paths, change.Path)
+	}
+	if strings.Join(paths, ",") != "bridge.ip,schedules[0].defaultBrightness" {
+		t.Errorf("unexpected changes %v", paths)
+	}
+	if latest.Changes[1].New != float64(42) || latest.Changes[1].Old != float64(brightness) {
+		t.Errorf("unexpected change %+v", latest.Changes[1])
+	}
+
+	raw, err := os.ReadFile(c.historyFile())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(raw), username) {
+		t.Errorf("history should not contain the bridge username")
+	}
+
+	// Revert to the version read from the file
+	err = c.RevertToVersion(first, "web client 127.0.0.1:1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+	reverted := Configuration{ConfigurationFile: file}
+	err = reverted.parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reverted.Schedules[0].DefaultBrightness != brightness || reverted.Bridge.IP != "192.168.10.37" {
+		t.Errorf("configuration should be reverted: %+v", reverted)
+	}
+	if reverted.Bridge.Username != username {
+		t.Errorf("bridge username should be kept on revert")
+	}
+	history, err = c.History()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if history[0].ID != first+2 || history[0].Source != fmt.Sprintf("revert to version %d by web client 127.0.0.1:1234", first) {
+		t.Errorf("revert should be recorded: %+v", history[0])
+	}
+
+	err = c.RevertToVersion(7, "test")
+	if err == nil {
+		t.Errorf("revert to an unknown version should fail")
+	}
+}
+
+func TestConfigurationHistoryLimit(t *testing.T) {
+	keep := *flagConfigurationHistory
+	defer func() { *flagConfigurationHistory = keep }()
+	*flagConfigurationHistory = 3
+
+	c := Configuration{ConfigurationFile: copyTestFile(t, "testdata/config-example.json")}
+	err := c.parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for brightness := 10; brightness < 60; brightness += 10 {
+		c.Schedules[0].DefaultBrightness = brightness
+		err = c.recordHistory("test")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	history, err := c.History()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 3 || history[0].ID != 5 || history[2].ID != 3 {
+		t.Errorf("history should keep the last 3 versions: %+v", history)
+	}
+}
+
+func TestConfigurationChanges(t *testing.T) {
+	old := []byte(`{"bridge": {"ip": "a"}, "schedules": [{"name": "default", "associatedDeviceIDs": [1, 2]}]}`)
+	updated := []byte(`{"bridge": {"ip": "a"}, "schedules": [{"name": "default", "associatedDeviceIDs": [1, 3]}, {"name": "new"}]}`)
+	changes, err := configurationChanges(old, updated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 2 {
+		t.Fatalf("expected 2 changes but got %+v", changes)
+	}
+	if changes[0].Path != "schedules[0].associatedDeviceIDs" || historyValue(changes[0].Old) != "[1,2]" || historyValue(changes[0].New) != "[1,3]" {
+		t.Errorf("unexpected change %+v", changes[0])
+	}
+	if changes[1].Path != "schedules[1].name" || changes[1].Old != nil || changes[1].New != "new" {
+		t.Errorf("unexpected change %+v", changes[1])
+	}
+}
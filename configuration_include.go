@@ -0,0 +1,244 @@
+// MIT License
+//
+// # Copyright (c) 2019 Stefan Wichmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// configurationFilenames are searched if the configuration points to a directory.
+var configurationFilenames = []string{"config.json", "config.yaml", "config.yml", "config.toml"}
+
+// IncludedSchedules is the content of an included configuration file.
+type IncludedSchedules struct {
+	Schedules []LightSchedule `json:"schedules"`
+}
+
+// newConfiguration returns an empty configuration for the given path. If
+// the path is a directory, its config file is used and all other
+// configuration files in the directory will be included.
+func newConfiguration(path string) Configuration {
+	var configuration Configuration
+	configuration.ConfigurationFile = path
+
+	info, err := os.Stat(path)
+	if err != nil || !info.IsDir() {
+		return configuration
+	}
+	configuration.ConfigurationFile = filepath.Join(path, configurationFilenames[0])
+	for _, filename := range configurationFilenames {
+		candidate := filepath.Join(path, filename)
+		if _, err := os.Stat(candidate); err == nil {
+			configuration.ConfigurationFile = candidate
+			break
+		}
+	}
+	configuration.implicitIncludes = []string{"*"}
+	return configuration
+}
+
+// includedFiles returns all files matching the include patterns sorted by
+// name. Only files with a known configuration format are included.
+func (configuration *Configuration) includedFiles() ([]string, error) {
+	main := absolutePath(configuration.ConfigurationFile)
+	found := make(map[string]bool)
+	var files []string
+	for _, pattern := range append(append([]string{}, configuration.implicitIncludes...), configuration.Include...) {
+		matches, err := filepath.Glob(configuration.resolvePath(pattern))
+		if err != nil {
+			return files, fmt.Errorf("invalid include pattern %q: %v", pattern, err)
+		}
+		for _, match := range matches {
+			match = absolutePath(match)
+			if match == main || found[match] || !isIncludableFile(match) {
+				continue
+			}
+			found[match] = true
+			files = append(files, match)
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+func isIncludableFile(filename string) bool {
+	info, err := os.Stat(filename)
+	if err != nil || !info.Mode().IsRegular() {
+		return false
+	}
+	extension := strings.ToLower(filepath.Ext(filename))
+	for _, format := range configurationFormats {
+		for _, candidate := range format.Extensions {
+			if candidate == extension {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// loadIncludes appends the schedules of all included files and remembers
+// which file owns every schedule.
+func (configuration *Configuration) loadIncludes() error {
+	configuration.scheduleFiles = make(map[string]string)
+	for _, schedule := range configuration.Schedules {
+		configuration.scheduleFiles[schedule.Name] = configuration.ConfigurationFile
+	}
+
+	files, err := configuration.includedFiles()
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		included, err := readIncludedSchedules(file)
+		if err != nil {
+			return fmt.Errorf("could not read included file %s: %v", file, err)
+		}
+		for _, schedule := range included.Schedules {
+			if owner, found := configuration.scheduleFiles[schedule.Name]; found {
+				return fmt.Errorf("schedule %q is defined in %s and %s", schedule.Name, owner, file)
+			}
+			configuration.scheduleFiles[schedule.Name] = file
+			configuration.Schedules = append(configuration.Schedules, schedule)
+		}
+		log.Debugf("⚙ Included %d schedules from %s", len(included.Schedules), file)
+	}
+
+	return configuration.validateLightAssignments()
+}
+
+func readIncludedSchedules(filename string) (IncludedSchedules, error) {
+	var included IncludedSchedules
+	raw, err := os.ReadFile(filename)
+	if err != nil {
+		return included, err
+	}
+	raw, err = configurationFormatForFile(filename).ToJSON(raw)
+	if err != nil {
+		return included, err
+	}
+
+	var document map[string]interface{}
+	err = json.Unmarshal(raw, &document)
+	if err != nil {
+		return included, err
+	}
+	for key := range document {
+		if !strings.EqualFold(key, "schedules") {
+			log.Warningf("⚙ Ignoring field %s in included file %s. Only schedules can be included.", key, filename)
+		}
+	}
+	err = json.Unmarshal(raw, &included)
+	return included, err
+}
+
+// validateLightAssignments ensures every light is associated with only
+// one schedule.
+func (configuration *Configuration) validateLightAssignments() error {
+	owners := make(map[DeviceID]string)
+	for _, schedule := range configuration.Schedules {
+		for _, id := range schedule.AssociatedDeviceIDs {
+			id = configuration.normalizeDeviceID(id)
+			if owner, found := owners[id]; found && owner != schedule.Name {
+				if configuration.scheduleFile(owner) == configuration.scheduleFile(schedule.Name) {
+					log.Warningf("⚙ Light %s is associated with schedule %q and %q. Using %q...", id, owner, schedule.Name, owner)
+					continue
+				}
+				return fmt.Errorf("light %s is associated with schedule %q (%s) and %q (%s)", id, owner, configuration.scheduleFile(owner), schedule.Name, configuration.scheduleFile(schedule.Name))
+			}
+			owners[id] = schedule.Name
+		}
+	}
+	return nil
+}
+
+// scheduleFile returns the file the given schedule is saved in.
+func (configuration *Configuration) scheduleFile(name string) string {
+	if file, found := configuration.scheduleFiles[name]; found {
+		return file
+	}
+	return configuration.ConfigurationFile
+}
+
+// saveIncludedSchedules writes all schedules owned by included files to
+// these files if they changed and removes them from persisted.
+func (configuration *Configuration) saveIncludedSchedules(persisted *Configuration) error {
+	var main []LightSchedule
+	included := make(map[string][]LightSchedule)
+	for _, file := range configuration.scheduleFiles {
+		if file != configuration.ConfigurationFile {
+			included[file] = []LightSchedule{}
+		}
+	}
+	for _, schedule := range persisted.Schedules {
+		file := configuration.scheduleFile(schedule.Name)
+		if file == configuration.ConfigurationFile {
+			main = append(main, schedule)
+		} else {
+			included[file] = append(included[file], schedule)
+		}
+	}
+	persisted.Schedules = main
+
+	for file, schedules := range included {
+		current, err := readIncludedSchedules(file)
+		if err == nil && reflect.DeepEqual(current.Schedules, schedules) {
+			continue
+		}
+		raw, err := encodeConfigurationFile(file, IncludedSchedules{schedules})
+		if err != nil {
+			return err
+		}
+		log.Debugf("⚙ Saving schedules to included file %s", file)
+		err = writeFileAtomic(file, raw, 0644)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// watchedFiles returns all files and directories which affect the configuration.
+func (configuration *Configuration) watchedFiles() []string {
+	files := []string{configuration.ConfigurationFile}
+	included, err := configuration.includedFiles()
+	if err == nil {
+		files = append(files, included...)
+	}
+	directories := make(map[string]bool)
+	for _, pattern := range append(append([]string{}, configuration.implicitIncludes...), configuration.Include...) {
+		directory := filepath.Dir(configuration.resolvePath(pattern))
+		if !directories[directory] {
+			directories[directory] = true
+			files = append(files, directory)
+		}
+	}
+	return files
+}
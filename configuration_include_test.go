@@ -0,0 +1,114 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeTestFile(t *testing.T, filename string, content string) {
+	t.Helper()
+	err := os.MkdirAll(filepath.Dir(filename), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filename, []byte(content), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConfigurationDirectory(t *testing.T) {
+	directory := t.TempDir()
+	writeTestFile(t, filepath.Join(directory, "config.json"), `{"version": 1, "schedules": [{"name": "default", "associatedDeviceIDs": [1], "defaultColorTemperature": 2750, "defaultBrightness": 100}]}`)
+	writeTestFile(t, filepath.Join(directory, "livingroom.yaml"), "# Living room\nschedules:\n- name: livingroom\n  associatedDeviceIDs: [3, 4]\n  defaultColorTemperature: 2700\n  defaultBrightness: 100\n")
+	writeTestFile(t, filepath.Join(directory, "bedroom.json"), `{"schedules": [{"name": "bedroom", "associatedDeviceIDs": [2], "defaultColorTemperature": 2500, "defaultBrightness": 80}]}`)
+
+	c := newConfiguration(directory)
+	err := c.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, schedule := range c.Schedules {
+		names = append(names, schedule.Name)
+	}
+	if strings.Join(names, ",") != "default,bedroom,livingroom" {
+		t.Fatalf("schedules should be merged in file order but are %v", names)
+	}
+
+	// Only the owning file is updated
+	livingroom, err := os.ReadFile(filepath.Join(directory, "livingroom.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Schedules[1].DefaultBrightness = 50
+	err = c.Write()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bedroom, err := readIncludedSchedules(filepath.Join(directory, "bedroom.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bedroom.Schedules) != 1 || bedroom.Schedules[0].DefaultBrightness != 50 {
+		t.Errorf("bedroom.json should contain the changed schedule: %+v", bedroom)
+	}
+	unchanged, err := os.ReadFile(filepath.Join(directory, "livingroom.yaml"))
+	if err != nil || string(unchanged) != string(livingroom) {
+		t.Errorf("livingroom.yaml should not be modified")
+	}
+	main := Configuration{ConfigurationFile: filepath.Join(directory, "config.json")}
+	err = main.decode(mustReadFile(t, main.ConfigurationFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(main.Schedules) != 1 || main.Schedules[0].Name != "default" {
+		t.Errorf("config.json should only contain its own schedules: %+v", main.Schedules)
+	}
+}
+
+func TestIncludeList(t *testing.T) {
+	directory := t.TempDir()
+	writeTestFile(t, filepath.Join(directory, "config.yaml"), "version: 1\ninclude:\n- rooms/*.yaml\nschedules:\n- name: default\n  associatedDeviceIDs: [1]\n")
+	writeTestFile(t, filepath.Join(directory, "rooms", "kitchen.yaml"), "schedules:\n- name: kitchen\n  associatedDeviceIDs: [5]\n")
+	writeTestFile(t, filepath.Join(directory, "rooms", "notes.txt"), "not a configuration")
+
+	c := newConfiguration(filepath.Join(directory, "config.yaml"))
+	err := c.parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c.Schedules) != 2 || c.scheduleFile("kitchen") != filepath.Join(directory, "rooms", "kitchen.yaml") {
+		t.Errorf("kitchen schedule should be included: %+v", c.Schedules)
+	}
+}
+
+func TestConflictingLightAssignments(t *testing.T) {
+	directory := t.TempDir()
+	writeTestFile(t, filepath.Join(directory, "config.json"), `{"version": 1, "schedules": [{"name": "default", "associatedDeviceIDs": [1, 2]}]}`)
+	writeTestFile(t, filepath.Join(directory, "office.json"), `{"schedules": [{"name": "office", "associatedDeviceIDs": [2]}]}`)
+	c := newConfiguration(directory)
+	err := c.parse()
+	if err == nil || !strings.Contains(err.Error(), "light 2") {
+		t.Errorf("conflicting light assignment should be detected: %v", err)
+	}
+
+	writeTestFile(t, filepath.Join(directory, "office.json"), `{"schedules": [{"name": "default", "associatedDeviceIDs": [3]}]}`)
+	c = newConfiguration(directory)
+	err = c.parse()
+	if err == nil {
+		t.Errorf("duplicate schedule names should be detected")
+	}
+}
+
+func mustReadFile(t *testing.T, filename string) []byte {
+	t.Helper()
+	raw, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
@@ -0,0 +1,384 @@
+// MIT License
+//
+// # Copyright (c) 2019 Stefan Wichmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Configuration values are loaded in layers. Every layer overrides the
+// values of the previous ones.
+const (
+	sourceDefault     = "default"
+	sourceFile        = "file"
+	sourceSecretsFile = "secrets file"
+	sourceCredential  = "credential"
+	sourceEnvironment = "environment"
+	sourceFlag        = "flag"
+)
+
+const environmentPrefix = "KELVIN_"
+
+// configurationOverride replaces a configuration value without changing
+// the configuration file.
+type configurationOverride struct {
+	Path     string
+	Value    string
+	Source   string
+	original json.RawMessage
+}
+
+// overrideFlag collects all -set parameters.
+type overrideFlag []string
+
+func (values *overrideFlag) String() string {
+	return strings.Join(*values, ",")
+}
+
+func (values *overrideFlag) Set(value string) error {
+	*values = append(*values, value)
+	return nil
+}
+
+// configurationPaths returns the paths of all configuration values which
+// can be overridden (e.g. "bridge.ip"). Schedules can only be configured
+// in the configuration file.
+func configurationPaths() []string {
+	var paths []string
+	collectConfigurationPaths(reflect.TypeOf(Configuration{}), "", &paths)
+	return paths
+}
+
+func collectConfigurationPaths(typ reflect.Type, path string, paths *[]string) {
+	for _, field := range jsonFields(typ) {
+		fieldPath := joinPath(path, jsonName(field))
+		switch {
+		case field.Type.Kind() == reflect.Struct:
+			collectConfigurationPaths(field.Type, fieldPath, paths)
+		case field.Type.Kind() == reflect.Slice && field.Type.Elem().Kind() == reflect.Struct:
+			continue
+		default:
+			*paths = append(*paths, fieldPath)
+		}
+	}
+}
+
+// environmentVariable returns the name of the environment variable for the
+// given path, e.g. KELVIN_AWAY_MODE_ENABLED for awayMode.enabled.
+func environmentVariable(path string) string {
+	var name strings.Builder
+	name.WriteString(environmentPrefix)
+	runes := []rune(path)
+	for index, r := range runes {
+		switch {
+		case r == '.':
+			name.WriteRune('_')
+		case unicode.IsUpper(r) && index > 0 && (unicode.IsLower(runes[index-1]) || unicode.IsDigit(runes[index-1])):
+			name.WriteRune('_')
+			name.WriteRune(r)
+		default:
+			name.WriteRune(unicode.ToUpper(r))
+		}
+	}
+	return name.String()
+}
+
+// environmentOverrides returns the overrides defined by KELVIN_*
+// environment variables.
+func environmentOverrides(environment []string) []configurationOverride {
+	values := make(map[string]string)
+	for _, variable := range environment {
+		name, value, found := strings.Cut(variable, "=")
+		if found && strings.HasPrefix(name, environmentPrefix) {
+			values[name] = value
+		}
+	}
+
+	var overrides []configurationOverride
+	for _, path := range configurationPaths() {
+		if value, found := values[environmentVariable(path)]; found {
+			overrides = append(overrides, configurationOverride{Path: path, Value: value, Source: sourceEnvironment})
+		}
+	}
+	return overrides
+}
+
+// parseFlagOverrides returns the overrides defined by the given -set
+// parameters in the form path=value.
+func parseFlagOverrides(values []string) ([]configurationOverride, error) {
+	var overrides []configurationOverride
+	for _, value := range values {
+		path, value, found := strings.Cut(value, "=")
+		if !found {
+			return overrides, fmt.Errorf("invalid parameter %q, expected path=value", path)
+		}
+		overrides = append(overrides, configurationOverride{Path: strings.TrimSpace(path), Value: value, Source: sourceFlag})
+	}
+	return overrides, nil
+}
+
+// applyOverrides sets the given overrides in order. Their values will not
+// be written to the configuration file.
+func (configuration *Configuration) applyOverrides(overrides []configurationOverride) error {
+	for _, override := range overrides {
+		field, err := configurationField(configuration, override.Path)
+		if err != nil {
+			return err
+		}
+
+		// Keep the value of the file for Write, even if overridden twice
+		original, err := json.Marshal(field.Interface())
+		if err != nil {
+			return err
+		}
+		for _, previous := range configuration.overrides {
+			if previous.Path == override.Path {
+				original = previous.original
+			}
+		}
+
+		err = setFieldFromString(field, override.Value)
+		if err != nil {
+			return fmt.Errorf("invalid value %q for %s (%s): %v", override.Value, override.Path, override.Source, err)
+		}
+		override.original = original
+		configuration.overrides = append(configuration.overrides, override)
+		configuration.setSource(override.Path, override.Source)
+		log.Debugf("⚙ Using %s from %s", override.Path, override.Source)
+	}
+	return nil
+}
+
+// persisted returns a copy of the configuration with all overridden values
+// reset to the values of the configuration file.
+func (configuration *Configuration) persisted() (Configuration, error) {
+	var persisted Configuration
+	raw, err := json.Marshal(configuration)
+	if err != nil {
+		return persisted, err
+	}
+	err = json.Unmarshal(raw, &persisted)
+	if err != nil {
+		return persisted, err
+	}
+
+	for _, override := range configuration.overrides {
+		field, err := configurationField(&persisted, override.Path)
+		if err != nil {
+			return persisted, err
+		}
+		value := reflect.New(field.Type())
+		err = json.Unmarshal(override.original, value.Interface())
+		if err != nil {
+			return persisted, err
+		}
+		field.Set(value.Elem())
+	}
+	return persisted, nil
+}
+
+// OverrideSource returns the layer which overrides the value of the given
+// path or an empty string if the value is taken from the configuration
+// file. Overridden values can't be changed in the web interface.
+func (configuration *Configuration) OverrideSource(path string) string {
+	source := ""
+	for _, override := range configuration.overrides {
+		if override.Path == path {
+			source = override.Source
+		}
+	}
+	return source
+}
+
+// overriddenChanges returns a description of every overridden value which
+// differs in the given configuration. These changes would be lost on
+// Write.
+func (configuration *Configuration) overriddenChanges(changed *Configuration) []string {
+	var changes []string
+	for _, path := range configurationPaths() {
+		source := configuration.OverrideSource(path)
+		if source == "" {
+			continue
+		}
+		current, err := configurationField(configuration, path)
+		if err != nil {
+			continue
+		}
+		value, err := configurationField(changed, path)
+		if err != nil {
+			continue
+		}
+		if !reflect.DeepEqual(current.Interface(), value.Interface()) {
+			changes = append(changes, fmt.Sprintf("%s is set by %s", path, source))
+		}
+	}
+	return changes
+}
+
+// Source returns the layer which provided the value of the given path.
+func (configuration *Configuration) Source(path string) string {
+	if source, found := configuration.Sources[path]; found {
+		return source
+	}
+	return sourceDefault
+}
+
+func (configuration *Configuration) setSource(path string, source string) {
+	if configuration.Sources == nil {
+		configuration.Sources = make(map[string]string)
+	}
+	configuration.Sources[path] = source
+}
+
+// recordFileSources marks all values present in the given JSON document
+// as provided by the configuration file.
+func (configuration *Configuration) recordFileSources(raw []byte) {
+	var document map[string]interface{}
+	if json.Unmarshal(raw, &document) != nil {
+		return
+	}
+	for _, path := range append(configurationPaths(), "schedules") {
+		if documentContains(document, path) {
+			configuration.setSource(path, sourceFile)
+		}
+	}
+}
+
+func documentContains(document map[string]interface{}, path string) bool {
+	var current interface{} = document
+	for _, key := range strings.Split(path, ".") {
+		object, ok := current.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		found := false
+		for candidate, value := range object {
+			if strings.EqualFold(candidate, key) {
+				current = value
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// SourceList returns the source of every configuration value sorted by path.
+func (configuration *Configuration) SourceList() [][2]string {
+	paths := append(configurationPaths(), "schedules")
+	sort.Strings(paths)
+	var sources [][2]string
+	for _, path := range paths {
+		sources = append(sources, [2]string{path, configuration.Source(path)})
+	}
+	return sources
+}
+
+func configurationField(configuration *Configuration, path string) (reflect.Value, error) {
+	value := reflect.ValueOf(configuration).Elem()
+	for _, key := range strings.Split(path, ".") {
+		if value.Kind() != reflect.Struct {
+			return value, fmt.Errorf("unknown configuration value %s", path)
+		}
+		field, found := jsonField(value.Type(), key)
+		if !found {
+			return value, fmt.Errorf("unknown configuration value %s", path)
+		}
+		value = value.FieldByIndex(field.Index)
+	}
+	if value.Kind() == reflect.Struct || (value.Kind() == reflect.Slice && value.Type().Elem().Kind() == reflect.Struct) {
+		return value, fmt.Errorf("configuration value %s can't be overridden", path)
+	}
+	return value, nil
+}
+
+func setFieldFromString(field reflect.Value, value string) error {
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Bool:
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		field.SetBool(parsed)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		parsed, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return err
+		}
+		field.SetInt(parsed)
+	case reflect.Float32, reflect.Float64:
+		parsed, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return err
+		}
+		field.SetFloat(parsed)
+	case reflect.Slice:
+		// Comma separated list, e.g. 1,2,3
+		slice := reflect.MakeSlice(field.Type(), 0, 0)
+		for _, element := range strings.Split(value, ",") {
+			element = strings.TrimSpace(element)
+			if element == "" {
+				continue
+			}
+			item := reflect.New(field.Type().Elem()).Elem()
+			err := setFieldFromString(item, element)
+			if err != nil {
+				return err
+			}
+			slice = reflect.Append(slice, item)
+		}
+		field.Set(slice)
+	default:
+		return fmt.Errorf("unsupported type %s", field.Type())
+	}
+	return nil
+}
+
+// startupOverrides returns the overrides of the environment and the
+// command line parameters of the running process.
+func startupOverrides(enableWebInterface bool) ([]configurationOverride, error) {
+	overrides := credentialOverrides(os.Getenv("CREDENTIALS_DIRECTORY"))
+	overrides = append(overrides, environmentOverrides(os.Environ())...)
+	flags, err := parseFlagOverrides(flagOverrideValues)
+	if err != nil {
+		return overrides, err
+	}
+	overrides = append(overrides, flags...)
+	if enableWebInterface {
+		overrides = append(overrides, configurationOverride{Path: "webinterface.enabled", Value: "true", Source: sourceFlag})
+	}
+	return overrides, nil
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestEnvironmentVariable(t *testing.T) {
+	tests := map[string]string{
+		"bridge.ip":                    "KELVIN_BRIDGE_IP",
+		"webinterface.port":            "KELVIN_WEBINTERFACE_PORT",
+		"location.latitude":            "KELVIN_LOCATION_LATITUDE",
+		"awayMode.associatedDeviceIDs": "KELVIN_AWAY_MODE_ASSOCIATED_DEVICE_IDS",
+		"weather.cloudCoverThreshold":  "KELVIN_WEATHER_CLOUD_COVER_THRESHOLD",
+	}
+	for path, expected := range tests {
+		if name := environmentVariable(path); name != expected {
+			t.Errorf("environment variable for %s should be %s but is %s", path, expected, name)
+		}
+	}
+}
+
+func TestOverridesAreNotPersisted(t *testing.T) {
+	file := copyTestFile(t, "testdata/config-example.json")
+	t.Setenv("KELVIN_BRIDGE_IP", "10.0.0.2")
+	t.Setenv("KELVIN_WEBINTERFACE_PORT", "9090")
+
+	c, err := InitializeConfiguration(file, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Bridge.IP != "10.0.0.2" || c.WebInterface.Port != 9090 || !c.WebInterface.Enabled {
+		t.Errorf("overrides were not applied: %+v", c)
+	}
+	if c.Source("bridge.ip") != sourceEnvironment || c.Source("webinterface.enabled") != sourceFlag || c.Source("schedules") != sourceFile || c.Source("timezone") != sourceDefault {
+		t.Errorf("unexpected sources: %v", c.Sources)
+	}
+
+	// Change another value and save
+	c.Timezone = "Europe/Berlin"
+	err = c.Write()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	saved := Configuration{ConfigurationFile: file}
+	err = saved.parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.Bridge.IP == "10.0.0.2" || saved.WebInterface.Port == 9090 || saved.WebInterface.Enabled {
+		t.Errorf("overrides should not be written to the configuration file: %+v", saved)
+	}
+	if saved.Timezone != "Europe/Berlin" {
+		t.Errorf("changed value should be written to the configuration file")
+	}
+}
+
+func TestInvalidOverride(t *testing.T) {
+	c := Configuration{}
+	err := c.applyOverrides([]configurationOverride{{Path: "webinterface.port", Value: "http", Source: sourceFlag}})
+	if err == nil {
+		t.Errorf("invalid value should be rejected")
+	}
+	err = c.applyOverrides([]configurationOverride{{Path: "bridge.address", Value: "10.0.0.2", Source: sourceFlag}})
+	if err == nil {
+		t.Errorf("unknown path should be rejected")
+	}
+	_, err = parseFlagOverrides([]string{"bridge.ip"})
+	if err == nil {
+		t.Errorf("parameter without value should be rejected")
+	}
+}
+
+func TestWebEditsOfOverriddenValues(t *testing.T) {
+	file := copyTestFile(t, "testdata/config-example.json")
+	t.Setenv("KELVIN_BRIDGE_IP", "10.0.0.2")
+	c, err := InitializeConfiguration(file, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := configuration
+	t.Cleanup(func() { configuration = previous })
+	configuration = &c
+	runMainLoop(t)
+
+	// The configuration page marks overridden values
+	recorder := httptest.NewRecorder()
+	onMainLoop(configurationHandler)(recorder, httptest.NewRequest("GET", "/configuration.html", nil))
+	if page := recorder.Body.String(); !strings.Contains(page, `id="ip" readonly title="Set by environment"`) || strings.Contains(page, `id="timezone" readonly`) {
+		t.Errorf("configuration page should only mark the overridden bridge IP as read only")
+	}
+
+	update := func(ip string, timezone string) *httptest.ResponseRecorder {
+		body := `{"bridge": {"ip": "` + ip + `"}, "location": {"latitude": 53.5553, "longitude": 9.995}, "timezone": "` + timezone + `", "webinterface": {"enabled": true, "port": 8080}}`
+		recorder := httptest.NewRecorder()
+		updateConfigurationHandler(recorder, httptest.NewRequest("PUT", "/configuration", strings.NewReader(body)))
+		return recorder
+	}
+
+	// Changing an overridden value is rejected instead of silently dropped
+	if recorder := update("10.0.0.3", "Europe/Berlin"); recorder.Code != http.StatusConflict || !strings.Contains(recorder.Body.String(), "bridge.ip is set by environment") {
+		t.Errorf("change of overridden bridge IP should be rejected but got %d: %s", recorder.Code, recorder.Body.String())
+	}
+	if configuration.Timezone == "Europe/Berlin" {
+		t.Errorf("rejected update should not change the configuration")
+	}
+
+	// Other values can be changed as long as the overridden ones are kept
+	configuration.Bridge.GroupCommands = true
+	if recorder := update("10.0.0.2", "Europe/Berlin"); recorder.Code != http.StatusOK {
+		t.Errorf("update of the time zone should succeed but got %d: %s", recorder.Code, recorder.Body.String())
+	}
+	saved := Configuration{ConfigurationFile: file}
+	if err := saved.parse(); err != nil {
+		t.Fatal(err)
+	}
+	if saved.Timezone != "Europe/Berlin" || saved.Bridge.IP == "10.0.0.2" {
+		t.Errorf("only the time zone should be written (Timezone: %s, IP: %s)", saved.Timezone, saved.Bridge.IP)
+	}
+	if !saved.Bridge.GroupCommands {
+		t.Errorf("bridge settings not shown in the web interface should be kept")
+	}
+}
@@ -22,22 +22,102 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
+	"strings"
 	"time"
 
 	log "github.com/sirupsen/logrus"
 )
 
+// configurationMigration converts a configuration of the given version to
+// the next version.
+type configurationMigration struct {
+	Version     int
+	Description string
+	Migrate     func(configuration *Configuration)
+}
+
+// configurationMigrations contains all migrations ordered by version. Add
+// new migrations at the end, the latest configuration version will follow.
+var configurationMigrations = []configurationMigration{
+	{0, "Convert timestamps to 24h format, add web interface settings and enable Kelvin when lights appear", (*Configuration).migrateVersion0},
+}
+
+// latestConfigurationVersion is the version of configurations after all migrations.
+var latestConfigurationVersion = len(configurationMigrations)
+
+// MigrationStep describes the changes of a single migration.
+type MigrationStep struct {
+	From        int
+	To          int
+	Description string
+	Diff        string
+}
+
+// pendingMigrations returns all migrations needed to reach the latest version.
+func (configuration *Configuration) pendingMigrations() []configurationMigration {
+	var pending []configurationMigration
+	for _, migration := range configurationMigrations {
+		if migration.Version >= configuration.Version {
+			pending = append(pending, migration)
+		}
+	}
+	return pending
+}
+
 func (configuration *Configuration) migrateToLatestVersion() {
 	log.Debugf("⚙ Migrating configuration to latest version...")
-	if configuration.Version == 0 {
-		configuration.migrateVersion0()
+	for _, migration := range configuration.pendingMigrations() {
+		log.Printf("⚙ Migrating configuration from version %d to %d: %s", migration.Version, migration.Version+1, migration.Description)
+		migration.Migrate(configuration)
+		configuration.Version = migration.Version + 1
 	}
 	log.Debugf("⚙ Migration of configuration complete")
 }
 
+// PlanMigrations returns the pending migrations including the changes each
+// of them would apply to the configuration file. The configuration
+// itself is not modified.
+func (configuration *Configuration) PlanMigrations() ([]MigrationStep, error) {
+	var steps []MigrationStep
+	current, err := configuration.persisted()
+	if err != nil {
+		return steps, err
+	}
+	current.ConfigurationFile = configuration.ConfigurationFile
+	for _, bridge := range current.configuredBridges() {
+		bridge.Username = redact(bridge.Username)
+	}
+	format := configurationFormatForFile(configuration.ConfigurationFile)
+
+	before, err := current.encode(format)
+	if err != nil {
+		return steps, err
+	}
+	for _, migration := range current.pendingMigrations() {
+		migration.Migrate(&current)
+		current.Version = migration.Version + 1
+		after, err := current.encode(format)
+		if err != nil {
+			return steps, err
+		}
+		steps = append(steps, MigrationStep{migration.Version, migration.Version + 1, migration.Description, lineDiff(before, after)})
+		before = after
+	}
+	return steps, nil
+}
+
+func (configuration *Configuration) encode(format ConfigurationFormat) (string, error) {
+	raw, err := json.MarshalIndent(configuration, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	raw, err = format.FromJSON(raw)
+	return string(raw), err
+}
+
 func (configuration *Configuration) migrateVersion0() {
-	log.Debugf("⚙ Migrating configuration version 0 to version 1...")
 
 	// Migrate to new timestamp format
 	for scheduleIndex := range configuration.Schedules {
@@ -71,8 +151,6 @@ func (configuration *Configuration) migrateVersion0() {
 		configuration.Schedules[scheduleIndex].EnableWhenLightsAppear = true
 	}
 
-	configuration.Version = 1
-	log.Debugf("⚙ Migration to version 1 complete")
 }
 
 func migrateTimestampFormat(timestamp string) (string, error) {
@@ -93,3 +171,70 @@ func migrateTimestampFormat(timestamp string) (string, error) {
 
 	return "", fmt.Errorf("invalid timestamp format: %s", timestamp)
 }
+
+// lineDiff returns a unified diff of the lines of a and b with three
+// lines of context.
+func lineDiff(a string, b string) string {
+	before := strings.Split(strings.TrimSuffix(a, "\n"), "\n")
+	after := strings.Split(strings.TrimSuffix(b, "\n"), "\n")
+
+	// Longest common subsequence of lines
+	lcs := make([][]int, len(before)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(after)+1)
+	}
+	for i := len(before) - 1; i >= 0; i-- {
+		for j := len(after) - 1; j >= 0; j-- {
+			if before[i] == after[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	type line struct {
+		prefix string
+		text   string
+	}
+	var lines []line
+	i, j := 0, 0
+	for i < len(before) || j < len(after) {
+		switch {
+		case i < len(before) && j < len(after) && before[i] == after[j]:
+			lines = append(lines, line{" ", before[i]})
+			i++
+			j++
+		case i < len(before) && (j == len(after) || lcs[i+1][j] >= lcs[i][j+1]):
+			lines = append(lines, line{"-", before[i]})
+			i++
+		default:
+			lines = append(lines, line{"+", after[j]})
+			j++
+		}
+	}
+
+	// Only print changed lines and their context
+	const context = 3
+	var diff strings.Builder
+	lastPrinted := -1
+	for index, current := range lines {
+		visible := false
+		for distance := -context; distance <= context; distance++ {
+			neighbour := index + distance
+			if neighbour >= 0 && neighbour < len(lines) && lines[neighbour].prefix != " " {
+				visible = true
+				break
+			}
+		}
+		if !visible {
+			continue
+		}
+		if lastPrinted != -1 && index > lastPrinted+1 {
+			diff.WriteString("@@\n")
+		}
+		diff.WriteString(current.prefix + current.text + "\n")
+		lastPrinted = index
+	}
+	return diff.String()
+}
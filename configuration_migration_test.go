@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var updateGoldenFiles = flag.Bool("update", false, "Update golden files in testdata")
+
+// TestMigrationGoldenFiles migrates all old configurations in
+// testdata/migrations and compares the result with the golden files.
+// Run go test -update to regenerate them after adding a migration.
+func TestMigrationGoldenFiles(t *testing.T) {
+	inputs, err := filepath.Glob("testdata/migrations/version*.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, input := range inputs {
+		if strings.HasSuffix(input, ".golden.json") {
+			continue
+		}
+		c := Configuration{ConfigurationFile: input}
+		err := c.parse()
+		if err != nil {
+			t.Fatalf("could not read %s: %v", input, err)
+		}
+		c.migrateToLatestVersion()
+		if c.Version != latestConfigurationVersion {
+			t.Errorf("%s: migrated to version %d instead of %d", input, c.Version, latestConfigurationVersion)
+		}
+
+		migrated, err := json.MarshalIndent(c, "", "  ")
+		if err != nil {
+			t.Fatal(err)
+		}
+		golden := strings.TrimSuffix(input, ".json") + ".golden.json"
+		if *updateGoldenFiles {
+			err = os.WriteFile(golden, append(migrated, '\n'), 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		expected, err := os.ReadFile(golden)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(expected) != string(migrated)+"\n" {
+			t.Errorf("%s: migrated configuration differs from %s:\n%s", input, golden, lineDiff(string(expected), string(migrated)+"\n"))
+		}
+	}
+}
+
+func TestLatestVersionIsNotMigrated(t *testing.T) {
+	c := Configuration{}
+	c.initializeDefaults()
+	if len(c.pendingMigrations()) != 0 {
+		t.Errorf("default configuration should not need migrations")
+	}
+	c.Schedules[0].EnableWhenLightsAppear = false
+	c.migrateToLatestVersion()
+	if c.Schedules[0].EnableWhenLightsAppear {
+		t.Errorf("migrations should not be applied to the latest version")
+	}
+}
+
+func TestPlanMigrations(t *testing.T) {
+	c := Configuration{ConfigurationFile: "testdata/migrations/version0.json"}
+	err := c.parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	steps, err := c.PlanMigrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(steps) != len(configurationMigrations) {
+		t.Fatalf("expected %d migration steps but got %d", len(configurationMigrations), len(steps))
+	}
+	if !strings.Contains(steps[0].Diff, `-          "time": "8:00PM",`) || !strings.Contains(steps[0].Diff, `+          "time": "20:00",`) {
+		t.Errorf("diff should contain the converted timestamp:\n%s", steps[0].Diff)
+	}
+	if c.Version != 0 || c.Schedules[0].AfterSunset[0].Time != "8:00PM" {
+		t.Errorf("planning migrations should not modify the configuration")
+	}
+}
+
+func TestMigrationCreatesSingleBackup(t *testing.T) {
+	c := Configuration{ConfigurationFile: copyTestFile(t, "testdata/migrations/version0.json")}
+	err := c.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Version != latestConfigurationVersion {
+		t.Errorf("configuration should be migrated to version %d but is at %d", latestConfigurationVersion, c.Version)
+	}
+	backups, err := c.Backups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 1 {
+		t.Errorf("expected a single backup of the original file but found %d", len(backups))
+	}
+}
+
+func TestLineDiff(t *testing.T) {
+	diff := lineDiff("a\nb\nc\n", "a\nx\nc\nd\n")
+	expected := " a\n-b\n+x\n c\n+d\n"
+	if diff != expected {
+		t.Errorf("expected diff\n%s\nbut got\n%s", expected, diff)
+	}
+}
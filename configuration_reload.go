@@ -0,0 +1,201 @@
+// MIT License
+//
+// # Copyright (c) 2019 Stefan Wichmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package main
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const configurationWatchInterval = 5 * time.Second
+
+// ConfigurationWatcher observes the configuration files and signals
+// modifications on its updates channel.
+type ConfigurationWatcher struct {
+	lock     sync.Mutex
+	modified map[string]time.Time
+	updates  chan struct{}
+}
+
+var configurationWatcher = ConfigurationWatcher{updates: make(chan struct{}, 1)}
+
+// start polls the modification time of the given files in the background.
+func (watcher *ConfigurationWatcher) start(files []string, interval time.Duration) {
+	watcher.watch(files)
+
+	go func() {
+		for {
+			time.Sleep(interval)
+			if watcher.changed() {
+				watcher.notify()
+			}
+		}
+	}()
+}
+
+// watch replaces the watched files, e.g. after the includes changed.
+func (watcher *ConfigurationWatcher) watch(files []string) {
+	modified := make(map[string]time.Time)
+	for _, file := range files {
+		modified[file] = modificationTime(file)
+	}
+	watcher.lock.Lock()
+	watcher.modified = modified
+	watcher.lock.Unlock()
+}
+
+func (watcher *ConfigurationWatcher) changed() bool {
+	watcher.lock.Lock()
+	defer watcher.lock.Unlock()
+	changed := false
+	for file, previous := range watcher.modified {
+		current := modificationTime(file)
+		if !current.Equal(previous) {
+			log.Debugf("⚙ Configuration %s was modified", file)
+			watcher.modified[file] = current
+			changed = true
+		}
+	}
+	return changed
+}
+
+// modificationTime returns the modification time of the given file or the
+// zero time if it doesn't exist.
+func modificationTime(file string) time.Time {
+	info, err := os.Stat(file)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
+
+// notify requests a reload of the configuration.
+func (watcher *ConfigurationWatcher) notify() {
+	select {
+	case watcher.updates <- struct{}{}:
+	default:
+	}
+}
+
+// readConfigurationUpdate reads and validates the configuration file of
+// the given configuration. It returns nil if the file content matches the
+// current configuration.
+func readConfigurationUpdate(current *Configuration) (*Configuration, error) {
+	var updated Configuration
+	updated.ConfigurationFile = current.ConfigurationFile
+	updated.implicitIncludes = current.implicitIncludes
+	err := updated.parse()
+	if err != nil {
+		return nil, err
+	}
+	updated.migrateToLatestVersion()
+	err = updated.applyOverrides(current.overrides)
+	if err != nil {
+		return nil, err
+	}
+	err = updated.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	// Settings used during startup can't be changed while running
+	if !reflect.DeepEqual(updated.Bridge, current.Bridge) || !reflect.DeepEqual(updated.AdditionalBridges, current.AdditionalBridges) {
+		log.Warningf("⚙ Changes to the bridge configuration will take effect after a restart")
+		updated.Bridge = current.Bridge
+		updated.AdditionalBridges = current.AdditionalBridges
+	}
+	if !reflect.DeepEqual(updated.WebInterface, current.WebInterface) {
+		log.Warningf("⚙ Changes to the web interface configuration will take effect after a restart")
+		updated.WebInterface = current.WebInterface
+	}
+
+	if updated.HashValue() == current.HashValue() {
+		return nil, nil
+	}
+	updated.Hash = updated.HashValue()
+	return &updated, nil
+}
+
+// reloadConfiguration replaces the active configuration with the content
+// of the configuration file and recalculates all schedules. The state of
+// all lights is kept. If the file is invalid the current configuration
+// stays active. It runs on the main loop, which also serves all web
+// requests accessing the configuration, so the replacement is atomic for
+// them.
+func reloadConfiguration() error {
+	if configuration == nil {
+		return errors.New("no configuration loaded")
+	}
+	updated, err := readConfigurationUpdate(configuration)
+	if err != nil {
+		log.Warningf("⚙ Could not reload configuration %s: %v. Keeping previous configuration.", configuration.ConfigurationFile, err)
+		return err
+	}
+	if updated == nil {
+		log.Debugf("⚙ Configuration hasn't changed. Omitting reload.")
+		return nil
+	}
+
+	previous := configuration
+	configuration = updated
+	configurationWatcher.watch(configuration.watchedFiles())
+	log.Printf("⚙ Configuration %v reloaded", configuration.ConfigurationFile)
+	configuration.logHistory(historySourceReload)
+	applyConfiguration(previous)
+	return nil
+}
+
+// applyConfiguration adjusts the running services and all schedules to
+// changes of the active configuration compared to previous. It runs on the
+// main loop.
+func applyConfiguration(previous *Configuration) {
+	configureLogTimezone(configuration.TimeLocation())
+	if configuration.Timezone != previous.Timezone {
+		resetNewDayTimer()
+	}
+	if configuration.Weather.Source != previous.Weather.Source {
+		if configuration.Weather.Source != "" {
+			log.Printf("☁ Reading weather from %s", configuration.Weather.Source)
+			weatherCache.start(newLocalWeatherProvider(configuration.Weather.Source), weatherUpdateInterval)
+		} else {
+			weatherCache.start(nil, weatherUpdateInterval)
+		}
+	}
+	if !reflect.DeepEqual(configuration.Calendar, previous.Calendar) || configuration.Timezone != previous.Timezone {
+		if configuration.Calendar.File != "" {
+			log.Printf("📅 Reading calendar from %s", configuration.Calendar.File)
+		}
+		calendarWatcher.start(configuration.Calendar, configuration.TimeLocation(), calendarUpdateInterval)
+	}
+
+	updateSchedules()
+
+	if !reflect.DeepEqual(configuration.AwayMode, previous.AwayMode) {
+		clearAwayMode()
+		planAwayMode(time.Now())
+	}
+}
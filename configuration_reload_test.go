@@ -0,0 +1,176 @@
+package main
+
+import (
+	"io"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func TestReadConfigurationUpdate(t *testing.T) {
+	file := copyTestFile(t, "testdata/config-example.json")
+	current := Configuration{ConfigurationFile: file}
+	err := current.parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Unchanged file
+	updated, err := readConfigurationUpdate(&current)
+	if err != nil || updated != nil {
+		t.Errorf("unchanged configuration should not be reloaded (updated: %v, error: %v)", updated, err)
+	}
+
+	// Modified file
+	modified := current
+	modified.Timezone = "Europe/Berlin"
+	modified.ConfigurationFile = file
+	modified.Hash = ""
+	err = modified.Write()
+	if err != nil {
+		t.Fatal(err)
+	}
+	updated, err = readConfigurationUpdate(&current)
+	if err != nil || updated == nil {
+		t.Fatalf("modified configuration should be reloaded (error: %v)", err)
+	}
+	if updated.Timezone != "Europe/Berlin" {
+		t.Errorf("reloaded time zone should be Europe/Berlin but is %q", updated.Timezone)
+	}
+
+	// Invalid files
+	for _, content := range []string{"{ invalid", `{"schedules": []}`, `{"timezone": "Mars/Olympus", "schedules": [{"name": "default"}]}`} {
+		err = os.WriteFile(file, []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		updated, err = readConfigurationUpdate(&current)
+		if err == nil || updated != nil {
+			t.Errorf("invalid configuration %q should be rejected", content)
+		}
+	}
+}
+
+func TestReloadDuringWebRequests(t *testing.T) {
+	previousConfiguration, previousLights, previousBridges := configuration, lights, bridges
+	t.Cleanup(func() { configuration, lights, bridges = previousConfiguration, previousLights, previousBridges })
+	configuration = &Configuration{ConfigurationFile: copyTestFile(t, "testdata/config-example.json")}
+	if err := configuration.parse(); err != nil {
+		t.Fatal(err)
+	}
+	configuration.Hash = configuration.HashValue()
+	lights, bridges = nil, nil
+	runMainLoop(t)
+
+	var wait sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			recorder := httptest.NewRecorder()
+			onMainLoop(configurationSourcesHandler)(recorder, httptest.NewRequest("GET", "/configuration/sources", nil))
+			if recorder.Code != 200 {
+				t.Errorf("configuration sources returned status %d", recorder.Code)
+			}
+		}()
+	}
+	for _, timezone := range []string{"Europe/Berlin", "America/New_York"} {
+		modified := *configuration
+		modified.Timezone = timezone
+		if err := modified.Write(); err != nil {
+			t.Fatal(err)
+		}
+		done := make(chan struct{})
+		mainLoopRequests <- func() {
+			defer close(done)
+			reloadConfiguration()
+		}
+		<-done
+	}
+	wait.Wait()
+
+	if configuration.Timezone != "America/New_York" {
+		t.Errorf("reloaded time zone should be America/New_York but is %q", configuration.Timezone)
+	}
+}
+
+func TestSlowWebClientDoesNotBlockMainLoop(t *testing.T) {
+	previousConfiguration, previousLights, previousBridges := configuration, lights, bridges
+	t.Cleanup(func() { configuration, lights, bridges = previousConfiguration, previousLights, previousBridges })
+	configuration = &Configuration{ConfigurationFile: copyTestFile(t, "testdata/config-example.json")}
+	if err := configuration.parse(); err != nil {
+		t.Fatal(err)
+	}
+	configuration.Hash = configuration.HashValue()
+	lights, bridges = nil, nil
+	runMainLoop(t)
+
+	// The client sends its body slowly
+	body, writer := io.Pipe()
+	finished := make(chan int)
+	go func() {
+		recorder := httptest.NewRecorder()
+		updateAwayModeHandler(recorder, httptest.NewRequest("PUT", "/away", body))
+		finished <- recorder.Code
+	}()
+	writer.Write([]byte(`{"enabled":`))
+
+	done := make(chan struct{})
+	select {
+	case mainLoopRequests <- func() { close(done) }:
+		<-done
+	case <-time.After(time.Second):
+		t.Errorf("main loop should not wait for the request body of a web client")
+	}
+
+	writer.Write([]byte(` false}`))
+	writer.Close()
+	if code := <-finished; code != 200 {
+		t.Errorf("away mode update returned status %d", code)
+	}
+}
+
+func TestWebTimezoneChangeIsApplied(t *testing.T) {
+	previousConfiguration, previousLights, previousTimer := configuration, lights, newDayTimer
+	previousFormatter := log.StandardLogger().Formatter
+	t.Cleanup(func() {
+		configuration, lights, newDayTimer = previousConfiguration, previousLights, previousTimer
+		log.SetFormatter(previousFormatter)
+	})
+	configuration = &Configuration{ConfigurationFile: copyTestFile(t, "testdata/config-example.json")}
+	if err := configuration.parse(); err != nil {
+		t.Fatal(err)
+	}
+	configuration.Hash = configuration.HashValue()
+	light := &Light{ID: 1, Device: newDeviceID("", 1), Name: "Desk"}
+	lights = []*Light{light}
+	newDayTimer = time.NewTimer(time.Hour)
+	runMainLoop(t)
+
+	body := `{"bridge": {"ip": "192.168.10.37"}, "location": {"latitude": 53.5553, "longitude": 9.995}, "timezone": "Pacific/Auckland", "webinterface": {"enabled": true, "port": 8080}}`
+	recorder := httptest.NewRecorder()
+	updateConfigurationHandler(recorder, httptest.NewRequest("PUT", "/configuration", strings.NewReader(body)))
+	if recorder.Code != 200 {
+		t.Fatalf("configuration update returned status %d: %s", recorder.Code, recorder.Body.String())
+	}
+
+	done := make(chan struct{})
+	mainLoopRequests <- func() {
+		defer close(done)
+		if location := configuration.TimeLocation().String(); location != "Pacific/Auckland" {
+			t.Errorf("schedules should be calculated in the new time zone but use %s", location)
+		}
+		if formatter, ok := log.StandardLogger().Formatter.(*timezoneFormatter); !ok || formatter.location.String() != "Pacific/Auckland" {
+			t.Errorf("log timestamps should use the new time zone")
+		}
+		if light.Schedule.endOfDay.Location().String() != "Pacific/Auckland" {
+			t.Errorf("schedule of light %s should be recalculated in the new time zone: %+v", light.Name, light.Schedule)
+		}
+	}
+	<-done
+}
@@ -0,0 +1,182 @@
+// MIT License
+//
+// # Copyright (c) 2019 Stefan Wichmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+const configurationSchemaID = "https://github.com/stefanwichmann/kelvin/configuration.schema.json"
+
+// unknownFields returns the paths of all fields in the given JSON document
+// which are not part of the configuration, e.g. "schedules[0].afterSunet".
+func unknownFields(raw []byte) ([]string, error) {
+	var document interface{}
+	err := json.Unmarshal(raw, &document)
+	if err != nil {
+		return nil, err
+	}
+	var unknown []string
+	collectUnknownFields(document, reflect.TypeOf(Configuration{}), "", &unknown)
+	sort.Strings(unknown)
+	return unknown, nil
+}
+
+func collectUnknownFields(value interface{}, typ reflect.Type, path string, unknown *[]string) {
+	switch typ.Kind() {
+	case reflect.Ptr:
+		collectUnknownFields(value, typ.Elem(), path, unknown)
+	case reflect.Struct:
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return
+		}
+		for key, child := range object {
+			field, found := jsonField(typ, key)
+			if !found {
+				*unknown = append(*unknown, joinPath(path, key))
+				continue
+			}
+			collectUnknownFields(child, field.Type, joinPath(path, key), unknown)
+		}
+	case reflect.Slice, reflect.Array:
+		list, ok := value.([]interface{})
+		if !ok {
+			return
+		}
+		for index, child := range list {
+			collectUnknownFields(child, typ.Elem(), fmt.Sprintf("%s[%d]", path, index), unknown)
+		}
+	}
+}
+
+// jsonField finds the struct field for the given key the same way
+// encoding/json does, preferring an exact match of the name.
+func jsonField(typ reflect.Type, key string) (reflect.StructField, bool) {
+	var candidate reflect.StructField
+	found := false
+	for _, field := range jsonFields(typ) {
+		name := jsonName(field)
+		if name == key {
+			return field, true
+		}
+		if !found && strings.EqualFold(name, key) {
+			candidate = field
+			found = true
+		}
+	}
+	return candidate, found
+}
+
+func jsonFields(typ reflect.Type) []reflect.StructField {
+	var fields []reflect.StructField
+	for index := 0; index < typ.NumField(); index++ {
+		field := typ.Field(index)
+		if field.PkgPath != "" || jsonName(field) == "-" {
+			continue
+		}
+		fields = append(fields, field)
+	}
+	return fields
+}
+
+func jsonName(field reflect.StructField) string {
+	name := strings.Split(field.Tag.Get("json"), ",")[0]
+	if name == "" {
+		return field.Name
+	}
+	return name
+}
+
+func joinPath(path string, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// ConfigurationSchema returns a JSON Schema describing the configuration
+// file. It can be used by editors to validate config.json and config.yaml.
+func ConfigurationSchema() map[string]interface{} {
+	schema := typeSchema(reflect.TypeOf(Configuration{}), "")
+	schema["$schema"] = "http://json-schema.org/draft-07/schema#"
+	schema["$id"] = configurationSchemaID
+	schema["title"] = "Kelvin configuration"
+	return schema
+}
+
+// schemaConstraints adds restrictions to the properties with the given name.
+var schemaConstraints = map[string]map[string]interface{}{
+	"time":                {"pattern": "^([01]?[0-9]|2[0-3]):[0-5][0-9]$"},
+	"start":               {"pattern": "^([01]?[0-9]|2[0-3]):[0-5][0-9]$"},
+	"end":                 {"pattern": "^([01]?[0-9]|2[0-3]):[0-5][0-9]$"},
+	"port":                {"minimum": 0, "maximum": 65535},
+	"cloudCoverThreshold": {"minimum": 0, "maximum": 100},
+	"colorTemperature":    {"anyOf": []interface{}{map[string]interface{}{"enum": []int{-1, 0}}, map[string]interface{}{"minimum": 1000, "maximum": 6500}}},
+	"brightness":          {"minimum": -1, "maximum": 100},
+	"latitude":            {"minimum": -90, "maximum": 90},
+	"longitude":           {"minimum": -180, "maximum": 180},
+}
+
+func typeSchema(typ reflect.Type, name string) map[string]interface{} {
+	schema := map[string]interface{}{}
+	if typ == reflect.TypeOf(DeviceID("")) {
+		// Light ID or bridge name and light ID, e.g. 3 or garage:3
+		schema["type"] = []string{"integer", "string"}
+		schema["pattern"] = "^[^:]+:[0-9]+$"
+		return schema
+	}
+	switch typ.Kind() {
+	case reflect.Ptr:
+		return typeSchema(typ.Elem(), name)
+	case reflect.Struct:
+		properties := map[string]interface{}{}
+		for _, field := range jsonFields(typ) {
+			properties[jsonName(field)] = typeSchema(field.Type, jsonName(field))
+		}
+		schema["type"] = "object"
+		schema["properties"] = properties
+		schema["additionalProperties"] = false
+	case reflect.Slice, reflect.Array:
+		schema["type"] = []string{"array", "null"} // empty lists are written as null
+		schema["items"] = typeSchema(typ.Elem(), "")
+	case reflect.Map:
+		schema["type"] = "object"
+		schema["additionalProperties"] = typeSchema(typ.Elem(), "")
+	case reflect.Bool:
+		schema["type"] = "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		schema["type"] = "integer"
+	case reflect.Float32, reflect.Float64:
+		schema["type"] = "number"
+	case reflect.String:
+		schema["type"] = "string"
+	}
+	for key, value := range schemaConstraints[name] {
+		schema[key] = value
+	}
+	return schema
+}
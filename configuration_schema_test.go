@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestUnknownFields(t *testing.T) {
+	raw := []byte(`{
+		"version": 1,
+		"Bridge": {"ip": "192.168.1.2", "user": "kelvin"},
+		"schedules": [
+			{"name": "default", "afterSunset": []},
+			{"name": "bedroom", "afterSunet": [], "beforeSunrise": [{"time": "4:00", "colour": 2000}]}
+		],
+		"foo": true
+	}`)
+	unknown, err := unknownFields(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"Bridge.user", "foo", "schedules[1].afterSunet", "schedules[1].beforeSunrise[0].colour"}
+	if !reflect.DeepEqual(unknown, expected) {
+		t.Errorf("expected unknown fields %v but got %v", expected, unknown)
+	}
+}
+
+func TestExampleConfigurationsAreKnown(t *testing.T) {
+	for _, testFile := range []string{"testdata/config-example.json", "testdata/config-example.yaml", "testdata/config-example.toml"} {
+		raw, err := os.ReadFile(testFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		raw, err = configurationFormatForFile(testFile).ToJSON(raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		unknown, err := unknownFields(raw)
+		if err != nil || len(unknown) > 0 {
+			t.Errorf("%s should not contain unknown fields: %v (error: %v)", testFile, unknown, err)
+		}
+	}
+}
+
+func TestStrictConfiguration(t *testing.T) {
+	previous := *flagStrictConfiguration
+	*flagStrictConfiguration = true
+	defer func() { *flagStrictConfiguration = previous }()
+
+	c := Configuration{ConfigurationFile: "config.json"}
+	err := c.decode([]byte(`{"schedules": [{"name": "default", "afterSunet": []}]}`))
+	if err == nil {
+		t.Errorf("strict parsing should reject unknown fields")
+	}
+}
+
+func TestConfigurationSchema(t *testing.T) {
+	raw, err := json.Marshal(ConfigurationSchema())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var schema struct {
+		Properties map[string]struct {
+			Items      map[string]json.RawMessage `json:"items"`
+			Properties map[string]json.RawMessage `json:"properties"`
+		} `json:"properties"`
+		AdditionalProperties bool `json:"additionalProperties"`
+	}
+	err = json.Unmarshal(raw, &schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if schema.AdditionalProperties {
+		t.Errorf("schema should not allow additional properties")
+	}
+	for _, field := range jsonFields(reflect.TypeOf(Configuration{})) {
+		if _, found := schema.Properties[jsonName(field)]; !found {
+			t.Errorf("schema is missing property %s", jsonName(field))
+		}
+	}
+	if schema.Properties["schedules"].Items == nil {
+		t.Errorf("schema should describe schedules as array: %+v", schema.Properties["schedules"])
+	}
+	if _, found := schema.Properties["bridge"].Properties["ip"]; !found {
+		t.Errorf("schema should describe bridge.ip")
+	}
+}
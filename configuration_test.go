@@ -1,17 +1,37 @@
 package main
 
 import (
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 )
 
+// copyTestFile copies the given file into a temporary directory, so tests
+// can modify it.
+func copyTestFile(t *testing.T, filename string) string {
+	t.Helper()
+	raw, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	target := filepath.Join(t.TempDir(), filepath.Base(filename))
+	err = os.WriteFile(target, raw, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return target
+}
+
 func TestReadOK(t *testing.T) {
 	correctfiles := []string{
 		"testdata/config-example.json",
 		"testdata/config-example.yaml",
+		"testdata/config-example.toml",
 	}
 	for _, testFile := range correctfiles {
 		c := Configuration{}
-		c.ConfigurationFile = testFile
+		c.ConfigurationFile = copyTestFile(t, testFile)
 		err := c.Read()
 		if err != nil {
 			t.Fatalf("Could not read correct configuration file : %v with error : %v", c.ConfigurationFile, err)
@@ -40,10 +60,11 @@ func TestWriteOK(t *testing.T) {
 	correctfiles := []string{
 		"testdata/config-example.json",
 		"testdata/config-example.yaml",
+		"testdata/config-example.toml",
 	}
 	for _, testFile := range correctfiles {
 		c := Configuration{}
-		c.ConfigurationFile = testFile
+		c.ConfigurationFile = copyTestFile(t, testFile)
 		_ = c.Read()
 		c.Hash = ""
 		err := c.Write()
@@ -52,3 +73,38 @@ func TestWriteOK(t *testing.T) {
 		}
 	}
 }
+
+func TestLightScheduleForDayUsesTimezone(t *testing.T) {
+	c := Configuration{}
+	c.initializeDefaults()
+	c.Timezone = "America/New_York"
+	c.Schedules[0].AssociatedDeviceIDs = deviceIDs(1)
+
+	// 03:00 UTC is still the previous day in New York
+	date := time.Date(2023, time.June, 2, 3, 0, 0, 0, time.UTC)
+	schedule, err := c.lightScheduleForDay("1", date)
+	if err != nil {
+		t.Fatalf("lightScheduleForDay returned error: %v", err)
+	}
+	if schedule.endOfDay.Location().String() != "America/New_York" {
+		t.Errorf("schedule calculated in time zone %v; want America/New_York", schedule.endOfDay.Location())
+	}
+	if schedule.endOfDay.Day() != 1 {
+		t.Errorf("schedule calculated for day %d; want 1", schedule.endOfDay.Day())
+	}
+	wakeup := schedule.beforeSunrise[0].Time
+	if wakeup.Hour() != 4 || wakeup.Location().String() != "America/New_York" {
+		t.Errorf("wakeup timestamp = %v; want 04:00 in America/New_York", wakeup)
+	}
+}
+
+func TestTimeLocationFallback(t *testing.T) {
+	c := Configuration{}
+	if c.TimeLocation() != time.Local {
+		t.Errorf("TimeLocation() = %v; want local time zone", c.TimeLocation())
+	}
+	c.Timezone = "Invalid/Zone"
+	if c.TimeLocation() != time.Local {
+		t.Errorf("TimeLocation() = %v; want local time zone for invalid zone", c.TimeLocation())
+	}
+}
@@ -0,0 +1,168 @@
+// MIT License
+//
+// # Copyright (c) 2019 Stefan Wichmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package main
+
+import (
+	"bytes"
+
+	yamlv3 "go.yaml.in/yaml/v3"
+)
+
+// updateYAML applies the values of the YAML document updated to the
+// document current. Comments, key order and formatting of current are
+// preserved for all values which still exist. New keys are appended.
+func updateYAML(current []byte, updated []byte) ([]byte, error) {
+	var currentDocument, updatedDocument yamlv3.Node
+	err := yamlv3.Unmarshal(current, &currentDocument)
+	if err != nil {
+		return nil, err
+	}
+	err = yamlv3.Unmarshal(updated, &updatedDocument)
+	if err != nil {
+		return nil, err
+	}
+	if len(currentDocument.Content) == 0 {
+		return updated, nil // empty file
+	}
+
+	mergeYAMLNode(currentDocument.Content[0], updatedDocument.Content[0])
+
+	var buffer bytes.Buffer
+	encoder := yamlv3.NewEncoder(&buffer)
+	encoder.SetIndent(2)
+	if !hasIndentedSequences(current) {
+		encoder.CompactSeqIndent()
+	}
+	err = encoder.Encode(&currentDocument)
+	if err != nil {
+		return nil, err
+	}
+	err = encoder.Close()
+	if err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// mergeYAMLNode updates target to the content of source while keeping
+// comments and styles of target.
+func mergeYAMLNode(target *yamlv3.Node, source *yamlv3.Node) {
+	if target.Kind != source.Kind {
+		comments := [3]string{target.HeadComment, target.LineComment, target.FootComment}
+		*target = *source
+		target.HeadComment, target.LineComment, target.FootComment = comments[0], comments[1], comments[2]
+		return
+	}
+
+	switch target.Kind {
+	case yamlv3.ScalarNode:
+		if target.Value != source.Value || target.Tag != source.Tag {
+			target.Value = source.Value
+			target.Tag = source.Tag
+			target.Style = source.Style
+		}
+	case yamlv3.MappingNode:
+		var content []*yamlv3.Node
+		for index := 0; index+1 < len(target.Content); index += 2 {
+			value := mappingValue(source, target.Content[index].Value)
+			if value == nil {
+				continue // removed key
+			}
+			mergeYAMLNode(target.Content[index+1], value)
+			content = append(content, target.Content[index], target.Content[index+1])
+		}
+		for index := 0; index+1 < len(source.Content); index += 2 {
+			if mappingValue(target, source.Content[index].Value) == nil {
+				content = append(content, source.Content[index], source.Content[index+1])
+			}
+		}
+		target.Content = content
+	case yamlv3.SequenceNode:
+		var content []*yamlv3.Node
+		for index, item := range source.Content {
+			match := matchingSequenceItem(target, item, index)
+			if match == nil {
+				content = append(content, item)
+				continue
+			}
+			mergeYAMLNode(match, item)
+			content = append(content, match)
+		}
+		target.Content = content
+		if len(target.Content) == 0 {
+			target.Style = yamlv3.FlowStyle // []
+		}
+	}
+}
+
+// matchingSequenceItem returns the item of the sequence target which
+// corresponds to the item at index of the updated sequence. Mappings with a
+// name (e.g. schedules) are matched by their name, so comments stay with
+// their item when other items are removed or reordered. All other items are
+// matched by their index.
+func matchingSequenceItem(target *yamlv3.Node, item *yamlv3.Node, index int) *yamlv3.Node {
+	if item.Kind == yamlv3.MappingNode {
+		if name := mappingValue(item, "name"); name != nil {
+			for _, candidate := range target.Content {
+				if candidate.Kind != yamlv3.MappingNode {
+					continue
+				}
+				if candidateName := mappingValue(candidate, "name"); candidateName != nil && candidateName.Value == name.Value {
+					return candidate
+				}
+			}
+			return nil
+		}
+	}
+	if index < len(target.Content) {
+		return target.Content[index]
+	}
+	return nil
+}
+
+func mappingValue(mapping *yamlv3.Node, key string) *yamlv3.Node {
+	for index := 0; index+1 < len(mapping.Content); index += 2 {
+		if mapping.Content[index].Value == key {
+			return mapping.Content[index+1]
+		}
+	}
+	return nil
+}
+
+// hasIndentedSequences reports whether the document indents sequences
+// below their parent key.
+func hasIndentedSequences(document []byte) bool {
+	lines := bytes.Split(document, []byte("\n"))
+	for index := 1; index < len(lines); index++ {
+		line := bytes.TrimLeft(lines[index], " ")
+		if !bytes.HasPrefix(line, []byte("- ")) {
+			continue
+		}
+		previous := bytes.TrimRight(lines[index-1], " \r")
+		if bytes.HasSuffix(previous, []byte(":")) {
+			indent := len(lines[index]) - len(line)
+			previousIndent := len(previous) - len(bytes.TrimLeft(previous, " "))
+			return indent > previousIndent
+		}
+	}
+	return false
+}
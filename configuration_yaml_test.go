@@ -0,0 +1,97 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestYAMLRoundTrip(t *testing.T) {
+	original, err := os.ReadFile("testdata/config-commented.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := Configuration{ConfigurationFile: copyTestFile(t, "testdata/config-commented.yaml")}
+	err = c.parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c.Bridge.Username = "newUsername"
+	c.Schedules[0].AfterSunset[1].Time = "22:30"
+	err = c.Write()
+	if err != nil {
+		t.Fatal(err)
+	}
+	written, err := os.ReadFile(c.ConfigurationFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// All unchanged lines including comments must be kept in order
+	expected := strings.Replace(string(original), "lbCDGagZZ7JEYQX5iGxrjMIx2jIROgpXfsSjHmCv", "newUsername", 1)
+	expected = strings.Replace(expected, `time: "22:00"`, `time: "22:30"`, 1)
+	if !strings.HasPrefix(string(written), expected) {
+		t.Errorf("written configuration should start with the original content.\nExpected:\n%s\nWritten:\n%s", expected, written)
+	}
+
+	// The written file must still be readable
+	read := Configuration{ConfigurationFile: c.ConfigurationFile}
+	err = read.parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if read.HashValue() != c.HashValue() {
+		t.Errorf("written configuration differs from saved one:\n%+v\n%+v", read, c)
+	}
+}
+
+func TestYAMLUnchangedWrite(t *testing.T) {
+	original, err := os.ReadFile("testdata/config-example.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := Configuration{ConfigurationFile: copyTestFile(t, "testdata/config-example.yaml")}
+	err = c.parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Hash = "" // force the write
+	err = c.Write()
+	if err != nil {
+		t.Fatal(err)
+	}
+	written, err := os.ReadFile(c.ConfigurationFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(written) != string(original) {
+		t.Errorf("unchanged configuration should be written byte by byte:\n%s", lineDiff(string(original), string(written)))
+	}
+}
+
+func TestYAMLRemovedSequenceItem(t *testing.T) {
+	current := []byte("schedules:\n# first\n- name: first\n  enableWhenLightsAppear: true\n# second\n- name: second\n  enableWhenLightsAppear: false\n# third\n- name: third\n  enableWhenLightsAppear: true\n")
+	updated := []byte("schedules:\n- name: first\n  enableWhenLightsAppear: true\n- name: third\n  enableWhenLightsAppear: false\n- name: fourth\n  enableWhenLightsAppear: true\n")
+	result, err := updateYAML(current, updated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "schedules:\n# first\n- name: first\n  enableWhenLightsAppear: true\n# third\n- name: third\n  enableWhenLightsAppear: false\n- name: fourth\n  enableWhenLightsAppear: true\n"
+	if string(result) != expected {
+		t.Errorf("expected\n%s\nbut got\n%s", expected, result)
+	}
+}
+
+func TestYAMLRemovedValues(t *testing.T) {
+	current := []byte("# header\nname: kelvin # comment\nold: true\nlist:\n- 1\n- 2\n")
+	updated := []byte("list:\n- 1\nname: kelvin\nnew: 2\n")
+	result, err := updateYAML(current, updated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "# header\nname: kelvin # comment\nlist:\n- 1\nnew: 2\n"
+	if string(result) != expected {
+		t.Errorf("expected\n%s\nbut got\n%s", expected, result)
+	}
+}
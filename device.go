@@ -0,0 +1,175 @@
+// MIT License
+//
+// # Copyright (c) 2019 Stefan Wichmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// deviceIDSeparator separates the bridge name from the light ID in a
+// qualified DeviceID, e.g. garage:3.
+const deviceIDSeparator = ":"
+
+// DeviceID references a light in the configuration. Lights of the main
+// bridge are referenced by their ID (e.g. 3), lights of additional
+// bridges by the name of the bridge and their ID (e.g. garage:3).
+type DeviceID string
+
+func newDeviceID(bridge string, light int) DeviceID {
+	if bridge == "" {
+		return DeviceID(strconv.Itoa(light))
+	}
+	return DeviceID(bridge + deviceIDSeparator + strconv.Itoa(light))
+}
+
+// split returns the bridge name and the light ID of the device. The
+// bridge name is empty for lights of the main bridge.
+func (id DeviceID) split() (string, int, error) {
+	bridge, light := "", string(id)
+	if index := strings.LastIndex(light, deviceIDSeparator); index != -1 {
+		bridge, light = light[:index], light[index+1:]
+	}
+	lightID, err := strconv.Atoi(strings.TrimSpace(light))
+	if err != nil {
+		return bridge, 0, fmt.Errorf("invalid light %q", string(id))
+	}
+	return strings.TrimSpace(bridge), lightID, nil
+}
+
+// MarshalJSON writes lights of the main bridge as plain numbers to stay
+// compatible with existing configurations.
+func (id DeviceID) MarshalJSON() ([]byte, error) {
+	if _, err := strconv.Atoi(string(id)); err == nil {
+		return []byte(id), nil
+	}
+	return json.Marshal(string(id))
+}
+
+// UnmarshalJSON accepts numbers and strings.
+func (id *DeviceID) UnmarshalJSON(data []byte) error {
+	var number int
+	if err := json.Unmarshal(data, &number); err == nil {
+		*id = DeviceID(strconv.Itoa(number))
+		return nil
+	}
+	var qualified string
+	err := json.Unmarshal(data, &qualified)
+	if err != nil {
+		return fmt.Errorf("invalid light %s", data)
+	}
+	*id = DeviceID(qualified)
+	return nil
+}
+
+// deviceIDs returns the IDs of the given lights on the main bridge.
+func deviceIDs(lights ...int) []DeviceID {
+	ids := []DeviceID{}
+	for _, light := range lights {
+		ids = append(ids, newDeviceID("", light))
+	}
+	return ids
+}
+
+// normalizeDeviceID removes the name of the main bridge from the given
+// device, so all references to a light are equal.
+func (configuration *Configuration) normalizeDeviceID(id DeviceID) DeviceID {
+	bridge, light, err := id.split()
+	if err != nil {
+		return id
+	}
+	if bridge == configuration.Bridge.Name {
+		bridge = ""
+	}
+	return newDeviceID(bridge, light)
+}
+
+// containsDevice reports whether the list references the given device.
+func (configuration *Configuration) containsDevice(ids []DeviceID, id DeviceID) bool {
+	id = configuration.normalizeDeviceID(id)
+	for _, candidate := range ids {
+		if configuration.normalizeDeviceID(candidate) == id {
+			return true
+		}
+	}
+	return false
+}
+
+// lightsOnBridge returns the IDs of all lights of the given list which
+// belong to the named bridge.
+func (configuration *Configuration) lightsOnBridge(ids []DeviceID, bridge string) []int {
+	var lights []int
+	for _, id := range ids {
+		name, light, err := configuration.normalizeDeviceID(id).split()
+		if err == nil && name == bridge {
+			lights = append(lights, light)
+		}
+	}
+	return lights
+}
+
+// configuredBridges returns the main bridge followed by all additional
+// bridges of the configuration.
+func (configuration *Configuration) configuredBridges() []*Bridge {
+	bridges := []*Bridge{&configuration.Bridge}
+	for index := range configuration.AdditionalBridges {
+		bridges = append(bridges, &configuration.AdditionalBridges[index])
+	}
+	return bridges
+}
+
+// validateDevices ensures all bridges can be told apart and every light
+// reference points to a configured bridge.
+func (configuration *Configuration) validateDevices() error {
+	names := map[string]bool{configuration.Bridge.Name: true}
+	if strings.Contains(configuration.Bridge.Name, deviceIDSeparator) {
+		return fmt.Errorf("invalid bridge name %q", configuration.Bridge.Name)
+	}
+	for _, bridge := range configuration.AdditionalBridges {
+		if bridge.Name == "" || strings.Contains(bridge.Name, deviceIDSeparator) {
+			return fmt.Errorf("invalid name %q of additional bridge %s", bridge.Name, bridge.IP)
+		}
+		if names[bridge.Name] {
+			return fmt.Errorf("bridge name %q is used more than once", bridge.Name)
+		}
+		names[bridge.Name] = true
+	}
+
+	lists := [][]DeviceID{configuration.AwayMode.AssociatedDeviceIDs}
+	for _, schedule := range configuration.Schedules {
+		lists = append(lists, schedule.AssociatedDeviceIDs)
+	}
+	for _, list := range lists {
+		for _, id := range list {
+			bridge, _, err := id.split()
+			if err != nil {
+				return err
+			}
+			if !names[bridge] {
+				return fmt.Errorf("light %s references unknown bridge %q", id, bridge)
+			}
+		}
+	}
+	return nil
+}
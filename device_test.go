@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDeviceIDJSON(t *testing.T) {
+	var ids []DeviceID
+	err := json.Unmarshal([]byte(`[1, "2", "garage:3"]`), &ids)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ids, []DeviceID{"1", "2", "garage:3"}) {
+		t.Errorf("unexpected device IDs %v", ids)
+	}
+	raw, err := json.Marshal(ids)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(raw) != `[1,2,"garage:3"]` {
+		t.Errorf("lights of the main bridge should be written as numbers: %s", raw)
+	}
+
+	err = json.Unmarshal([]byte(`[true]`), &ids)
+	if err == nil {
+		t.Errorf("invalid device ID should be rejected")
+	}
+}
+
+func TestDeviceIDMatching(t *testing.T) {
+	c := Configuration{Bridge: Bridge{Name: "house"}, AdditionalBridges: []Bridge{{Name: "garage"}}}
+	ids := []DeviceID{"1", "house:2", "garage:3"}
+	tests := []struct {
+		id       DeviceID
+		expected bool
+	}{
+		{"1", true},
+		{"house:1", true},
+		{"2", true},
+		{"3", false},
+		{"garage:3", true},
+		{"garage:1", false},
+	}
+	for _, test := range tests {
+		if c.containsDevice(ids, test.id) != test.expected {
+			t.Errorf("containsDevice(%v, %s) should be %v", ids, test.id, test.expected)
+		}
+	}
+
+	if lights := c.lightsOnBridge(ids, ""); !reflect.DeepEqual(lights, []int{1, 2}) {
+		t.Errorf("unexpected lights on main bridge %v", lights)
+	}
+	if lights := c.lightsOnBridge(ids, "garage"); !reflect.DeepEqual(lights, []int{3}) {
+		t.Errorf("unexpected lights on garage bridge %v", lights)
+	}
+}
+
+func TestValidateDevices(t *testing.T) {
+	tests := []struct {
+		configuration Configuration
+		valid         bool
+	}{
+		{Configuration{AdditionalBridges: []Bridge{{Name: "garage"}}, Schedules: []LightSchedule{{AssociatedDeviceIDs: []DeviceID{"1", "garage:3"}}}}, true},
+		{Configuration{Schedules: []LightSchedule{{AssociatedDeviceIDs: []DeviceID{"garage:3"}}}}, false},
+		{Configuration{AwayMode: AwayMode{AssociatedDeviceIDs: []DeviceID{"garage:x"}}, AdditionalBridges: []Bridge{{Name: "garage"}}}, false},
+		{Configuration{AdditionalBridges: []Bridge{{IP: "192.168.10.38"}}}, false},
+		{Configuration{AdditionalBridges: []Bridge{{Name: "garage"}, {Name: "garage"}}}, false},
+		{Configuration{Bridge: Bridge{Name: "house"}, AdditionalBridges: []Bridge{{Name: "house"}}}, false},
+	}
+	for index, test := range tests {
+		err := test.configuration.validateDevices()
+		if (err == nil) != test.valid {
+			t.Errorf("test %d: unexpected validation result %v", index, err)
+		}
+	}
+}
+
+func TestLightScheduleOnAdditionalBridge(t *testing.T) {
+	c := Configuration{AdditionalBridges: []Bridge{{Name: "garage"}}}
+	c.initializeDefaults()
+	garage := c.Schedules[0]
+	garage.Name = "garage"
+	garage.DefaultBrightness = 40
+	garage.AssociatedDeviceIDs = []DeviceID{"garage:1"}
+	c.Schedules[0].AssociatedDeviceIDs = deviceIDs(1)
+	c.Schedules = append(c.Schedules, garage)
+
+	date := time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+	for id, brightness := range map[DeviceID]int{"1": 100, "garage:1": 40} {
+		schedule, err := c.lightScheduleForDay(id, date)
+		if err != nil {
+			t.Fatalf("light %s should have a schedule: %v", id, err)
+		}
+		if schedule.sunrise.Brightness != brightness {
+			t.Errorf("light %s should use the schedule with %d%% brightness but got %d%%", id, brightness, schedule.sunrise.Brightness)
+		}
+	}
+	if _, err := c.lightScheduleForDay("garage:2", date); err == nil {
+		t.Errorf("light garage:2 should not be associated with a schedule")
+	}
+}
@@ -0,0 +1,289 @@
+// MIT License
+//
+// # Copyright (c) 2019 Stefan Wichmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/stefanwichmann/lanscan"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const hueServiceName = "_hue._tcp.local."
+
+var mdnsAddress = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+var ssdpAddress = &net.UDPAddr{IP: net.IPv4(239, 255, 255, 250), Port: 1900}
+var philipsDiscoveryURL = "https://discovery.meethue.com/"
+
+// The local network scan probes at most scanMaxHosts addresses per network
+// with scanWorkers parallel connections.
+const scanWorkers = 20
+const scanMaxHosts = 1000
+const scanTimeout = 2 * time.Second
+
+// discoveryMethod finds the addresses of bridges in the local network.
+type discoveryMethod struct {
+	name     string
+	timeout  time.Duration
+	discover func(timeout time.Duration) ([]string, error)
+}
+
+// discoveryMethods are tried in order until a bridge is found. The local
+// methods come first, so Kelvin works in networks without internet access.
+var discoveryMethods = []discoveryMethod{
+	{name: "mDNS", timeout: 3 * time.Second, discover: discoverMDNS},
+	{name: "SSDP", timeout: 3 * time.Second, discover: discoverSSDP},
+	{name: "cloud discovery", timeout: 20 * time.Second, discover: discoverCloud},
+}
+
+// findBridge runs the discovery methods in order and returns the first
+// discovered address accepted by the given function.
+func findBridge(accept func(address string) bool) (string, error) {
+	for _, method := range discoveryMethods {
+		log.Debugf("⌘ Starting bridge discovery via %s", method.name)
+		addresses, err := method.discover(method.timeout)
+		if err != nil {
+			log.Debugf("⌘ Bridge discovery via %s failed: %v", method.name, err)
+		}
+		for _, address := range addresses {
+			if accept(address) {
+				log.Printf("⌘ Found bridge at %s via %s", address, method.name)
+				return address, nil
+			}
+			log.Debugf("⌘ Ignoring %s found via %s", address, method.name)
+		}
+	}
+	return "", errors.New("Bridge discovery failed. Please configure manually in config.json")
+}
+
+// discoverMDNS queries the hue service via multicast DNS.
+func discoverMDNS(timeout time.Duration) ([]string, error) {
+	query, err := mdnsQuery()
+	if err != nil {
+		return nil, err
+	}
+	return multicastDiscovery(mdnsAddress, query, timeout, parseMDNSResponse)
+}
+
+// discoverSSDP searches hue bridges via the simple service discovery protocol.
+func discoverSSDP(timeout time.Duration) ([]string, error) {
+	query := fmt.Sprintf("M-SEARCH * HTTP/1.1\r\nHOST: %s\r\nMAN: \"ssdp:discover\"\r\nMX: %d\r\nST: ssdp:all\r\n\r\n", ssdpAddress, int(timeout/time.Second))
+	return multicastDiscovery(ssdpAddress, []byte(query), timeout, parseSSDPResponse)
+}
+
+// discoverCloud asks the Philips discovery service and scans the local
+// network as last resort. All requests are cancelled after the timeout.
+func discoverCloud(timeout time.Duration) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	addresses, err := discoverPhilipsService(ctx)
+	if err != nil {
+		log.Debugf("⌘ Philips discovery service failed: %v", err)
+	}
+	if len(addresses) > 0 {
+		return addresses, nil
+	}
+	return scanLocalNetwork(ctx)
+}
+
+// discoverPhilipsService returns the bridges the Philips discovery service
+// knows for the public address of this network.
+func discoverPhilipsService(ctx context.Context) ([]string, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, philipsDiscoveryURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	var bridges []struct {
+		Address string `json:"internalipaddress"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&bridges); err != nil {
+		return nil, err
+	}
+	var addresses []string
+	for _, bridge := range bridges {
+		if bridge.Address != "" && !containsString(addresses, bridge.Address) {
+			addresses = append(addresses, bridge.Address)
+		}
+	}
+	return addresses, nil
+}
+
+// scanLocalNetwork returns all hosts in the local networks accepting
+// connections on port 80. It returns once every probe has stopped.
+func scanLocalNetwork(ctx context.Context) ([]string, error) {
+	hosts := make(chan string)
+	var lock sync.Mutex
+	var addresses []string
+	var workers sync.WaitGroup
+	for i := 0; i < scanWorkers; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			dialer := net.Dialer{Timeout: scanTimeout}
+			for host := range hosts {
+				conn, err := dialer.DialContext(ctx, "tcp4", net.JoinHostPort(host, "80"))
+				if err != nil {
+					continue
+				}
+				conn.Close()
+				lock.Lock()
+				addresses = append(addresses, host)
+				lock.Unlock()
+			}
+		}()
+	}
+
+scan:
+	for _, network := range lanscan.LinkLocalAddresses("tcp4") {
+		for _, host := range lanscan.CalculateSubnetIPs(network, scanMaxHosts) {
+			select {
+			case hosts <- host:
+			case <-ctx.Done():
+				break scan
+			}
+		}
+	}
+	close(hosts)
+	workers.Wait()
+	return addresses, ctx.Err()
+}
+
+// multicastDiscovery sends the query to the given multicast group and
+// collects the addresses parsed from all responses until the timeout.
+func multicastDiscovery(group *net.UDPAddr, query []byte, timeout time.Duration, parse func(response []byte, source net.IP) []string) ([]string, error) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4zero})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	_, err = conn.WriteToUDP(query, group)
+	if err != nil {
+		return nil, err
+	}
+
+	var addresses []string
+	buffer := make([]byte, 9000)
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	for {
+		n, source, err := conn.ReadFromUDP(buffer)
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				return addresses, nil
+			}
+			return addresses, err
+		}
+		for _, address := range parse(buffer[:n], source.IP) {
+			if !containsString(addresses, address) {
+				addresses = append(addresses, address)
+			}
+		}
+	}
+}
+
+// mdnsQuery returns a PTR query for the hue service. The unicast response
+// bit is set, because the query isn't sent from port 5353.
+func mdnsQuery() ([]byte, error) {
+	name, err := dnsmessage.NewName(hueServiceName)
+	if err != nil {
+		return nil, err
+	}
+	message := dnsmessage.Message{
+		Questions: []dnsmessage.Question{{Name: name, Type: dnsmessage.TypePTR, Class: dnsmessage.ClassINET | 1<<15}},
+	}
+	return message.Pack()
+}
+
+// parseMDNSResponse returns the IPv4 addresses announced for the hue
+// service. Responders without address records are identified by the
+// source of the response.
+func parseMDNSResponse(response []byte, source net.IP) []string {
+	var message dnsmessage.Message
+	if err := message.Unpack(response); err != nil || !message.Header.Response {
+		return nil
+	}
+
+	hueService := false
+	var addresses []string
+	for _, resource := range append(message.Answers, message.Additionals...) {
+		switch body := resource.Body.(type) {
+		case *dnsmessage.PTRResource:
+			if strings.EqualFold(resource.Header.Name.String(), hueServiceName) {
+				hueService = true
+			}
+		case *dnsmessage.AResource:
+			addresses = append(addresses, net.IP(body.A[:]).String())
+		}
+	}
+	if !hueService {
+		return nil
+	}
+	if len(addresses) == 0 && source != nil {
+		addresses = append(addresses, source.String())
+	}
+	return addresses
+}
+
+// parseSSDPResponse returns the address of a hue bridge answering the
+// search. Other devices in the network are ignored.
+func parseSSDPResponse(response []byte, source net.IP) []string {
+	resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(response)), nil)
+	if err != nil {
+		return nil
+	}
+	resp.Body.Close()
+	if resp.Header.Get("hue-bridgeid") == "" && !strings.Contains(resp.Header.Get("Server"), "IpBridge") {
+		return nil
+	}
+
+	location, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil || location.Hostname() == "" {
+		if source == nil {
+			return nil
+		}
+		return []string{source.String()}
+	}
+	return []string{location.Hostname()}
+}
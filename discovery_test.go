@@ -0,0 +1,155 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func mdnsResponse(t *testing.T, service string, addresses ...[4]byte) []byte {
+	t.Helper()
+	message := dnsmessage.Message{Header: dnsmessage.Header{Response: true, Authoritative: true}}
+	message.Answers = append(message.Answers, dnsmessage.Resource{
+		Header: dnsmessage.ResourceHeader{Name: dnsmessage.MustNewName(service), Type: dnsmessage.TypePTR, Class: dnsmessage.ClassINET},
+		Body:   &dnsmessage.PTRResource{PTR: dnsmessage.MustNewName("Hue Bridge - 23BFC2." + service)},
+	})
+	for _, address := range addresses {
+		message.Additionals = append(message.Additionals, dnsmessage.Resource{
+			Header: dnsmessage.ResourceHeader{Name: dnsmessage.MustNewName("hue-bridge.local."), Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET},
+			Body:   &dnsmessage.AResource{A: address},
+		})
+	}
+	packet, err := message.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return packet
+}
+
+func TestMDNSQuery(t *testing.T) {
+	packet, err := mdnsQuery()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var message dnsmessage.Message
+	if err := message.Unpack(packet); err != nil {
+		t.Fatal(err)
+	}
+	if len(message.Questions) != 1 || message.Questions[0].Name.String() != hueServiceName || message.Questions[0].Type != dnsmessage.TypePTR {
+		t.Errorf("query should ask for the PTR records of %s: %+v", hueServiceName, message.Questions)
+	}
+}
+
+func TestParseMDNSResponse(t *testing.T) {
+	source := net.IPv4(192, 168, 1, 99)
+	tests := []struct {
+		name     string
+		response []byte
+		expected []string
+	}{
+		{"address record", mdnsResponse(t, hueServiceName, [4]byte{192, 168, 1, 20}), []string{"192.168.1.20"}},
+		{"source address", mdnsResponse(t, hueServiceName), []string{"192.168.1.99"}},
+		{"other service", mdnsResponse(t, "_printer._tcp.local.", [4]byte{192, 168, 1, 30}), nil},
+		{"invalid packet", []byte("hello"), nil},
+	}
+	for _, test := range tests {
+		addresses := parseMDNSResponse(test.response, source)
+		if len(addresses) != len(test.expected) || (len(addresses) > 0 && addresses[0] != test.expected[0]) {
+			t.Errorf("%s: expected %v but got %v", test.name, test.expected, addresses)
+		}
+	}
+}
+
+func TestParseSSDPResponse(t *testing.T) {
+	source := net.IPv4(192, 168, 1, 99)
+	bridge := "HTTP/1.1 200 OK\r\nHOST: 239.255.255.250:1900\r\nCACHE-CONTROL: max-age=100\r\nLOCATION: http://192.168.1.20:80/description.xml\r\nSERVER: Hue/1.0 UPnP/1.0 IpBridge/1.41.0\r\nhue-bridgeid: 001788FFFE23BFC2\r\nST: upnp:rootdevice\r\n\r\n"
+	if addresses := parseSSDPResponse([]byte(bridge), source); len(addresses) != 1 || addresses[0] != "192.168.1.20" {
+		t.Errorf("bridge should be found at 192.168.1.20 but got %v", addresses)
+	}
+	other := "HTTP/1.1 200 OK\r\nLOCATION: http://192.168.1.30:49152/rootDesc.xml\r\nSERVER: Linux UPnP/1.0 MiniUPnPd/2.1\r\nST: upnp:rootdevice\r\n\r\n"
+	if addresses := parseSSDPResponse([]byte(other), source); len(addresses) != 0 {
+		t.Errorf("other devices should be ignored but got %v", addresses)
+	}
+}
+
+func TestDiscoverPhilipsService(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[{"id":"001788fffe23bfc2","internalipaddress":"192.168.1.2"},{"id":"001788fffe23bfc3","internalipaddress":"192.168.1.3"}]`))
+	}))
+	defer server.Close()
+	previous := philipsDiscoveryURL
+	t.Cleanup(func() { philipsDiscoveryURL = previous })
+	philipsDiscoveryURL = server.URL
+
+	addresses, err := discoverCloud(time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(addresses) != 2 || addresses[0] != "192.168.1.2" || addresses[1] != "192.168.1.3" {
+		t.Errorf("unexpected addresses %v", addresses)
+	}
+}
+
+func TestCloudDiscoveryStopsAfterTimeout(t *testing.T) {
+	cancelled := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+		close(cancelled)
+	}))
+	defer server.Close()
+	previous := philipsDiscoveryURL
+	t.Cleanup(func() { philipsDiscoveryURL = previous })
+	philipsDiscoveryURL = server.URL
+
+	start := time.Now()
+	if _, err := discoverCloud(100 * time.Millisecond); err == nil {
+		t.Error("discovery should fail after the timeout")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("discovery should return after the timeout but took %v", elapsed)
+	}
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Error("request to the discovery service should be cancelled after the timeout")
+	}
+}
+
+func TestDiscoveryMethodsAreTriedInOrder(t *testing.T) {
+	fake := newFakeBridge(t)
+	var called []string
+	method := func(name string, addresses []string, err error) discoveryMethod {
+		return discoveryMethod{name: name, discover: func(time.Duration) ([]string, error) {
+			called = append(called, name)
+			return addresses, err
+		}}
+	}
+	previous := discoveryMethods
+	t.Cleanup(func() { discoveryMethods = previous })
+	discoveryMethods = []discoveryMethod{
+		method("mDNS", nil, errors.New("no multicast")),
+		method("SSDP", []string{"127.0.0.1:1", fake.address()}, nil),
+		method("cloud discovery", []string{fake.address()}, nil),
+	}
+
+	bridge := &HueBridge{}
+	if err := bridge.discover(""); err != nil {
+		t.Fatal(err)
+	}
+	if bridge.BridgeIP != fake.address() {
+		t.Errorf("bridge should be found at %s but uses %s", fake.address(), bridge.BridgeIP)
+	}
+	if len(called) != 2 || called[0] != "mDNS" || called[1] != "SSDP" {
+		t.Errorf("discovery should stop after SSDP found the bridge but called %v", called)
+	}
+
+	discoveryMethods = []discoveryMethod{method("mDNS", []string{"127.0.0.1:1"}, nil)}
+	if err := bridge.discover(""); err == nil || bridge.BridgeIP != "" {
+		t.Errorf("discovery without a valid bridge should fail (IP: %s, Error: %v)", bridge.BridgeIP, err)
+	}
+}
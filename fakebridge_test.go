@@ -0,0 +1,285 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	hue "github.com/stefanwichmann/go.hue"
+)
+
+const fakeBridgeUsername = "fake-username"
+
+// fakeBridge serves the endpoints of the hue API v1 used by Kelvin.
+// Tests can change lights behind Kelvin's back, make them unreachable and
+// delay all responses of the bridge.
+type fakeBridge struct {
+	server   *httptest.Server
+	id       string
+	username string
+
+	lock          sync.Mutex
+	lights        map[string]*hue.LightAttributes
+	scenes        map[string]*hue.Scene
+	groups        map[string]*HueGroup
+	delay         time.Duration
+	stateRequests int
+	groupRequests int
+}
+
+func newFakeBridge(t *testing.T) *fakeBridge {
+	bridge := &fakeBridge{id: "001788FFFE23BFC2", username: fakeBridgeUsername, lights: make(map[string]*hue.LightAttributes), scenes: make(map[string]*hue.Scene), groups: make(map[string]*HueGroup)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /description.xml", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<?xml version="1.0" encoding="UTF-8" ?><root xmlns="urn:schemas-upnp-org:device-1-0"><device><modelName>Philips hue bridge 2012</modelName><modelNumber>929000226503</modelNumber></device></root>`))
+	})
+	mux.HandleFunc("GET /api/config", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]string{"name": "Fake bridge", "bridgeid": bridge.id, "modelid": "BSB001", "apiversion": "1.16.0"})
+	})
+	mux.HandleFunc("GET /api/{user}/config", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, hue.Configuration{Name: "Fake bridge", BridgeId: bridge.id, ModelId: "BSB001", SoftwareVersion: "01041302", APIVersion: "1.16.0"})
+	})
+	mux.HandleFunc("GET /api/{user}/lights", func(w http.ResponseWriter, r *http.Request) {
+		bridge.lock.Lock()
+		defer bridge.lock.Unlock()
+		writeJSON(w, bridge.lights)
+	})
+	mux.HandleFunc("PUT /api/{user}/lights/{id}/state", bridge.handleLightState)
+	mux.HandleFunc("GET /api/{user}/scenes", func(w http.ResponseWriter, r *http.Request) {
+		bridge.lock.Lock()
+		defer bridge.lock.Unlock()
+		writeJSON(w, bridge.scenes)
+	})
+	mux.HandleFunc("PUT /api/{user}/scenes/{id}", func(w http.ResponseWriter, r *http.Request) {
+		var modify hue.ModifyScene
+		bridge.modifyScene(w, r, &modify, func(scene *hue.Scene) {
+			if len(modify.Lights) > 0 {
+				scene.Lights = modify.Lights
+			}
+		})
+	})
+	mux.HandleFunc("PUT /api/{user}/scenes/{id}/lightstates/{light}", func(w http.ResponseWriter, r *http.Request) {
+		var modify hue.LightState
+		bridge.modifyScene(w, r, &modify, func(scene *hue.Scene) {
+			scene.LightStates[r.PathValue("light")] = modify
+		})
+	})
+	mux.HandleFunc("GET /api/{user}/groups", func(w http.ResponseWriter, r *http.Request) {
+		bridge.lock.Lock()
+		defer bridge.lock.Unlock()
+		writeJSON(w, bridge.groups)
+	})
+	mux.HandleFunc("PUT /api/{user}/groups/{id}/action", bridge.handleGroupAction)
+
+	bridge.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		bridge.lock.Lock()
+		delay := bridge.delay
+		bridge.lock.Unlock()
+		select {
+		case <-time.After(delay):
+		case <-r.Context().Done():
+			return
+		}
+
+		if strings.HasPrefix(r.URL.Path, "/api/") && r.URL.Path != "/api/config" && !strings.HasPrefix(r.URL.Path, "/api/"+bridge.username+"/") {
+			writeJSON(w, []map[string]interface{}{{"error": map[string]interface{}{"type": 1, "address": r.URL.Path, "description": "unauthorized user"}}})
+			return
+		}
+		mux.ServeHTTP(w, r)
+	}))
+	t.Cleanup(bridge.server.Close)
+	return bridge
+}
+
+func writeJSON(w http.ResponseWriter, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(value)
+}
+
+// handleLightState applies a new state to a light the way the bridge does.
+func (bridge *fakeBridge) handleLightState(w http.ResponseWriter, r *http.Request) {
+	var params map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	bridge.lock.Lock()
+	defer bridge.lock.Unlock()
+	bridge.stateRequests++
+	light, found := bridge.lights[r.PathValue("id")]
+	if !found {
+		writeJSON(w, []map[string]interface{}{{"error": map[string]interface{}{"type": 3, "description": "resource not available"}}})
+		return
+	}
+	applyLightState(light, params)
+	writeJSON(w, []map[string]interface{}{{"success": params}})
+}
+
+// handleGroupAction applies a new state to all lights of a group.
+func (bridge *fakeBridge) handleGroupAction(w http.ResponseWriter, r *http.Request) {
+	var params map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	bridge.lock.Lock()
+	defer bridge.lock.Unlock()
+	bridge.groupRequests++
+	group, found := bridge.groups[r.PathValue("id")]
+	if !found {
+		writeJSON(w, []map[string]interface{}{{"error": map[string]interface{}{"type": 3, "description": "resource not available"}}})
+		return
+	}
+	for _, id := range group.Lights {
+		applyLightState(bridge.lights[id], params)
+	}
+	writeJSON(w, []map[string]interface{}{{"success": params}})
+}
+
+// applyLightState changes the state of a light. Unreachable lights keep
+// their state.
+func applyLightState(light *hue.LightAttributes, params map[string]interface{}) {
+	if !light.State.Reachable {
+		return
+	}
+
+	if on, ok := params["on"].(bool); ok {
+		light.State.On = on
+	}
+	if bri, ok := params["bri"].(float64); ok {
+		light.State.Bri = int(bri)
+	}
+	// The bridge prefers xy colors if both color modes are given
+	if ct, ok := params["ct"].(float64); ok {
+		light.State.Ct = int(ct)
+		light.State.ColorMode = "ct"
+	}
+	if xy, ok := params["xy"].([]interface{}); ok && len(xy) == 2 {
+		light.State.Xy = []float32{float32(xy[0].(float64)), float32(xy[1].(float64))}
+		light.State.ColorMode = "xy"
+	}
+}
+
+func (bridge *fakeBridge) modifyScene(w http.ResponseWriter, r *http.Request, request interface{}, modify func(scene *hue.Scene)) {
+	if err := json.NewDecoder(r.Body).Decode(request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	bridge.lock.Lock()
+	defer bridge.lock.Unlock()
+	scene, found := bridge.scenes[r.PathValue("id")]
+	if !found {
+		writeJSON(w, []map[string]interface{}{{"error": map[string]interface{}{"type": 3, "description": "resource not available"}}})
+		return
+	}
+	modify(scene)
+	writeJSON(w, []map[string]interface{}{{"success": map[string]interface{}{r.URL.Path: request}}})
+}
+
+func (bridge *fakeBridge) address() string {
+	return strings.TrimPrefix(bridge.server.URL, "http://")
+}
+
+// addLight adds a light of the given type which is turned on.
+func (bridge *fakeBridge) addLight(id string, name string, lightType string) {
+	bridge.lock.Lock()
+	defer bridge.lock.Unlock()
+	bridge.lights[id] = &hue.LightAttributes{
+		Name:  name,
+		Type:  lightType,
+		State: hue.LightState{On: true, Bri: 254, Ct: 366, Xy: []float32{0.4573, 0.41}, ColorMode: "ct", Reachable: true},
+	}
+}
+
+// addScene adds a scene containing the given lights.
+func (bridge *fakeBridge) addScene(id string, name string, lights ...string) {
+	bridge.lock.Lock()
+	defer bridge.lock.Unlock()
+	bridge.scenes[id] = &hue.Scene{Name: name, Lights: lights, LightStates: make(map[string]hue.LightState)}
+}
+
+// addGroup adds a room containing the given lights.
+func (bridge *fakeBridge) addGroup(id string, name string, lights ...string) {
+	bridge.lock.Lock()
+	defer bridge.lock.Unlock()
+	bridge.groups[id] = &HueGroup{Name: name, Type: "Room", Lights: lights}
+}
+
+// change modifies the state of a light the way a switch or another app would.
+func (bridge *fakeBridge) change(id string, change func(state *hue.LightState)) {
+	bridge.lock.Lock()
+	defer bridge.lock.Unlock()
+	change(&bridge.lights[id].State)
+}
+
+func (bridge *fakeBridge) state(id string) hue.LightState {
+	bridge.lock.Lock()
+	defer bridge.lock.Unlock()
+	return bridge.lights[id].State
+}
+
+func (bridge *fakeBridge) scene(id string) hue.Scene {
+	bridge.lock.Lock()
+	defer bridge.lock.Unlock()
+	scene := *bridge.scenes[id]
+	sort.Strings(scene.Lights)
+	return scene
+}
+
+// setDelay delays all following responses of the bridge.
+func (bridge *fakeBridge) setDelay(delay time.Duration) {
+	bridge.lock.Lock()
+	defer bridge.lock.Unlock()
+	bridge.delay = delay
+}
+
+// requests returns the number of light state requests received so far.
+func (bridge *fakeBridge) requests() int {
+	bridge.lock.Lock()
+	defer bridge.lock.Unlock()
+	return bridge.stateRequests
+}
+
+// groupActions returns the number of group actions received so far.
+func (bridge *fakeBridge) groupActions() int {
+	bridge.lock.Lock()
+	defer bridge.lock.Unlock()
+	return bridge.groupRequests
+}
+
+// connect returns a HueBridge connected to the fake bridge.
+func (bridge *fakeBridge) connect(t *testing.T) *HueBridge {
+	t.Helper()
+	disableRateLimiting := *flagDisableRateLimiting
+	*flagDisableRateLimiting = true
+	t.Cleanup(func() { *flagDisableRateLimiting = disableRateLimiting })
+
+	hueBridge := &HueBridge{Main: true, BridgeIP: bridge.address(), Username: fakeBridgeUsername}
+	if err := hueBridge.validateBridge(); err != nil {
+		t.Fatal(err)
+	}
+	if hueBridge.Version != 1 {
+		t.Errorf("fake bridge identified as version %d; want 1", hueBridge.Version)
+	}
+	if err := hueBridge.connect(); err != nil {
+		t.Fatal(err)
+	}
+	hueBridge.ready.Store(true)
+	return hueBridge
+}
+
+func TestFakeBridgeRejectsUnknownUser(t *testing.T) {
+	fake := newFakeBridge(t)
+	bridge := &HueBridge{BridgeIP: fake.address(), Username: "unknown"}
+	if err := bridge.connect(); err == nil {
+		t.Errorf("connection with an unknown user should fail")
+	}
+}
@@ -1,8 +1,9 @@
 module github.com/stefanwichmann/kelvin
 
-go 1.24.0
+go 1.26.0
 
 require (
+	github.com/BurntSushi/toml v1.6.0
 	github.com/Masterminds/semver v1.5.0
 	github.com/bt51/ntpclient v0.0.0-20140310165113-3045f71e2530
 	github.com/btittelbach/astrotime v0.0.0-20160515101311-7ddba43aa26e
@@ -11,13 +12,19 @@ require (
 	github.com/gorilla/mux v1.8.1
 	github.com/sirupsen/logrus v1.9.4
 	github.com/stefanwichmann/go.hue v0.0.0-20220212213913-58bb9edbe001
+	github.com/stefanwichmann/lanscan v0.0.0-20190324154315-2a77f896f93a
+	go.yaml.in/yaml/v3 v3.0.5
+	golang.org/x/net v0.60.0
 )
 
 require (
 	github.com/felixge/httpsnoop v1.0.4 // indirect
 	github.com/onsi/ginkgo v1.16.5 // indirect
 	github.com/onsi/gomega v1.18.1 // indirect
-	github.com/stefanwichmann/lanscan v0.0.0-20190324154315-2a77f896f93a // indirect
-	golang.org/x/sys v0.41.0 // indirect
+	golang.org/x/sys v0.48.0 // indirect
 	gopkg.in/yaml.v2 v2.4.0 // indirect
 )
+
+// go.hue includes Bridge.SetClient, which pins the certificate of the bridge
+// for all requests. Remove once the fork is tagged with it.
+replace github.com/stefanwichmann/go.hue => ./third_party/go.hue
@@ -0,0 +1,282 @@
+// MIT License
+//
+// # Copyright (c) 2019 Stefan Wichmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	hue "github.com/stefanwichmann/go.hue"
+)
+
+// The bridge forwards a group action as one broadcast to all lights of the
+// group. Philips recommends not to send more than one per second.
+const timeBetweenGroupActions = time.Second
+const groupRefreshInterval = 5 * time.Minute
+
+// HueGroup is a room, zone or light group defined on the bridge.
+type HueGroup struct {
+	ID     string   `json:"-"`
+	Name   string   `json:"name"`
+	Type   string   `json:"type"`
+	Lights []string `json:"lights"`
+}
+
+// GroupStatistics counts the group actions sent to a bridge and the light
+// commands they replaced.
+type GroupStatistics struct {
+	GroupActions int `json:"groupActions"`
+	SavedCalls   int `json:"savedCalls"`
+}
+
+// hueGroups caches the groups of a bridge and limits the rate of group
+// actions.
+type hueGroups struct {
+	lock       sync.Mutex
+	groups     []HueGroup
+	updated    time.Time
+	lastAction time.Time
+	statistics GroupStatistics
+}
+
+// Groups returns all groups of the bridge. They are cached for a few minutes
+// as they rarely change.
+func (bridge *HueBridge) Groups() ([]HueGroup, error) {
+	bridge.groups.lock.Lock()
+	cached := bridge.groups.groups
+	if !bridge.groups.updated.IsZero() && time.Since(bridge.groups.updated) < groupRefreshInterval {
+		bridge.groups.lock.Unlock()
+		return cached, nil
+	}
+	bridge.groups.lock.Unlock()
+
+	// The lock isn't held during the request so the statistics can be read
+	var response map[string]HueGroup
+	err := bridge.requests.do(priorityHousekeeping, func() error {
+		return bridge.apiRequest("GET", "/groups", nil, &response)
+	})
+	if err != nil {
+		return cached, err
+	}
+	var groups []HueGroup
+	for id, group := range response {
+		group.ID = id
+		groups = append(groups, group)
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].ID < groups[j].ID })
+	log.Debugf("⌘ Found %d groups on bridge %s", len(groups), bridge)
+
+	bridge.groups.lock.Lock()
+	defer bridge.groups.lock.Unlock()
+	bridge.groups.groups = groups
+	bridge.groups.updated = time.Now()
+	return groups, nil
+}
+
+// SetGroupState sends the given state to all lights of the group with one
+// command. Only one group action per second is sent, a group action
+// exceeding this limit is rejected so the lights can be updated
+// individually.
+func (bridge *HueBridge) SetGroupState(group HueGroup, state hue.SetLightState) error {
+	bridge.groups.lock.Lock()
+	if time.Since(bridge.groups.lastAction) < timeBetweenGroupActions {
+		bridge.groups.lock.Unlock()
+		return fmt.Errorf("group action to %s exceeds the rate limit", group.Name)
+	}
+	bridge.groups.lastAction = time.Now()
+	bridge.groups.lock.Unlock()
+
+	// The lock isn't held during the request so the statistics can be read
+	var results []map[string]json.RawMessage
+	err := bridge.requests.do(priorityUpdate, func() error {
+		return bridge.apiRequest("PUT", "/groups/"+group.ID+"/action", lightStateParameters(state), &results)
+	})
+	if err != nil {
+		return err
+	}
+	for _, result := range results {
+		if message, found := result["error"]; found {
+			var bridgeError struct {
+				Description string `json:"description"`
+			}
+			json.Unmarshal(message, &bridgeError)
+			return fmt.Errorf("bridge rejected group action: %s", bridgeError.Description)
+		}
+	}
+
+	bridge.groups.lock.Lock()
+	defer bridge.groups.lock.Unlock()
+	bridge.groups.statistics.GroupActions++
+	bridge.groups.statistics.SavedCalls += len(group.Lights) - 1
+	return nil
+}
+
+func (bridge *HueBridge) groupCommands() bool {
+	return bridge.GroupCommands
+}
+
+// GroupStatistics returns the number of group actions sent to the bridge
+// and the bridge calls saved by them.
+func (bridge *HueBridge) GroupStatistics() GroupStatistics {
+	bridge.groups.lock.Lock()
+	defer bridge.groups.lock.Unlock()
+	return bridge.groups.statistics
+}
+
+// apiRequest sends a request to the hue API v1 of the bridge. It is used
+// for resources not covered by go.hue.
+func (bridge *HueBridge) apiRequest(method string, path string, body interface{}, response interface{}) error {
+	scheme := "http"
+	if bridge.https {
+		scheme = "https"
+	}
+
+	var content strings.Builder
+	if body != nil {
+		err := json.NewEncoder(&content).Encode(body)
+		if err != nil {
+			return err
+		}
+	}
+	request, err := http.NewRequest(method, fmt.Sprintf("%s://%s/api/%s%s", scheme, bridge.BridgeIP, bridge.Username, path), strings.NewReader(content.String()))
+	if err != nil {
+		return err
+	}
+	resp, err := bridge.client.Do(request)
+	bridge.recordContact(err)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("bridge responded with status %s", resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(response)
+}
+
+// lightStateParameters converts the light state the way go.hue does for a
+// single light.
+func lightStateParameters(state hue.SetLightState) map[string]interface{} {
+	params := make(map[string]interface{})
+	if state.On != "" {
+		value, _ := strconv.ParseBool(state.On)
+		params["on"] = value
+	}
+	if state.Bri != "" {
+		params["bri"], _ = strconv.Atoi(state.Bri)
+	}
+	if state.Xy != nil {
+		params["xy"] = state.Xy
+	}
+	if state.Ct != "" {
+		params["ct"], _ = strconv.Atoi(state.Ct)
+	}
+	if state.TransitionTime != "" {
+		params["transitiontime"], _ = strconv.Atoi(state.TransitionTime)
+	}
+	return params
+}
+
+// updateGroupsOfBackend sends one group action to every group whose lights
+// all await the same light state. It returns the lights updated this way.
+// Lights which are turned off, changed manually or need a different state
+// than the rest of their group are left to the regular update.
+func updateGroupsOfBackend(backend GroupBackend, lights []*Light, transitionTime time.Duration) map[*Light]bool {
+	updated := make(map[*Light]bool)
+	groups, err := backend.Groups()
+	if err != nil {
+		log.Debugf("🤖 Failed to read groups of bridge %s: %v", backend, err)
+	}
+
+	// Prefer large groups to save as many calls as possible
+	groups = append([]HueGroup(nil), groups...)
+	sort.SliceStable(groups, func(i, j int) bool { return len(groups[i].Lights) > len(groups[j].Lights) })
+
+	for _, group := range groups {
+		if len(group.Lights) < 2 {
+			continue
+		}
+		members, state, ok := groupLightState(group, backend, lights, updated, transitionTime)
+		if !ok {
+			continue
+		}
+
+		err := backend.SetGroupState(group, state)
+		if err != nil {
+			log.Debugf("🤖 Group %s - Falling back to single light commands: %v", group.Name, err)
+			continue
+		}
+		for _, member := range members {
+			member.light.HueLight = member.hueLight
+			updated[member.light] = true
+		}
+		target := members[0].light.TargetLightState
+		log.Printf("🤖 Group %s - Updated light state of %d lights to %vK at %v%% brightness", group.Name, len(members), target.ColorTemperature, target.Brightness)
+	}
+	return updated
+}
+
+type groupMember struct {
+	light    *Light
+	hueLight HueLight
+}
+
+// groupLightState returns the lights of the group and the common state to
+// send if all of them await the same light state.
+func groupLightState(group HueGroup, backend LightBackend, lights []*Light, updated map[*Light]bool, transitionTime time.Duration) ([]groupMember, hue.SetLightState, bool) {
+	var members []groupMember
+	var state hue.SetLightState
+	for _, id := range group.Lights {
+		light := lightOfBackend(backend, lights, id)
+		if light == nil || updated[light] || !light.awaitsUpdate() {
+			return nil, state, false
+		}
+
+		// Prepare the state on a copy, the light keeps its targets if the
+		// group action is not sent.
+		member := groupMember{light: light, hueLight: light.HueLight}
+		memberState := member.hueLight.prepareLightState(light.TargetLightState.ColorTemperature, light.TargetLightState.Brightness, transitionTime, false)
+		if len(members) > 0 && !reflect.DeepEqual(memberState, state) {
+			return nil, state, false
+		}
+		state = memberState
+		members = append(members, member)
+	}
+	return members, state, true
+}
+
+func lightOfBackend(backend LightBackend, lights []*Light, id string) *Light {
+	for _, light := range lights {
+		if light.backend == backend && strconv.Itoa(light.ID) == id {
+			return light
+		}
+	}
+	return nil
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	hue "github.com/stefanwichmann/go.hue"
+)
+
+// newTestGroup returns three automatic lights of the same room which
+// finished their initialization.
+func newTestGroup(t *testing.T, groupCommands bool) (*fakeBridge, *HueBridge, []*Light) {
+	t.Helper()
+	fake := newFakeBridge(t)
+	for _, id := range []string{"1", "2", "3"} {
+		fake.addLight(id, "Light "+id, "Extended color light")
+	}
+	fake.addGroup("1", "Living room", "1", "2", "3")
+	bridge := fake.connect(t)
+	bridge.GroupCommands = groupCommands
+	lights, err := bridge.Lights()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, light := range lights {
+		light.Scheduled = true
+		light.Schedule.enableWhenLightsAppear = true
+		light.TargetLightState = LightState{ColorTemperature: 2700, Brightness: 60}
+	}
+
+	updateLightsOfBackend(bridge, lights)
+	for _, light := range lights {
+		light.Appearance = time.Now().Add(-initializationDuration)
+	}
+	updateLightsOfBackend(bridge, lights)
+	for _, light := range lights {
+		if !light.Automatic || light.Initializing {
+			t.Fatalf("light %s should be initialized (Automatic: %v, Initializing: %v)", light.Name, light.Automatic, light.Initializing)
+		}
+	}
+	return fake, bridge, lights
+}
+
+func setTarget(lights []*Light, target LightState) {
+	for _, light := range lights {
+		light.TargetLightState = target
+	}
+}
+
+func TestGroupAction(t *testing.T) {
+	fake, bridge, lights := newTestGroup(t, true)
+	requests := fake.requests()
+
+	target := LightState{ColorTemperature: 3000, Brightness: 80}
+	setTarget(lights, target)
+	updateLightsOfBackend(bridge, lights)
+	if fake.groupActions() != 1 || fake.requests() != requests {
+		t.Errorf("lights should be updated by one group action but received %d group actions and %d light commands", fake.groupActions(), fake.requests()-requests)
+	}
+	for _, id := range []string{"1", "2", "3"} {
+		if state := fake.state(id); !hasTargetState(state, target) {
+			t.Errorf("light %s should have been set to %+v but has state %+v", id, target, state)
+		}
+	}
+	if statistics := bridge.GroupStatistics(); statistics.GroupActions != 1 || statistics.SavedCalls != 2 {
+		t.Errorf("one group action should save two calls but statistics are %+v", statistics)
+	}
+
+	// The new state is not mistaken for a manual change
+	updateLightsOfBackend(bridge, lights)
+	for _, light := range lights {
+		if !light.Automatic {
+			t.Errorf("light %s should still be automatic", light.Name)
+		}
+	}
+	if fake.groupActions() != 1 || fake.requests() != requests {
+		t.Errorf("lights in target state should not be updated again")
+	}
+}
+
+func TestGroupActionFallback(t *testing.T) {
+	tests := []struct {
+		name          string
+		groupCommands bool
+		change        func(fake *fakeBridge, lights []*Light)
+		untouched     string
+	}{
+		{"disabled", false, func(fake *fakeBridge, lights []*Light) {}, ""},
+		{"manual change", true, func(fake *fakeBridge, lights []*Light) {
+			fake.change("3", func(state *hue.LightState) { state.Bri = 20 })
+		}, "3"},
+		{"light turned off", true, func(fake *fakeBridge, lights []*Light) {
+			fake.change("3", func(state *hue.LightState) { state.On = false })
+		}, "3"},
+		{"different target", true, func(fake *fakeBridge, lights []*Light) {
+			lights[2].TargetLightState = LightState{ColorTemperature: 3500, Brightness: 100}
+		}, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fake, bridge, lights := newTestGroup(t, test.groupCommands)
+			target := LightState{ColorTemperature: 3000, Brightness: 80}
+			setTarget(lights, target)
+			test.change(fake, lights)
+			before := fake.state("3")
+
+			updateLightsOfBackend(bridge, lights)
+			if fake.groupActions() != 0 {
+				t.Errorf("no group action should be sent")
+			}
+			for _, light := range lights {
+				id := strconv.Itoa(light.ID)
+				state := fake.state(id)
+				switch {
+				case id == test.untouched:
+					if state.Bri != before.Bri || state.On != before.On {
+						t.Errorf("light %s should be left untouched but has state %+v", id, state)
+					}
+				case !hasTargetState(state, light.TargetLightState):
+					t.Errorf("light %s should have been set to %+v but has state %+v", id, light.TargetLightState, state)
+				}
+			}
+			if statistics := bridge.GroupStatistics(); statistics.SavedCalls != 0 {
+				t.Errorf("no calls should be saved but statistics are %+v", statistics)
+			}
+		})
+	}
+}
+
+func TestGroupActionRateLimit(t *testing.T) {
+	fake, bridge, lights := newTestGroup(t, true)
+
+	setTarget(lights, LightState{ColorTemperature: 3000, Brightness: 80})
+	updateLightsOfBackend(bridge, lights)
+
+	// A second group action within a second is replaced by light commands
+	requests := fake.requests()
+	target := LightState{ColorTemperature: 3200, Brightness: 90}
+	setTarget(lights, target)
+	updateLightsOfBackend(bridge, lights)
+	if fake.groupActions() != 1 || fake.requests() != requests+3 {
+		t.Errorf("lights should be updated individually but received %d group actions and %d light commands", fake.groupActions(), fake.requests()-requests)
+	}
+	for _, id := range []string{"1", "2", "3"} {
+		if state := fake.state(id); !hasTargetState(state, target) {
+			t.Errorf("light %s should have been set to %+v but has state %+v", id, target, state)
+		}
+	}
+}
+
+func TestGroupStatisticsDuringGroupAction(t *testing.T) {
+	fake, bridge, _ := newTestGroup(t, true)
+	groups, err := bridge.Groups()
+	if err != nil || len(groups) != 1 {
+		t.Fatalf("expected one group (Groups: %v, Error: %v)", groups, err)
+	}
+
+	fake.setDelay(time.Second)
+	done := make(chan error)
+	go func() {
+		done <- bridge.SetGroupState(groups[0], hue.SetLightState{On: "true", Bri: "200", Ct: "300"})
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	start := time.Now()
+	bridge.GroupStatistics()
+	if waited := time.Since(start); waited > 500*time.Millisecond {
+		t.Errorf("statistics should not wait for the pending group action but waited %v", waited)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if statistics := bridge.GroupStatistics(); statistics.GroupActions != 1 {
+		t.Errorf("group action should be counted but statistics are %+v", statistics)
+	}
+}
@@ -36,6 +36,9 @@ function uploadConfiguration(configuration) {
       } else {
         console.log(result);
       }
+    },
+    error: function(xhr) {
+      $("#message").append('<div class="alert alert-danger alert-dismissable"><a href="#" class="close" data-dismiss="alert" aria-label="close">&times;</a><strong>Error:</strong> '+xhr.responseText+'</div>');
     }
   });
 }
@@ -56,6 +59,7 @@ function readConfiguration(target){
   var configuration = Object();
   configuration.Bridge = bridge
   configuration.Location = location
+  configuration.Timezone = $(target).find("#timezone").val().trim();
   configuration.WebInterface = webinterface
 
   return configuration;
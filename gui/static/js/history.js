@@ -0,0 +1,24 @@
+$(document).ready(function(){
+  $(".revert").click(function(){
+    var version = $(this).data("version");
+    console.log("Revert button clicked for version "+version);
+    revertConfiguration(version);
+  });
+});
+
+function revertConfiguration(version) {
+  $.ajax({
+    url: "/configuration/history/"+version+"/revert",
+    type: 'PUT',
+    success: function(result) {
+      if (result == "success") {
+        $("#message").append('<div class="alert alert-success alert-dismissable"><a href="#" class="close" data-dismiss="alert" aria-label="close">&times;</a><strong>Configuration reverted to version '+version+'.</strong> <a href="history.html">Reload</a> to see the new version.</div>');
+      } else {
+        console.log(result);
+      }
+    },
+    error: function(xhr) {
+      $("#message").append('<div class="alert alert-danger alert-dismissable"><a href="#" class="close" data-dismiss="alert" aria-label="close">&times;</a><strong>Error:</strong> '+xhr.responseText+'</div>');
+    }
+  });
+}
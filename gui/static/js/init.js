@@ -1,36 +1,105 @@
 $(document).ready(function(){
-  $(".continueButton").click(function(){
-    console.log("Continue button clicked");
-    uploadConfiguration($("#ip").val());
+  $(".pairButton").click(function(){
+    console.log("Pair button clicked");
+    startPairing($("#ip").val());
   });
+  $("#bridges").on("click", ".bridge", function(){
+    startPairing($(this).data("address"));
+  });
+  $(".discoverButton").click(function(){
+    sendPairingCommand("/pairing/discover", null);
+  });
+  $(".cancelButton").click(function(){
+    sendPairingCommand("/pairing/cancel", null);
+  });
+  $("#message").on("click", ".retryButton", function(){
+    startPairing($(this).data("address"));
+  });
+  updatePairing();
 });
 
-function uploadConfiguration(ip) {
-  var configuration = Object();
-  var bridge = Object();
-  bridge.IP = ip;
-  configuration.bridge = bridge;
-  console.log(JSON.stringify(configuration));
+function startPairing(address) {
+  sendPairingCommand("/pairing/start", JSON.stringify({address: address}));
+}
+
+function sendPairingCommand(url, data) {
   $.ajax({
-    url: "/configuration",
+    url: url,
     type: 'PUT',
-    data: JSON.stringify(configuration),
+    data: data,
     contentType: 'application/json',
     success: function(result) {
-      console.log(result);
-      if (result == "success") {
-        restartKelvin();
-        window.setTimeout(function(){location.reload(true);}, 5000);
-      } else {
-        console.log(result);
-      }
+      updatePairing();
+    },
+    error: function(xhr) {
+      showMessage("danger", '<strong>Error:</strong> '+xhr.responseText);
     }
   });
 }
 
-function restartKelvin() {
-  $.ajax({
-    url: "/restart",
-    type: 'PUT'
+function updatePairing() {
+  $.getJSON("/pairing", function(status) {
+    renderPairing(status);
+    if (status.state == "paired") {
+      window.setTimeout(function(){location.reload(true);}, 3000);
+    } else {
+      window.setTimeout(updatePairing, 1000);
+    }
+  }).fail(function() {
+    // Kelvin might be restarting
+    window.setTimeout(updatePairing, 5000);
   });
 }
+
+function renderPairing(status) {
+  $("#discovering").toggleClass("hidden", status.state != "discovering");
+  $("#discoveryDone").toggleClass("hidden", status.bridges.length == 0);
+  $(".pairButton, .discoverButton").prop("disabled", status.state == "discovering");
+
+  var bridges = $("#bridges").empty();
+  $.each(status.bridges, function(index, bridge) {
+    var image = bridge.version == 1 ? "bridge_v1.svg" : "bridge_v2.svg";
+    var item = $('<button type="button" class="bridge list-group-item"></button>').data("address", bridge.address);
+    item.append($('<img height="40" width="40">').attr("src", "/static/images/"+image));
+    item.append(" ").append($("<strong></strong>").text(bridge.address));
+    item.append(" ").append($("<small></small>").text((bridge.id ? bridge.id+", " : "")+"found via "+bridge.method));
+    bridges.append(item);
+  });
+
+  $("#waiting").toggleClass("hidden", status.state != "waiting");
+  if (status.state == "waiting") {
+    $("#address").text(status.address);
+    $("#pushlink").attr("src", status.version == 1 ? "/static/images/pushlink_bridgev1.svg" : "/static/images/pushlink_bridgev2.svg");
+    $("#countdown").css("width", (100*status.remaining/status.timeout)+"%").text(status.remaining+"s");
+  }
+
+  switch (status.state) {
+  case "paired":
+    showMessage("success", "<strong>Kelvin is paired with your bridge.</strong> Loading your lights...");
+    break;
+  case "timeout":
+  case "failed":
+    showMessage("danger", $("<span></span>").text(status.error).html()+' <button type="button" class="retryButton btn btn-default btn-xs">Try again</button>');
+    $(".retryButton").data("address", status.address);
+    break;
+  case "cancelled":
+    showMessage("info", "Pairing cancelled.");
+    break;
+  case "selecting":
+    if (status.error) {
+      showMessage("warning", $("<span></span>").text(status.error).html());
+      break;
+    }
+  default:
+    $("#message").empty();
+  }
+}
+
+function showMessage(type, html) {
+  var current = $("#message .alert");
+  if (current.length == 1 && current.data("html") == type+html) {
+    return;
+  }
+  var alert = $('<div class="alert alert-'+type+'"></div>').html(html).data("html", type+html);
+  $("#message").empty().append(alert);
+}
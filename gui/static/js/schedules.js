@@ -156,7 +156,9 @@ function parseIDs(text) {
   }
   var ids = text.trim().split(",");
   for (index in ids ) {
-    ids[index] = parseInt(ids[index], 10);
+    // Lights of additional bridges are qualified by the bridge name, e.g. garage:3
+    var id = ids[index].trim();
+    ids[index] = /^[0-9]+$/.test(id) ? parseInt(id, 10) : id;
   }
   return ids;
 }
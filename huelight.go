@@ -37,7 +37,6 @@ var lightsSupportingXYColor = []string{"Color light", "Extended color light"}
 // HueLight represents a physical hue light.
 type HueLight struct {
 	Name                     string
-	HueLight                 hue.Light
 	SetColorTemperature      int
 	SetBrightness            int
 	TargetColorTemperature   int
@@ -53,6 +52,9 @@ type HueLight struct {
 	Reachable                bool
 	On                       bool
 	MinimumColorTemperature  int
+
+	controller LightController
+	scheduler  *RequestScheduler
 }
 
 func (light *HueLight) initialize(attr hue.LightAttributes) {
@@ -110,6 +112,42 @@ func (light *HueLight) updateCurrentLightState(attr hue.LightAttributes) {
 }
 
 func (light *HueLight) setLightState(colorTemperature int, brightness int, transitionTime time.Duration) error {
+	return light.sendLightState(colorTemperature, brightness, transitionTime, false, priorityUpdate)
+}
+
+// initializeLightState sets the given light state ahead of all regular
+// updates, e.g. for a light which just appeared.
+func (light *HueLight) initializeLightState(colorTemperature int, brightness int, transitionTime time.Duration) error {
+	return light.sendLightState(colorTemperature, brightness, transitionTime, false, priorityAppearance)
+}
+
+// switchOn turns the light on and sets the given light state.
+func (light *HueLight) switchOn(colorTemperature int, brightness int, transitionTime time.Duration) error {
+	return light.sendLightState(colorTemperature, brightness, transitionTime, true, priorityUpdate)
+}
+
+func (light *HueLight) sendLightState(colorTemperature int, brightness int, transitionTime time.Duration, switchOn bool, priority requestPriority) error {
+	hueLightState := light.prepareLightState(colorTemperature, brightness, transitionTime, switchOn)
+
+	// Send new state to the light
+	log.Debugf("💡 HueLight %s - Setting light state to %dK and %d%% brightness (TargetColorTemperature: %d, CurrentColorTemperature: %d, TargetColor: %v, CurrentColor: %v, TargetBrightness: %d, CurrentBrightness: %d, TransitionTime: %s)", light.Name, colorTemperature, brightness, light.TargetColorTemperature, light.CurrentColorTemperature, light.TargetColor, light.CurrentColor, light.TargetBrightness, light.CurrentBrightness, hueLightState.TransitionTime)
+	var result []hue.Result
+	err := light.scheduler.do(priority, func() (err error) {
+		result, err = light.controller.SetState(hueLightState)
+		return err
+	})
+	if err != nil {
+		log.Warningf("💡 HueLight %s - Setting light state failed: %v (Result: %v)", light.Name, err, result)
+		return err
+	}
+
+	log.Debugf("💡 HueLight %s - Light was successfully updated (TargetColorTemperature: %d, CurrentColorTemperature: %d, TargetColor: %v, CurrentColor: %v, TargetBrightness: %d, CurrentBrightness: %d, TransitionTime: %s)", light.Name, light.TargetColorTemperature, light.CurrentColorTemperature, light.TargetColor, light.CurrentColor, light.TargetBrightness, light.CurrentBrightness, hueLightState.TransitionTime)
+	return nil
+}
+
+// prepareLightState adopts the given light state as new target and returns
+// the state to send to the light.
+func (light *HueLight) prepareLightState(colorTemperature int, brightness int, transitionTime time.Duration, switchOn bool) hue.SetLightState {
 	if colorTemperature != -1 && (colorTemperature < 1000 || colorTemperature > 6500) {
 		log.Warningf("💡 Light %s - Invalid color temperature %d", light.Name, colorTemperature)
 	}
@@ -144,6 +182,10 @@ func (light *HueLight) setLightState(colorTemperature int, brightness int, trans
 		}
 	}
 
+	if switchOn && brightness != 0 {
+		hueLightState.On = "true"
+	}
+
 	if brightness != -1 {
 		if brightness == 0 {
 			// Target brightness zero should turn the light off.
@@ -153,16 +195,7 @@ func (light *HueLight) setLightState(colorTemperature int, brightness int, trans
 		}
 	}
 
-	// Send new state to the light
-	log.Debugf("💡 HueLight %s - Setting light state to %dK and %d%% brightness (TargetColorTemperature: %d, CurrentColorTemperature: %d, TargetColor: %v, CurrentColor: %v, TargetBrightness: %d, CurrentBrightness: %d, TransitionTime: %s)", light.Name, colorTemperature, brightness, light.TargetColorTemperature, light.CurrentColorTemperature, light.TargetColor, light.CurrentColor, light.TargetBrightness, light.CurrentBrightness, hueLightState.TransitionTime)
-	result, err := light.HueLight.SetState(hueLightState)
-	if err != nil {
-		log.Warningf("💡 HueLight %s - Setting light state failed: %v (Result: %v)", light.Name, err, result)
-		return err
-	}
-
-	log.Debugf("💡 HueLight %s - Light was successfully updated (TargetColorTemperature: %d, CurrentColorTemperature: %d, TargetColor: %v, CurrentColor: %v, TargetBrightness: %d, CurrentBrightness: %d, TransitionTime: %s)", light.Name, light.TargetColorTemperature, light.CurrentColorTemperature, light.TargetColor, light.CurrentColor, light.TargetBrightness, light.CurrentBrightness, hueLightState.TransitionTime)
-	return nil
+	return hueLightState
 }
 
 func (light *HueLight) hasChanged() bool {
@@ -27,6 +27,7 @@ import (
 	"os"
 	"os/signal"
 	"runtime"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -44,12 +45,26 @@ var flagForceUpdate = flag.Bool("forceUpdate", false, "Update to new major versi
 var flagEnableUpdates = flag.Bool("enableUpdates", true, "Enable automatic updates")
 var flagEnableWebInterface = flag.Bool("enableWebInterface", false, "Enable the web interface at startup")
 var flagDisableRateLimiting = flag.Bool("disableRateLimiting", false, "Disable the limiting of requests to the hue bridge")
+var flagDisableEventStream = flag.Bool("disableEventStream", false, "Poll light states instead of using the event stream of the hue API v2")
 var flagDisableHTTPS = flag.Bool("disableHTTPS", false, "Disable HTTPS for the connection to the hue bridge")
+var flagConfigurationBackups = flag.Int("backups", 10, "Number of configuration backups to keep")
+var flagConfigurationHistory = flag.Int("history", 100, "Number of configuration versions to keep in the history")
+var flagStrictConfiguration = flag.Bool("strict", false, "Reject configurations containing unknown fields")
+var flagOverrideValues overrideFlag
+
+func init() {
+	flag.Var(&flagOverrideValues, "set", "Override a configuration value without saving it (e.g. -set bridge.ip=192.168.1.2)")
+}
 
 var configuration *Configuration
-var bridge = &HueBridge{}
+var bridges []*HueBridge
 var lights []*Light
 
+// mainLoopRequests passes the requests of the web interface to the main
+// loop, which owns the configuration and all lights.
+var mainLoopRequests = make(chan func())
+var mainLoopRunning atomic.Bool
+
 const lightUpdateInterval = 1 * time.Second
 const stateUpdateInterval = 1 * time.Minute
 
@@ -60,6 +75,11 @@ func main() {
 	flag.Parse()
 	configureLogging()
 
+	// Run command line tools instead of the service
+	if flag.NArg() > 0 {
+		os.Exit(runCommand(flag.Args()))
+	}
+
 	log.Printf("🤖 Kelvin %s starting up... 🚀", version)
 	log.Debugf("🤖 Built at %s based on commit %s", date, commit)
 	log.Debugf("🤖 GOOS=%s, GOARCH=%s", runtime.GOOS, runtime.GOARCH)
@@ -78,20 +98,31 @@ func main() {
 		log.Fatal(err)
 	}
 	configuration = &conf
+	configureLogTimezone(configuration.TimeLocation())
+	log.Debugf("🤖 Using time zone %s for all calculations", configuration.TimeLocation())
 
 	// Start web interface
+	bridges = newHueBridges(configuration)
 	go startInterface()
 
-	// Find Hue bridge
+	// Find Hue bridges. Kelvin can't run without its main bridge, while
+	// additional bridges are attached as soon as they can be reached.
 	log.Printf("🤖 Initializing bridge connection...")
-	for {
-		err = bridge.InitializeBridge(configuration)
-		if err != nil {
-			log.Errorf("Could not initialize bridge: %v - Retrying...", err)
-			time.Sleep(10 * time.Second)
-		} else {
-			break
+	for _, bridge := range bridges {
+		if !bridge.Main {
+			bridge.initializeInBackground(configuration)
+			continue
 		}
+		for {
+			err = bridge.InitializeBridge(configuration)
+			if err != nil {
+				log.Errorf("Could not initialize bridge %s: %v - Retrying...", bridge, err)
+				time.Sleep(10 * time.Second)
+			} else {
+				break
+			}
+		}
+		bridge.ready.Store(true)
 	}
 
 	// Find geo location
@@ -105,88 +136,209 @@ func main() {
 	if err != nil {
 		log.Fatal(err)
 	}
+	configuration.logHistory(historySourceBridge)
 
-	// Initialize lights
-	l, err := bridge.Lights()
-	if err != nil {
-		log.Warning(err)
+	// Watch configuration file for modifications
+	configurationWatcher.start(configuration.watchedFiles(), configurationWatchInterval)
+
+	// Start weather updates
+	if configuration.Weather.Source != "" {
+		log.Printf("☁ Reading weather from %s", configuration.Weather.Source)
+		weatherCache.start(newLocalWeatherProvider(configuration.Weather.Source), weatherUpdateInterval)
 	}
-	printDevices(l)
-	for _, light := range l {
-		light := light
 
-		// Filter devices we can't control
-		if !light.HueLight.supportsColorTemperature() && !light.HueLight.supportsBrightness() {
-			log.Printf("🤖 Light %s - This device doesn't support any functionality Kelvin uses. Ignoring...", light.Name)
-		} else {
-			lights = append(lights, light)
-			updateScheduleForLight(light)
+	// Start calendar updates
+	if configuration.Calendar.File != "" {
+		log.Printf("📅 Reading calendar from %s", configuration.Calendar.File)
+		calendarWatcher.start(configuration.Calendar, configuration.TimeLocation(), calendarUpdateInterval)
+	}
+
+	// Initialize lights
+	for _, bridge := range bridges {
+		if bridge.ready.Load() {
+			attachLights(bridge)
 		}
 	}
 
 	// Initialize scenes
 	updateScenes()
 
+	// Plan presence simulation
+	planAwayMode(time.Now())
+
 	// Start cyclic update for all lights and scenes
 	log.Debugf("🤖 Starting cyclic update...")
 	lightUpdateTimer := time.NewTimer(lightUpdateInterval)
 	stateUpdateTick := time.Tick(stateUpdateInterval)
-	newDayTimer := time.After(durationUntilNextDay())
+	scenesOutdated := false
+	newDayTimer = time.NewTimer(durationUntilNextDay(configuration.TimeLocation()))
+	clock := clockMonitor{}
+	clock.start()
+	mainLoopRunning.Store(true)
 	for {
 		select {
-		case <-newDayTimer:
+		case request := <-mainLoopRequests:
+			// A web client requested or changed the state of Kelvin
+			request()
+		case <-newDayTimer.C:
 			// A new day has begun, calculate new schedule
-			log.Printf("🤖 Calculating schedule for %v", time.Now().Format("Jan 2 2006"))
-			for _, light := range lights {
-				light := light
-				updateScheduleForLight(light)
-			}
-			updateScenes()
-			newDayTimer = time.After(durationUntilNextDay())
+			updateSchedules()
+			planAwayMode(time.Now())
+			resetNewDayTimer()
+		case <-weatherCache.updates:
+			// The weather changed, adjust daylight
+			updateSchedules()
+		case <-calendarWatcher.updates:
+			// Calendar events started or ended
+			updateSchedules()
+		case <-lightEvents:
+			// A light was switched or changed manually
+			updateLights()
+		case <-configurationWatcher.updates:
+			// The configuration file was modified or SIGHUP received
+			reloadConfiguration()
 		case <-stateUpdateTick:
-			// update interval and color every minute
-			updated := false
-			for _, light := range lights {
-				light := light
-				light.updateInterval()
-				if light.updateTargetLightState() {
-					updated = true
-				}
-			}
-			// update scenes
-			if updated {
+			// update scenes once with the target states of the last minute
+			if scenesOutdated {
 				updateScenes()
+				scenesOutdated = false
 			}
 		case <-lightUpdateTimer.C:
-			states, err := bridge.LightStates()
-			if err != nil {
-				log.Warningf("🤖 Failed to update light states: %v", err)
+			// Did the system resume from suspend or was the clock stepped?
+			if jump, detected := clock.jump(); detected {
+				log.Printf("🤖 Detected a wall clock jump of %v (system suspend or time synchronization)", jump.Round(time.Second))
+				updateSchedules()
+				resetNewDayTimer()
 			}
 
-			for _, light := range lights {
-				light := light
-				currentLightState, found := states[light.ID]
-				if found {
-					light.updateCurrentLightState(currentLightState)
-					updated, err := light.update(lightTransistionTime)
-					if err != nil {
-						log.Warningf("🤖 Light %s - Failed to update light: %v", light.Name, err)
-					}
-					if updated {
-						log.Debugf("🤖 Light %s - Updated light state. Awaiting transition...", light.Name)
-					}
-				} else {
-					log.Warningf("🤖 Light %s - No current light state found", light.Name)
-				}
+			executeAwayMode(time.Now())
+			if updateTargetLightStates(time.Now()) {
+				scenesOutdated = true
 			}
-
+			updateLights()
 			lightUpdateTimer.Reset(lightUpdateInterval)
+			clock.start()
+		}
+	}
+}
+
+// attachLights adds all lights of the given bridge which Kelvin can
+// control.
+func attachLights(bridge *HueBridge) {
+	l, err := bridge.Lights()
+	if err != nil {
+		log.Warning(err)
+	}
+	printDevices(bridge, l)
+	for _, light := range l {
+		light := light
+
+		// Filter devices we can't control
+		if !light.HueLight.supportsColorTemperature() && !light.HueLight.supportsBrightness() {
+			log.Printf("🤖 Light %s - This device doesn't support any functionality Kelvin uses. Ignoring...", light.Name)
+		} else {
+			lights = append(lights, light)
+			updateScheduleForLight(light)
+		}
+	}
+}
+
+// newDayTimer fires when a new day begins in the configured time zone. It
+// is owned by the main loop.
+var newDayTimer *time.Timer
+
+// resetNewDayTimer restarts the timer for the next day, e.g. after the time
+// zone changed.
+func resetNewDayTimer() {
+	if newDayTimer != nil {
+		newDayTimer.Reset(durationUntilNextDay(configuration.TimeLocation()))
+	}
+}
+
+// updateTargetLightStates updates the interval and color of every light
+// once per stateUpdateInterval. The lights are spread over the interval,
+// so their new states don't reach the bridge all at once. It reports
+// whether a target light state changed.
+func updateTargetLightStates(now time.Time) bool {
+	updated := false
+	for index, light := range lights {
+		offset := stateUpdateInterval * time.Duration(index) / time.Duration(len(lights))
+		period := now.Add(-offset).Truncate(stateUpdateInterval)
+		if period.Equal(light.targetPeriod) {
+			continue
+		}
+		light.targetPeriod = period
+		light.updateInterval()
+		if light.updateTargetLightState() {
+			updated = true
+		}
+	}
+	return updated
+}
+
+func updateLights() {
+	for _, bridge := range bridges {
+		if !bridge.ready.Load() {
+			continue
+		}
+		updateLightsOfBackend(bridge, lights)
+		if bridge.connectionLost() {
+			bridge.reconnect()
+		}
+	}
+}
+
+// updateLightsOfBackend reads the current light states from the given
+// backend and updates all of its lights.
+func updateLightsOfBackend(backend LightBackend, lights []*Light) {
+	states, err := backend.LightStates()
+	if err != nil {
+		log.Warningf("🤖 Failed to update light states of bridge %s: %v", backend, err)
+	}
+
+	for _, light := range lights {
+		if currentLightState, found := states[light.ID]; found && light.backend == backend {
+			light.updateCurrentLightState(currentLightState)
+		}
+	}
+
+	// Send one command to groups of lights awaiting the same state
+	updatedByGroup := make(map[*Light]bool)
+	if groupBackend, ok := backend.(GroupBackend); ok && groupBackend.groupCommands() {
+		updatedByGroup = updateGroupsOfBackend(groupBackend, lights, lightTransistionTime)
+	}
+
+	for _, light := range lights {
+		light := light
+		if light.backend != backend || updatedByGroup[light] {
+			continue
+		}
+		_, found := states[light.ID]
+		if found {
+			updated, err := light.update(lightTransistionTime)
+			if err != nil {
+				log.Warningf("🤖 Light %s - Failed to update light: %v", light.Name, err)
+			}
+			if updated {
+				log.Debugf("🤖 Light %s - Updated light state. Awaiting transition...", light.Name)
+			}
+		} else {
+			log.Warningf("🤖 Light %s - No current light state found", light.Name)
 		}
 	}
 }
 
+func updateSchedules() {
+	log.Printf("🤖 Calculating schedule for %v", time.Now().In(configuration.TimeLocation()).Format("Jan 2 2006"))
+	for _, light := range lights {
+		light := light
+		updateScheduleForLight(light)
+	}
+	updateScenes()
+}
+
 func updateScheduleForLight(light *Light) {
-	schedule, err := configuration.lightScheduleForDay(light.ID, time.Now())
+	schedule, err := configuration.lightScheduleForDay(light.Device, time.Now())
 	if err != nil {
 		log.Printf("🤖 Light %s - Light is not associated to any schedule. Ignoring...", light.Name)
 		light.Schedule = schedule // Assign empty schedule
@@ -197,31 +349,40 @@ func updateScheduleForLight(light *Light) {
 	}
 }
 
-func printDevices(l []*Light) {
-	log.Printf("🤖 Devices found on current bridge:")
+func printDevices(bridge *HueBridge, l []*Light) {
+	log.Printf("🤖 Devices found on bridge %s:", bridge)
 	log.Printf("| %-32s | %3v | %-5v | %-8v | %-11v | %-5v | %17v |", "Name", "ID", "On", "Dimmable", "Temperature", "Color", "Temperature range")
 	for _, light := range l {
 		ctRange := ""
 		if light.HueLight.supportsColorTemperature() {
 			ctRange = fmt.Sprintf("%dK - %dK", light.HueLight.MinimumColorTemperature, 6500)
 		}
-		log.Printf("| %-32s | %3v | %-5v | %-8v | %-11v | %-5v | %17v |", light.Name, light.ID, light.On, light.HueLight.Dimmable, light.HueLight.SupportsColorTemperature, light.HueLight.SupportsXYColor, ctRange)
+		log.Printf("| %-32s | %3v | %-5v | %-8v | %-11v | %-5v | %17v |", light.Name, light.Device, light.On, light.HueLight.Dimmable, light.HueLight.SupportsColorTemperature, light.HueLight.SupportsXYColor, ctRange)
 	}
 }
 
 func handleSIGHUP() {
 	sighup := make(chan os.Signal, 1)
 	signal.Notify(sighup, syscall.SIGHUP)
-	<-sighup // wait for signal
-	log.Printf("🤖 Received signal SIGHUP. Restarting...")
-	Restart()
+	for range sighup {
+		log.Printf("🤖 Received signal SIGHUP. Reloading configuration...")
+		configurationWatcher.notify()
+	}
+}
+
+// timezoneFormatter prints all log timestamps in the configured time zone.
+type timezoneFormatter struct {
+	log.Formatter
+	location *time.Location
+}
+
+func (formatter *timezoneFormatter) Format(entry *log.Entry) ([]byte, error) {
+	entry.Time = entry.Time.In(formatter.location)
+	return formatter.Formatter.Format(entry)
 }
 
 func configureLogging() {
-	formatter := new(log.TextFormatter)
-	formatter.FullTimestamp = true
-	formatter.TimestampFormat = "2006/02/01 15:04:05"
-	log.SetFormatter(formatter)
+	configureLogTimezone(time.Local)
 	if *flagDebug {
 		log.SetLevel(log.DebugLevel)
 	}
@@ -235,6 +396,13 @@ func configureLogging() {
 	}
 }
 
+func configureLogTimezone(location *time.Location) {
+	formatter := new(log.TextFormatter)
+	formatter.FullTimestamp = true
+	formatter.TimestampFormat = "2006/02/01 15:04:05"
+	log.SetFormatter(&timezoneFormatter{formatter, location})
+}
+
 func validateSystemTime() {
 	// validate local clock as it forms the basis for all time calculations.
 	valid, err := IsLocalTimeValid()
@@ -32,19 +32,27 @@ const initializationDuration = 3 * time.Second
 
 // Light represents a light kelvin can automate in your system.
 type Light struct {
-	ID               int        `json:"id"`
-	Name             string     `json:"name"`
-	HueLight         HueLight   `json:"-"`
-	TargetLightState LightState `json:"targetLightState,omitempty"`
-	Scheduled        bool       `json:"scheduled"`
-	Reachable        bool       `json:"reachable"`
-	On               bool       `json:"on"`
-	Tracking         bool       `json:"-"`
-	Automatic        bool       `json:"automatic"`
-	Initializing     bool       `json:"-"`
-	Schedule         Schedule   `json:"-"`
-	Interval         Interval   `json:"interval"`
-	Appearance       time.Time  `json:"-"`
+	ID               int         `json:"id"`
+	Device           DeviceID    `json:"device"`
+	Bridge           string      `json:"bridge"`
+	Name             string      `json:"name"`
+	HueLight         HueLight    `json:"-"`
+	TargetLightState LightState  `json:"targetLightState,omitempty"`
+	Scheduled        bool        `json:"scheduled"`
+	Reachable        bool        `json:"reachable"`
+	On               bool        `json:"on"`
+	Tracking         bool        `json:"-"`
+	Automatic        bool        `json:"automatic"`
+	Initializing     bool        `json:"-"`
+	Schedule         Schedule    `json:"-"`
+	Interval         Interval    `json:"interval"`
+	Appearance       time.Time   `json:"-"`
+	Away             []AwayEvent `json:"away,omitempty"`
+
+	backend LightBackend
+	// targetPeriod is the start of the period in which the target light
+	// state was last calculated
+	targetPeriod time.Time
 }
 
 func (light *Light) updateCurrentLightState(attr hue.LightAttributes) error {
@@ -98,7 +106,7 @@ func (light *Light) update(transistionTime time.Duration) (bool, error) {
 		if light.Schedule.enableWhenLightsAppear {
 			log.Printf("💡 Light %s - Initializing state to %vK at %v%% brightness.", light.Name, light.TargetLightState.ColorTemperature, light.TargetLightState.Brightness)
 
-			err := light.HueLight.setLightState(light.TargetLightState.ColorTemperature, light.TargetLightState.Brightness, transistionTime)
+			err := light.HueLight.initializeLightState(light.TargetLightState.ColorTemperature, light.TargetLightState.Brightness, transistionTime)
 			if err != nil {
 				log.Debugf("💡 Light %s - Could not initialize light after %v", light.Name, time.Since(light.Appearance))
 				return true, err
@@ -125,7 +133,7 @@ func (light *Light) update(transistionTime time.Duration) (bool, error) {
 			light.Initializing = true
 
 			// set correct target lightstate on HueLight
-			err := light.HueLight.setLightState(light.TargetLightState.ColorTemperature, light.TargetLightState.Brightness, transistionTime)
+			err := light.HueLight.initializeLightState(light.TargetLightState.ColorTemperature, light.TargetLightState.Brightness, transistionTime)
 			if err != nil {
 				return true, err
 			}
@@ -149,7 +157,7 @@ func (light *Light) update(transistionTime time.Duration) (bool, error) {
 		}
 
 		if hasChanged {
-			err := light.HueLight.setLightState(light.TargetLightState.ColorTemperature, light.TargetLightState.Brightness, transistionTime)
+			err := light.HueLight.initializeLightState(light.TargetLightState.ColorTemperature, light.TargetLightState.Brightness, transistionTime)
 			if err != nil {
 				return true, err
 			}
@@ -186,6 +194,15 @@ func (light *Light) update(transistionTime time.Duration) (bool, error) {
 	return true, nil
 }
 
+// awaitsUpdate reports whether the light is in automatic mode and only
+// waits for a new target light state.
+func (light *Light) awaitsUpdate() bool {
+	if !light.Scheduled || !light.Reachable || !light.On || !light.Tracking || !light.Automatic || light.Initializing {
+		return false
+	}
+	return !light.HueLight.hasChanged() && !light.HueLight.hasState(light.TargetLightState.ColorTemperature, light.TargetLightState.Brightness)
+}
+
 func (light *Light) updateSchedule(schedule Schedule) {
 	light.Schedule = schedule
 	light.Scheduled = true
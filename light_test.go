@@ -0,0 +1,234 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	hue "github.com/stefanwichmann/go.hue"
+)
+
+// newTestLight returns the first light of the fake bridge with a schedule
+// targeting the given light state.
+func newTestLight(t *testing.T, fake *fakeBridge, target LightState, enableWhenLightsAppear bool) (*HueBridge, *Light) {
+	t.Helper()
+	bridge := fake.connect(t)
+	lights, err := bridge.Lights()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lights) == 0 {
+		t.Fatal("fake bridge has no lights")
+	}
+	light := lights[0]
+	light.Scheduled = true
+	light.Schedule.enableWhenLightsAppear = enableWhenLightsAppear
+	light.TargetLightState = target
+	return bridge, light
+}
+
+// hasTargetState reports whether the light on the fake bridge shows the
+// given light state.
+func hasTargetState(state hue.LightState, target LightState) bool {
+	return state.ColorMode == "xy" &&
+		equalsFloat(state.Xy, colorTemperatureToXYColor(target.ColorTemperature), 0.001) &&
+		state.Bri == mapBrightness(target.Brightness)
+}
+
+func TestAutomaticAndManualMode(t *testing.T) {
+	fake := newFakeBridge(t)
+	fake.addLight("1", "Desk", "Extended color light")
+	target := LightState{ColorTemperature: 2700, Brightness: 60}
+	bridge, light := newTestLight(t, fake, target, true)
+	lights := []*Light{light}
+
+	// The light appears and is initialized to the target state
+	updateLightsOfBackend(bridge, lights)
+	if !light.Tracking || !light.Automatic || !light.Initializing {
+		t.Fatalf("appearing light should be tracked and initialized (Tracking: %v, Automatic: %v, Initializing: %v)", light.Tracking, light.Automatic, light.Initializing)
+	}
+	if state := fake.state("1"); !hasTargetState(state, target) {
+		t.Errorf("light should have been set to %+v but has state %+v", target, state)
+	}
+
+	// Initialization ends once the light adopted the state
+	light.Appearance = time.Now().Add(-initializationDuration)
+	updateLightsOfBackend(bridge, lights)
+	if light.Initializing {
+		t.Errorf("initialization should end after %v", initializationDuration)
+	}
+
+	// A new target state is sent to the light
+	target = LightState{ColorTemperature: 3000, Brightness: 80}
+	light.TargetLightState = target
+	updateLightsOfBackend(bridge, lights)
+	if state := fake.state("1"); !hasTargetState(state, target) {
+		t.Errorf("light should have been updated to %+v but has state %+v", target, state)
+	}
+
+	// A manual change disables Kelvin for this light
+	fake.change("1", func(state *hue.LightState) { state.Bri = 20 })
+	updateLightsOfBackend(bridge, lights)
+	if light.Automatic {
+		t.Fatalf("manually changed light should not be automatic anymore")
+	}
+	requests := fake.requests()
+	light.TargetLightState = LightState{ColorTemperature: 3100, Brightness: 85}
+	updateLightsOfBackend(bridge, lights)
+	if fake.requests() != requests {
+		t.Errorf("manually changed light should not be updated")
+	}
+	if state := fake.state("1"); state.Bri != 20 {
+		t.Errorf("manual brightness should be kept but is %d", state.Bri)
+	}
+
+	// Setting the light to the target state (e.g. by a Kelvin scene) activates Kelvin again
+	target = light.TargetLightState
+	fake.change("1", func(state *hue.LightState) {
+		state.Xy = colorTemperatureToXYColor(target.ColorTemperature)
+		state.Bri = mapBrightness(target.Brightness)
+	})
+	updateLightsOfBackend(bridge, lights)
+	if !light.Automatic {
+		t.Errorf("light in target state should be automatic again")
+	}
+
+	// Turning the light off clears the state
+	fake.change("1", func(state *hue.LightState) { state.On = false })
+	updateLightsOfBackend(bridge, lights)
+	if light.Tracking || light.Automatic || light.Initializing {
+		t.Errorf("turned off light should not be tracked (Tracking: %v, Automatic: %v, Initializing: %v)", light.Tracking, light.Automatic, light.Initializing)
+	}
+}
+
+func TestLightAppearsWithoutAutomaticMode(t *testing.T) {
+	fake := newFakeBridge(t)
+	fake.addLight("1", "Desk", "Color temperature light")
+	bridge, light := newTestLight(t, fake, LightState{ColorTemperature: 2700, Brightness: 60}, false)
+
+	updateLightsOfBackend(bridge, []*Light{light})
+	if !light.Tracking || light.Automatic {
+		t.Errorf("appearing light should be tracked in manual mode (Tracking: %v, Automatic: %v)", light.Tracking, light.Automatic)
+	}
+	if fake.requests() != 0 {
+		t.Errorf("light should not be changed if Kelvin is not enabled when lights appear")
+	}
+}
+
+func TestUnreachableLight(t *testing.T) {
+	fake := newFakeBridge(t)
+	fake.addLight("1", "Desk", "Extended color light")
+	target := LightState{ColorTemperature: 2700, Brightness: 60}
+	bridge, light := newTestLight(t, fake, target, true)
+	lights := []*Light{light}
+
+	updateLightsOfBackend(bridge, lights)
+	fake.change("1", func(state *hue.LightState) {
+		state.Reachable = false
+		state.Bri = 254
+	})
+	updateLightsOfBackend(bridge, lights)
+	if light.Reachable || light.Tracking || light.Automatic {
+		t.Errorf("unreachable light should not be tracked (Reachable: %v, Tracking: %v, Automatic: %v)", light.Reachable, light.Tracking, light.Automatic)
+	}
+
+	// The light appears again once it is reachable
+	fake.change("1", func(state *hue.LightState) { state.Reachable = true })
+	updateLightsOfBackend(bridge, lights)
+	if !light.Tracking || !light.Automatic {
+		t.Errorf("reachable light should be initialized again (Tracking: %v, Automatic: %v)", light.Tracking, light.Automatic)
+	}
+	if state := fake.state("1"); !hasTargetState(state, target) {
+		t.Errorf("light should have been set to %+v but has state %+v", target, state)
+	}
+}
+
+func TestSlowBridge(t *testing.T) {
+	if testing.Short() {
+		t.Skip("waits for the hue client timeout")
+	}
+	fake := newFakeBridge(t)
+	fake.addLight("1", "Desk", "Extended color light")
+	target := LightState{ColorTemperature: 2700, Brightness: 60}
+	bridge, light := newTestLight(t, fake, target, true)
+	lights := []*Light{light}
+
+	// Requests time out and the light is left untouched
+	fake.setDelay(3 * time.Second)
+	updateLightsOfBackend(bridge, lights)
+	if light.Tracking {
+		t.Errorf("light should not be tracked without a current state")
+	}
+
+	// The light is updated once the bridge responds again
+	fake.setDelay(0)
+	updateLightsOfBackend(bridge, lights)
+	if !light.Automatic {
+		t.Errorf("light should be automatic once the bridge responds")
+	}
+	if state := fake.state("1"); !hasTargetState(state, target) {
+		t.Errorf("light should have been set to %+v but has state %+v", target, state)
+	}
+}
+
+func TestUpdateScenes(t *testing.T) {
+	fake := newFakeBridge(t)
+	fake.addLight("1", "Desk", "Extended color light")
+	fake.addLight("2", "Shelf", "Extended color light")
+	fake.addScene("s1", "Kelvin default", "1")
+	fake.addScene("s2", "Reading", "1")
+	bridge := fake.connect(t)
+
+	previous := configuration
+	t.Cleanup(func() { configuration = previous })
+	configuration = &Configuration{}
+	configuration.initializeDefaults()
+	configuration.Schedules[0].AssociatedDeviceIDs = deviceIDs(1, 2)
+
+	updateScenesOfBackend(bridge)
+	waitForRequests(bridge.requests)
+
+	scene := fake.scene("s1")
+	if len(scene.Lights) != 2 || scene.Lights[0] != "1" || scene.Lights[1] != "2" {
+		t.Errorf("Kelvin scene should contain lights [1 2] but contains %v", scene.Lights)
+	}
+	for _, id := range []string{"1", "2"} {
+		state, found := scene.LightStates[id]
+		if !found || !state.On || state.Bri == 0 {
+			t.Errorf("Kelvin scene should turn on light %s with a brightness but has state %+v", id, state)
+		}
+	}
+	if other := fake.scene("s2"); len(other.Lights) != 1 || len(other.LightStates) != 0 {
+		t.Errorf("other scenes should not be modified but scene has lights %v and states %v", other.Lights, other.LightStates)
+	}
+}
+
+func TestTargetLightStatesAreStaggered(t *testing.T) {
+	previous := lights
+	t.Cleanup(func() { lights = previous })
+	lights = []*Light{{Name: "1"}, {Name: "2"}, {Name: "3"}, {Name: "4"}}
+
+	start := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	updateTargetLightStates(start)
+	for second := 1; second <= 60; second++ {
+		var before []time.Time
+		for _, light := range lights {
+			before = append(before, light.targetPeriod)
+		}
+		updateTargetLightStates(start.Add(time.Duration(second) * time.Second))
+
+		var updated []int
+		for index, light := range lights {
+			if !light.targetPeriod.Equal(before[index]) {
+				updated = append(updated, index)
+			}
+		}
+		var expected []int
+		if second%15 == 0 {
+			expected = []int{second / 15 % len(lights)}
+		}
+		if fmt.Sprint(updated) != fmt.Sprint(expected) {
+			t.Errorf("after %ds lights %v should be updated but updated %v", second, expected, updated)
+		}
+	}
+}
@@ -105,19 +105,11 @@ func (location *Geolocation) updateByIP() error {
 // CalculateSunset calculates the sunset for the given day based on
 // the configured position on earth.
 func CalculateSunset(date time.Time, latitude float64, longitude float64) time.Time {
-	// calculate start of day
-	yr, mth, day := date.Date()
-	startOfDay := time.Date(yr, mth, day, 0, 0, 0, 0, date.Location())
-
-	return astrotime.CalcDusk(startOfDay, latitude, longitude, astrotime.GOLDEN_HOUR)
+	return astrotime.CalcDusk(startOfDay(date), latitude, longitude, astrotime.GOLDEN_HOUR)
 }
 
 // CalculateSunrise calculates the sunrise for the given day based on
 // the configured position on earth.
 func CalculateSunrise(date time.Time, latitude float64, longitude float64) time.Time {
-	// calculate start of day
-	yr, mth, day := date.Date()
-	startOfDay := time.Date(yr, mth, day, 0, 0, 0, 0, date.Location())
-
-	return astrotime.CalcDawn(startOfDay, latitude, longitude, astrotime.GOLDEN_HOUR)
+	return astrotime.CalcDawn(startOfDay(date), latitude, longitude, astrotime.GOLDEN_HOUR)
 }
@@ -0,0 +1,309 @@
+// MIT License
+//
+// # Copyright (c) 2019 Stefan Wichmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	hue "github.com/stefanwichmann/go.hue"
+)
+
+// States of the bridge pairing.
+const (
+	pairingIdle        = "idle"
+	pairingDiscovering = "discovering"
+	pairingSelecting   = "selecting"
+	pairingWaiting     = "waiting"
+	pairingPaired      = "paired"
+	pairingTimedOut    = "timeout"
+	pairingCancelled   = "cancelled"
+	pairingFailed      = "failed"
+)
+
+const pairingTimeout = 1 * time.Minute
+const pairingPollInterval = 2 * time.Second
+
+// DiscoveredBridge is a bridge found in the local network.
+type DiscoveredBridge struct {
+	Address string `json:"address"`
+	ID      string `json:"id,omitempty"`
+	Version int    `json:"version"`
+	Method  string `json:"method"`
+}
+
+// PairingStatus describes the progress of the pairing for the web interface.
+type PairingStatus struct {
+	State     string             `json:"state"`
+	Bridge    string             `json:"bridge,omitempty"`
+	Bridges   []DiscoveredBridge `json:"bridges"`
+	Address   string             `json:"address,omitempty"`
+	Version   int                `json:"version,omitempty"`
+	Timeout   int                `json:"timeout"`
+	Remaining int                `json:"remaining"`
+	Error     string             `json:"error,omitempty"`
+}
+
+type pairingCommand struct {
+	action  string
+	address string
+}
+
+// Pairing registers Kelvin at a bridge. The web interface drives it by
+// choosing a discovered bridge or entering an address, starting the
+// pairing and cancelling it. Every attempt waits a limited time for the
+// link button to be pressed.
+type Pairing struct {
+	lock     sync.Mutex
+	status   PairingStatus
+	deadline time.Time
+	commands chan pairingCommand
+
+	timeout  time.Duration
+	interval time.Duration
+	// register requests a new username from the bridge at the given address.
+	// It returns an empty username as long as the link button wasn't pressed.
+	register func(address string) (string, error)
+}
+
+var pairing = newPairing()
+
+func newPairing() *Pairing {
+	return &Pairing{
+		status:   PairingStatus{State: pairingIdle, Bridges: []DiscoveredBridge{}},
+		commands: make(chan pairingCommand),
+		timeout:  pairingTimeout,
+		interval: pairingPollInterval,
+		register: registerAtBridge,
+	}
+}
+
+func registerAtBridge(address string) (string, error) {
+	bridge := hue.NewBridge(address, "")
+	err := bridge.CreateUser(hueBridgeAppName)
+	return bridge.Username, err
+}
+
+// Status returns the current state of the pairing.
+func (pairing *Pairing) Status() PairingStatus {
+	pairing.lock.Lock()
+	defer pairing.lock.Unlock()
+	status := pairing.status
+	status.Bridges = append([]DiscoveredBridge{}, status.Bridges...)
+	if status.State == pairingWaiting {
+		status.Remaining = int(time.Until(pairing.deadline).Round(time.Second) / time.Second)
+		if status.Remaining < 0 {
+			status.Remaining = 0
+		}
+	}
+	return status
+}
+
+// Start pairs with the bridge at the given address.
+func (pairing *Pairing) Start(address string) error {
+	if address == "" {
+		return errors.New("no bridge address given")
+	}
+	return pairing.send(pairingCommand{action: "start", address: address})
+}
+
+// Discover searches the local network for bridges again.
+func (pairing *Pairing) Discover() error {
+	return pairing.send(pairingCommand{action: "discover"})
+}
+
+// Cancel stops the current pairing attempt.
+func (pairing *Pairing) Cancel() error {
+	return pairing.send(pairingCommand{action: "cancel"})
+}
+
+func (pairing *Pairing) send(command pairingCommand) error {
+	select {
+	case pairing.commands <- command:
+		return nil
+	case <-time.After(time.Second):
+		switch pairing.Status().State {
+		case pairingIdle, pairingPaired:
+			return errors.New("no bridge is waiting to be paired")
+		}
+		return errors.New("pairing is busy, please try again")
+	}
+}
+
+func (pairing *Pairing) update(change func(status *PairingStatus)) {
+	pairing.lock.Lock()
+	defer pairing.lock.Unlock()
+	change(&pairing.status)
+}
+
+// run pairs the named bridge and returns its address and the new
+// username. If no address is given, the first discovered bridge is used.
+// Without a web interface (autoRestart) errors and timeouts are returned,
+// otherwise the pairing waits for the user to try again.
+func (pairing *Pairing) run(name string, address string, autoRestart bool) (string, string, error) {
+	pairing.update(func(status *PairingStatus) {
+		*status = PairingStatus{State: pairingIdle, Bridge: name, Bridges: []DiscoveredBridge{}, Timeout: int(pairing.timeout / time.Second)}
+	})
+
+	if address == "" {
+		bridges := pairing.discover()
+		if len(bridges) > 0 {
+			address = bridges[0].Address
+		} else if autoRestart {
+			return "", "", errors.New("Bridge discovery failed. Please configure manually in config.json")
+		}
+	}
+
+	for {
+		var command pairingCommand
+		if address != "" {
+			username, next, err := pairing.attempt(address)
+			if err == nil && username != "" {
+				return address, username, nil
+			}
+			if next != nil {
+				command = *next
+			} else if autoRestart {
+				return "", "", err
+			} else {
+				command = <-pairing.commands
+			}
+		} else {
+			command = <-pairing.commands
+		}
+
+		switch command.action {
+		case "start":
+			address = command.address
+		case "discover":
+			address = ""
+			pairing.discover()
+		case "cancel":
+			address = ""
+			log.Printf("⌘ Pairing with bridge %s cancelled", name)
+			pairing.update(func(status *PairingStatus) { status.State = pairingCancelled })
+		}
+	}
+}
+
+// discover searches the local network with all discovery methods and
+// offers the found bridges for selection.
+func (pairing *Pairing) discover() []DiscoveredBridge {
+	pairing.update(func(status *PairingStatus) {
+		status.State = pairingDiscovering
+		status.Error = ""
+	})
+
+	bridges := []DiscoveredBridge{}
+	for _, method := range discoveryMethods {
+		addresses, err := method.discover(method.timeout)
+		if err != nil {
+			log.Debugf("⌘ Bridge discovery via %s failed: %v", method.name, err)
+		}
+		for _, address := range addresses {
+			if containsBridge(bridges, address) {
+				continue
+			}
+			candidate := HueBridge{BridgeIP: address}
+			if candidate.validateBridge() != nil {
+				continue
+			}
+			id, _ := bridgeID(address)
+			log.Printf("⌘ Found bridge at %s via %s", address, method.name)
+			bridges = append(bridges, DiscoveredBridge{Address: address, ID: id, Version: candidate.Version, Method: method.name})
+		}
+	}
+
+	pairing.update(func(status *PairingStatus) {
+		status.State = pairingSelecting
+		status.Bridges = bridges
+		if len(bridges) == 0 {
+			status.Error = "No bridge found in the local network. Please enter the IP address of your bridge."
+		}
+	})
+	return bridges
+}
+
+func containsBridge(bridges []DiscoveredBridge, address string) bool {
+	for _, bridge := range bridges {
+		if bridge.Address == address {
+			return true
+		}
+	}
+	return false
+}
+
+// attempt waits for the link button of the bridge at the given address.
+// It returns the new username, or the command which interrupted it.
+func (pairing *Pairing) attempt(address string) (string, *pairingCommand, error) {
+	candidate := HueBridge{BridgeIP: address}
+	if err := candidate.validateBridge(); err != nil {
+		err = fmt.Errorf("no hue bridge found at %s: %v", address, err)
+		log.Warningf("⌘ %v", err)
+		pairing.update(func(status *PairingStatus) {
+			status.State = pairingFailed
+			status.Address = address
+			status.Error = err.Error()
+		})
+		return "", nil, err
+	}
+
+	log.Printf("⌘ Starting user registration at %s.", address)
+	log.Warningf("⌘ PLEASE PUSH THE BLUE BUTTON ON YOUR HUE BRIDGE")
+	pairing.lock.Lock()
+	pairing.deadline = time.Now().Add(pairing.timeout)
+	pairing.status.State = pairingWaiting
+	pairing.status.Address = address
+	pairing.status.Version = candidate.Version
+	pairing.status.Error = ""
+	pairing.lock.Unlock()
+
+	ticker := time.NewTicker(pairing.interval)
+	defer ticker.Stop()
+	timeout := time.After(pairing.timeout)
+	for {
+		select {
+		case command := <-pairing.commands:
+			return "", &command, nil
+		case <-timeout:
+			log.Printf("⌘ Link button wasn't pressed within %v", pairing.timeout)
+			pairing.update(func(status *PairingStatus) {
+				status.State = pairingTimedOut
+				status.Error = fmt.Sprintf("The link button wasn't pressed within %v.", pairing.timeout)
+			})
+			return "", nil, errors.New("link button wasn't pressed in time")
+		case <-ticker.C:
+			// try user creation, will fail if the button wasn't pressed.
+			username, err := pairing.register(address)
+			if err != nil || username == "" {
+				log.Debugf("⌘ Button wasn't pressed yet. Waiting...")
+				continue
+			}
+			log.Printf("⌘ User registration successful.")
+			pairing.update(func(status *PairingStatus) { status.State = pairingPaired })
+			return username, nil, nil
+		}
+	}
+}
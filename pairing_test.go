@@ -0,0 +1,174 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type pairingResult struct {
+	address  string
+	username string
+	err      error
+}
+
+// newTestPairing returns a pairing with short timeouts. The link button of
+// the bridge is pressed once pressButton is called.
+func newTestPairing(t *testing.T, addresses ...string) (*Pairing, func()) {
+	stubBridgeDiscovery(t, addresses...)
+	var lock sync.Mutex
+	pressed := false
+	pairing := newPairing()
+	pairing.timeout = 300 * time.Millisecond
+	pairing.interval = 10 * time.Millisecond
+	pairing.register = func(address string) (string, error) {
+		lock.Lock()
+		defer lock.Unlock()
+		if pressed {
+			return "new-username", nil
+		}
+		return "", nil
+	}
+	return pairing, func() {
+		lock.Lock()
+		defer lock.Unlock()
+		pressed = true
+	}
+}
+
+func runPairing(pairing *Pairing, address string, autoRestart bool) chan pairingResult {
+	results := make(chan pairingResult, 1)
+	go func() {
+		address, username, err := pairing.run("main bridge", address, autoRestart)
+		results <- pairingResult{address, username, err}
+	}()
+	return results
+}
+
+func waitForPairingState(t *testing.T, pairing *Pairing, state string) PairingStatus {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		status := pairing.Status()
+		if status.State == state {
+			return status
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("pairing should reach state %s but is %+v", state, pairing.Status())
+	return PairingStatus{}
+}
+
+func waitForPairingResult(t *testing.T, results chan pairingResult) pairingResult {
+	t.Helper()
+	select {
+	case result := <-results:
+		return result
+	case <-time.After(5 * time.Second):
+		t.Fatal("pairing did not finish")
+		return pairingResult{}
+	}
+}
+
+func TestPairingWithDiscoveredBridge(t *testing.T) {
+	fake := newFakeBridge(t)
+	pairing, pressButton := newTestPairing(t, "127.0.0.1:1", fake.address())
+	pairing.timeout = 2 * time.Second
+	results := runPairing(pairing, "", false)
+
+	status := waitForPairingState(t, pairing, pairingWaiting)
+	if len(status.Bridges) != 1 || status.Bridges[0].Address != fake.address() || status.Bridges[0].ID != fake.id || status.Bridges[0].Version != 1 {
+		t.Errorf("only the fake bridge should be discovered: %+v", status.Bridges)
+	}
+	if status.Address != fake.address() || status.Remaining <= 0 || status.Remaining > status.Timeout {
+		t.Errorf("pairing should count down for the discovered bridge: %+v", status)
+	}
+
+	pressButton()
+	result := waitForPairingResult(t, results)
+	if result.err != nil || result.address != fake.address() || result.username != "new-username" {
+		t.Errorf("pairing should return the new username for the fake bridge: %+v", result)
+	}
+	if state := pairing.Status().State; state != pairingPaired {
+		t.Errorf("pairing should be in state %s but is %s", pairingPaired, state)
+	}
+}
+
+func TestPairingTimeoutAndRetry(t *testing.T) {
+	fake := newFakeBridge(t)
+	pairing, pressButton := newTestPairing(t, fake.address())
+	results := runPairing(pairing, "", false)
+
+	status := waitForPairingState(t, pairing, pairingTimedOut)
+	if status.Error == "" {
+		t.Errorf("timeout should be explained")
+	}
+
+	if err := pairing.Start(fake.address()); err != nil {
+		t.Fatal(err)
+	}
+	waitForPairingState(t, pairing, pairingWaiting)
+	pressButton()
+	if result := waitForPairingResult(t, results); result.username != "new-username" {
+		t.Errorf("retried pairing should succeed: %+v", result)
+	}
+}
+
+func TestPairingWithManualAddressAndCancel(t *testing.T) {
+	fake := newFakeBridge(t)
+	pairing, pressButton := newTestPairing(t)
+	results := runPairing(pairing, "", false)
+
+	status := waitForPairingState(t, pairing, pairingSelecting)
+	if len(status.Bridges) != 0 || status.Error == "" {
+		t.Errorf("user should be asked for the address if no bridge was found: %+v", status)
+	}
+
+	if err := pairing.Start("127.0.0.1:1"); err != nil {
+		t.Fatal(err)
+	}
+	waitForPairingState(t, pairing, pairingFailed)
+
+	if err := pairing.Start(fake.address()); err != nil {
+		t.Fatal(err)
+	}
+	waitForPairingState(t, pairing, pairingWaiting)
+	if err := pairing.Cancel(); err != nil {
+		t.Fatal(err)
+	}
+	waitForPairingState(t, pairing, pairingCancelled)
+
+	pressButton()
+	if err := pairing.Start(fake.address()); err != nil {
+		t.Fatal(err)
+	}
+	if result := waitForPairingResult(t, results); result.address != fake.address() || result.username != "new-username" {
+		t.Errorf("pairing with the entered address should succeed: %+v", result)
+	}
+}
+
+func TestPairingWithoutWebInterface(t *testing.T) {
+	fake := newFakeBridge(t)
+	pairing, _ := newTestPairing(t, fake.address())
+	results := runPairing(pairing, "", true)
+	if result := waitForPairingResult(t, results); result.err == nil {
+		t.Errorf("pairing without web interface should return after the timeout: %+v", result)
+	}
+
+	pairing, _ = newTestPairing(t)
+	results = runPairing(pairing, "", true)
+	if result := waitForPairingResult(t, results); result.err == nil {
+		t.Errorf("pairing without web interface should fail if no bridge was found: %+v", result)
+	}
+}
+
+func TestPairingCommandWithoutPairing(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	pairingStartHandler(recorder, httptest.NewRequest("POST", "/pairing/start", strings.NewReader(`{"address": "192.168.0.2"}`)))
+	if recorder.Code != http.StatusConflict {
+		t.Errorf("starting a pairing without unpaired bridge should fail with %d but got %d", http.StatusConflict, recorder.Code)
+	}
+}
@@ -28,32 +28,38 @@ import "strings"
 
 func updateScenes() {
 	log.Debugf("🎨 Updating scenes...")
-	scenes, _ := bridge.bridge.AllScenes()
+	for _, bridge := range bridges {
+		if !bridge.ready.Load() {
+			continue
+		}
+		updateScenesOfBackend(bridge)
+	}
+}
+
+// updateScenesOfBackend updates all Kelvin scenes stored on the given backend.
+func updateScenesOfBackend(backend LightBackend) {
+	scenes, _ := backend.Scenes()
 	for _, scene := range scenes {
 		if strings.Contains(strings.ToLower(scene.Name), "kelvin") {
 			for _, schedule := range configuration.Schedules {
+				// Scenes can only contain lights of their own bridge
+				lights := configuration.lightsOnBridge(schedule.AssociatedDeviceIDs, backend.qualifier())
+				if len(lights) == 0 {
+					continue
+				}
 				if strings.Contains(strings.ToLower(scene.Name), strings.ToLower(schedule.Name)) {
-					log.Debugf("🎨 Updating scene \"%s\" for schedule \"%s\"...", scene.Name, schedule.Name)
-					updateSceneForSchedule(scene, schedule)
+					log.Debugf("🎨 Updating scene \"%s\" on bridge %s for schedule \"%s\"...", scene.Name, backend, schedule.Name)
+					updateSceneForSchedule(scene, backend, lights)
 				}
 			}
 		}
 	}
 }
 
-func updateSceneForSchedule(scene *hue.Scene, lightSchedule LightSchedule) {
-	// Updating lights
-	var modifyScene hue.ModifyScene
-	modifyScene.Lights = toStringArray(lightSchedule.AssociatedDeviceIDs)
-
-	_, err := scene.Modify(modifyScene)
-	if err != nil {
-		log.Warningf("🎨 %v", err)
-		return
-	}
-
-	// Updating light states
-	light := lightSchedule.AssociatedDeviceIDs[0]
+// updateSceneForSchedule queues the update of the scene behind all light
+// updates. Scenes are only used when activated and can wait.
+func updateSceneForSchedule(scene *hue.Scene, backend LightBackend, lights []int) {
+	light := newDeviceID(backend.qualifier(), lights[0])
 	schedule, err := configuration.lightScheduleForDay(light, time.Now())
 	if err != nil {
 		log.Warningf("🎨 %v", err)
@@ -68,6 +74,9 @@ func updateSceneForSchedule(scene *hue.Scene, lightSchedule LightSchedule) {
 
 	state := interval.calculateLightStateInInterval(time.Now())
 
+	var modifyScene hue.ModifyScene
+	modifyScene.Lights = toStringArray(lights)
+
 	var modifyState hue.ModifyLightState
 	modifyState.On = true // turn lights on when the scene is activated
 
@@ -79,10 +88,28 @@ func updateSceneForSchedule(scene *hue.Scene, lightSchedule LightSchedule) {
 		modifyState.Brightness = uint8(mapBrightness(state.Brightness))
 	}
 
-	_, err = scene.ModifyLightStates(modifyState)
-	if err != nil {
-		log.Warningf("🎨 %v", err)
-	}
+	// Updating lights
+	scheduler := backend.scheduler()
+	scheduler.submit(priorityHousekeeping, "scene "+scene.Id, func() error {
+		_, err := scene.Modify(modifyScene)
+		if err != nil {
+			log.Warningf("🎨 %v", err)
+			return err
+		}
 
-	log.Debugf("🎨 Successfully updated scene \"%s\"", scene.Name)
+		// Updating light states of all lights, one request each
+		for _, light := range modifyScene.Lights {
+			light := light
+			scheduler.submit(priorityHousekeeping, "scene "+scene.Id+" light "+light, func() error {
+				_, err := scene.ModifyLightState(light, modifyState)
+				if err != nil {
+					log.Warningf("🎨 %v", err)
+					return err
+				}
+				log.Debugf("🎨 Successfully updated light %s of scene \"%s\"", light, scene.Name)
+				return nil
+			})
+		}
+		return nil
+	})
 }
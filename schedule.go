@@ -41,6 +41,9 @@ type Schedule struct {
 }
 
 func (schedule *Schedule) currentInterval(timestamp time.Time) (Interval, error) {
+	// evaluate timestamp in the time zone the schedule was calculated for
+	timestamp = timestamp.In(schedule.endOfDay.Location())
+
 	// check if timestamp respresents the current day
 	if timestamp.After(schedule.endOfDay) {
 		return Interval{TimeStamp{time.Now(), 0, 0}, TimeStamp{time.Now(), 0, 0}}, fmt.Errorf("no current interval as the requested timestamp (%v) lays after the end of the current schedule (%v)", timestamp, schedule.endOfDay)
@@ -54,9 +57,8 @@ func (schedule *Schedule) currentInterval(timestamp time.Time) (Interval, error)
 	var before, after TimeStamp
 	// Before sunrise
 	if timestamp.Before(schedule.sunrise.Time) {
-		yr, mth, dy := timestamp.Date()
-		startOfDay := TimeStamp{time.Date(yr, mth, dy, 0, 0, 0, 0, timestamp.Location()), -1, -1}
-		candidates := append(schedule.beforeSunrise, startOfDay, schedule.sunrise)
+		start := TimeStamp{startOfDay(timestamp), -1, -1}
+		candidates := append(schedule.beforeSunrise, start, schedule.sunrise)
 
 		before, after = findTargetTimes(timestamp, candidates)
 
@@ -71,9 +73,8 @@ func (schedule *Schedule) currentInterval(timestamp time.Time) (Interval, error)
 
 	// After sunset
 	if timestamp.After(schedule.sunset.Time) {
-		yr, mth, dy := timestamp.Date()
-		endOfDay := TimeStamp{time.Date(yr, mth, dy, 23, 59, 59, 0, timestamp.Location()), -1, -1}
-		candidates := append(schedule.afterSunset, endOfDay, schedule.sunset)
+		end := TimeStamp{endOfDay(timestamp), -1, -1}
+		candidates := append(schedule.afterSunset, end, schedule.sunset)
 
 		before, after = findTargetTimes(timestamp, candidates)
 	}
@@ -87,6 +88,18 @@ func (schedule *Schedule) currentInterval(timestamp time.Time) (Interval, error)
 	return Interval{before, after}, nil
 }
 
+// shift moves all timestamps of the schedule by the given duration.
+func (schedule *Schedule) shift(offset time.Duration) {
+	for index := range schedule.beforeSunrise {
+		schedule.beforeSunrise[index].Time = schedule.beforeSunrise[index].Time.Add(offset)
+	}
+	schedule.sunrise.Time = schedule.sunrise.Time.Add(offset)
+	schedule.sunset.Time = schedule.sunset.Time.Add(offset)
+	for index := range schedule.afterSunset {
+		schedule.afterSunset[index].Time = schedule.afterSunset[index].Time.Add(offset)
+	}
+}
+
 func findTargetTimes(timestamp time.Time, candidates []TimeStamp) (TimeStamp, TimeStamp) {
 	beforeCandidate := TimeStamp{timestamp.AddDate(0, 0, -2), 0, 0}
 	afterCandidate := TimeStamp{timestamp.AddDate(0, 0, 2), 0, 0}
@@ -0,0 +1,298 @@
+// MIT License
+//
+// # Copyright (c) 2019 Stefan Wichmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package main
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// requestPriority orders the requests to a bridge. Requests with a lower
+// value are sent first.
+type requestPriority int
+
+const (
+	// priorityAppearance initializes lights which just appeared or were
+	// activated by the user.
+	priorityAppearance requestPriority = iota
+	// priorityUpdate polls the light states and sends new target states.
+	priorityUpdate
+	// priorityHousekeeping updates scenes and reads groups.
+	priorityHousekeeping
+)
+
+var requestPriorityNames = [...]string{"appearance", "update", "housekeeping"}
+
+func (priority requestPriority) String() string {
+	return requestPriorityNames[priority]
+}
+
+// maximumBackoff limits the additional delay between requests while the
+// bridge returns errors.
+const maximumBackoff = 10 * time.Second
+
+// minimumBackoff is the first step of the backoff if the interval is
+// shorter, e.g. without rate limiting.
+const minimumBackoff = 100 * time.Millisecond
+
+type scheduledRequest struct {
+	key      string
+	execute  func() error
+	enqueued time.Time
+	done     chan error
+}
+
+// SchedulerMetrics describes the queue of requests to a bridge.
+type SchedulerMetrics struct {
+	Queued      map[string]int    `json:"queued"`
+	Executed    map[string]int    `json:"executed"`
+	LongestWait map[string]string `json:"longestWait"`
+	Replaced    int               `json:"replaced"`
+	Errors      int               `json:"errors"`
+	Backoff     string            `json:"backoff"`
+}
+
+// RequestScheduler sends the requests of Kelvin to a bridge one after
+// another. Requests of a higher priority overtake queued ones, so a light
+// which just appeared is not delayed by a slow scene update. Housekeeping
+// requests are spaced twice as far to leave room for the others and the
+// scheduler backs off while the bridge returns errors.
+type RequestScheduler struct {
+	name     string
+	lock     sync.Mutex
+	interval time.Duration
+	queues   [len(requestPriorityNames)][]*scheduledRequest
+	wakeup   chan struct{}
+	last     time.Time
+	backoff  time.Duration
+
+	executed    [len(requestPriorityNames)]int
+	longestWait [len(requestPriorityNames)]time.Duration
+	replaced    int
+	errors      int
+}
+
+func newRequestScheduler(name string, interval time.Duration) *RequestScheduler {
+	scheduler := &RequestScheduler{name: name, interval: interval, wakeup: make(chan struct{}, 1)}
+	go scheduler.run()
+	return scheduler
+}
+
+// setInterval changes the minimal time between two requests.
+func (scheduler *RequestScheduler) setInterval(interval time.Duration) {
+	scheduler.lock.Lock()
+	defer scheduler.lock.Unlock()
+	scheduler.interval = interval
+}
+
+// do sends the request with the given priority and waits for its result.
+func (scheduler *RequestScheduler) do(priority requestPriority, request func() error) error {
+	if scheduler == nil {
+		return request()
+	}
+	done := make(chan error, 1)
+	scheduler.enqueue(priority, &scheduledRequest{execute: request, enqueued: time.Now(), done: done})
+	return <-done
+}
+
+// submit queues the request with the given priority without waiting for
+// it. A queued request with the same key is replaced as only the latest
+// state matters. Errors have to be handled by the request.
+func (scheduler *RequestScheduler) submit(priority requestPriority, key string, request func() error) {
+	if scheduler == nil {
+		request()
+		return
+	}
+
+	scheduler.lock.Lock()
+	for _, queued := range scheduler.queues[priority] {
+		if queued.done == nil && queued.key == key {
+			log.Debugf("⌘ Replacing queued %s request %s to bridge %s", priority, key, scheduler.name)
+			queued.execute = request
+			scheduler.replaced++
+			scheduler.lock.Unlock()
+			return
+		}
+	}
+	scheduler.lock.Unlock()
+	scheduler.enqueue(priority, &scheduledRequest{key: key, execute: request, enqueued: time.Now()})
+}
+
+func (scheduler *RequestScheduler) enqueue(priority requestPriority, request *scheduledRequest) {
+	scheduler.lock.Lock()
+	scheduler.queues[priority] = append(scheduler.queues[priority], request)
+	scheduler.lock.Unlock()
+
+	select {
+	case scheduler.wakeup <- struct{}{}:
+	default:
+	}
+}
+
+// run sends the queued requests. It waits for the delay of the most urgent
+// request and picks again if a new request arrives in the meantime.
+func (scheduler *RequestScheduler) run() {
+	for {
+		scheduler.lock.Lock()
+		priority, found := scheduler.next()
+		if !found {
+			scheduler.lock.Unlock()
+			<-scheduler.wakeup
+			continue
+		}
+		if wait := time.Until(scheduler.last.Add(scheduler.delay(priority))); wait > 0 {
+			scheduler.lock.Unlock()
+			select {
+			case <-time.After(wait):
+			case <-scheduler.wakeup:
+			}
+			continue
+		}
+		request := scheduler.queues[priority][0]
+		scheduler.queues[priority] = scheduler.queues[priority][1:]
+		if wait := time.Since(request.enqueued); wait > scheduler.longestWait[priority] {
+			scheduler.longestWait[priority] = wait
+		}
+		scheduler.lock.Unlock()
+
+		err := request.execute()
+
+		scheduler.lock.Lock()
+		scheduler.last = time.Now()
+		scheduler.executed[priority]++
+		scheduler.adjustBackoff(err)
+		scheduler.lock.Unlock()
+		if request.done != nil {
+			request.done <- err
+		}
+	}
+}
+
+// next returns the priority of the most urgent queued request.
+func (scheduler *RequestScheduler) next() (requestPriority, bool) {
+	for priority := range scheduler.queues {
+		if len(scheduler.queues[priority]) > 0 {
+			return requestPriority(priority), true
+		}
+	}
+	return 0, false
+}
+
+// delay returns the time to wait after the last request before sending a
+// request with the given priority.
+func (scheduler *RequestScheduler) delay(priority requestPriority) time.Duration {
+	delay := scheduler.interval + scheduler.backoff
+	if priority == priorityHousekeeping {
+		delay += scheduler.interval
+	}
+	return delay
+}
+
+// adjustBackoff doubles the additional delay after a request failed
+// because the bridge is overloaded and halves it after a successful one.
+// Other errors don't change the delay.
+func (scheduler *RequestScheduler) adjustBackoff(err error) {
+	step := max(scheduler.interval, minimumBackoff)
+	if err == nil {
+		scheduler.backoff /= 2
+		if scheduler.backoff < step {
+			scheduler.backoff = 0
+		}
+		return
+	}
+
+	scheduler.errors++
+	if !overloaded(err) {
+		return
+	}
+	backoff := max(2*scheduler.backoff, step)
+	if backoff > maximumBackoff {
+		backoff = maximumBackoff
+	}
+	if backoff != scheduler.backoff {
+		log.Debugf("⌘ Request to bridge %s failed (%v). Waiting %v longer between requests", scheduler.name, err, backoff)
+	}
+	scheduler.backoff = backoff
+}
+
+// overloadError is returned for responses asking Kelvin to send fewer
+// requests.
+type overloadError struct {
+	status string
+}
+
+func (err overloadError) Error() string {
+	return "bridge responded with status " + err.status
+}
+
+// overloaded reports whether the error shows an overloaded bridge: a
+// response with status 429 or 503, or a request which timed out.
+func overloaded(err error) bool {
+	if errors.As(err, &overloadError{}) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// overloadTransport turns responses of an overloaded bridge into errors.
+// go.hue doesn't check the status of a response, so the scheduler would
+// only see a decoding error otherwise.
+type overloadTransport struct {
+	next http.RoundTripper
+}
+
+func (transport overloadTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	resp, err := transport.next.RoundTrip(request)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable {
+		resp.Body.Close()
+		return nil, overloadError{status: resp.Status}
+	}
+	return resp, nil
+}
+
+// Metrics returns the current state of the queue.
+func (scheduler *RequestScheduler) Metrics() SchedulerMetrics {
+	metrics := SchedulerMetrics{Queued: make(map[string]int), Executed: make(map[string]int), LongestWait: make(map[string]string)}
+	if scheduler == nil {
+		return metrics
+	}
+	scheduler.lock.Lock()
+	defer scheduler.lock.Unlock()
+	for priority, name := range requestPriorityNames {
+		metrics.Queued[name] = len(scheduler.queues[priority])
+		metrics.Executed[name] = scheduler.executed[priority]
+		metrics.LongestWait[name] = scheduler.longestWait[priority].Round(time.Millisecond).String()
+	}
+	metrics.Replaced = scheduler.replaced
+	metrics.Errors = scheduler.errors
+	metrics.Backoff = scheduler.backoff.String()
+	return metrics
+}
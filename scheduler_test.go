@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// waitForRequests returns once all queued requests were sent, including
+// requests queued by other requests.
+func waitForRequests(scheduler *RequestScheduler) {
+	for {
+		scheduler.do(priorityHousekeeping, func() error { return nil })
+		queued := 0
+		for _, count := range scheduler.Metrics().Queued {
+			queued += count
+		}
+		if queued == 0 {
+			return
+		}
+	}
+}
+
+// blockScheduler sends a request which blocks the scheduler until the
+// returned function is called.
+func blockScheduler(scheduler *RequestScheduler) func() {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	scheduler.submit(priorityAppearance, "block", func() error {
+		close(started)
+		<-release
+		return nil
+	})
+	<-started
+	return func() { close(release) }
+}
+
+func TestSchedulerPriorities(t *testing.T) {
+	scheduler := newRequestScheduler("test", 0)
+	var lock sync.Mutex
+	var order []string
+	record := func(name string) func() error {
+		return func() error {
+			lock.Lock()
+			defer lock.Unlock()
+			order = append(order, name)
+			return nil
+		}
+	}
+
+	release := blockScheduler(scheduler)
+	scheduler.submit(priorityHousekeeping, "scene 1", record("scene 1"))
+	scheduler.submit(priorityHousekeeping, "scene 2", record("scene 2"))
+	scheduler.submit(priorityUpdate, "light 1", record("update"))
+	scheduler.submit(priorityAppearance, "light 2", record("appearance"))
+
+	var wait sync.WaitGroup
+	wait.Add(1)
+	go func() {
+		defer wait.Done()
+		scheduler.do(priorityUpdate, record("poll"))
+	}()
+	for scheduler.Metrics().Queued["update"] != 2 {
+		time.Sleep(time.Millisecond)
+	}
+	release()
+	wait.Wait()
+	waitForRequests(scheduler)
+
+	expected := []string{"appearance", "update", "poll", "scene 1", "scene 2"}
+	if !reflect.DeepEqual(order, expected) {
+		t.Errorf("requests should be sent in order %v but were sent in order %v", expected, order)
+	}
+	metrics := scheduler.Metrics()
+	if metrics.Executed["appearance"] != 2 || metrics.Executed["update"] != 2 {
+		t.Errorf("metrics should count the executed requests by priority: %+v", metrics)
+	}
+}
+
+func TestSchedulerReplacesQueuedRequests(t *testing.T) {
+	scheduler := newRequestScheduler("test", 0)
+	var sent []string
+	release := blockScheduler(scheduler)
+	scheduler.submit(priorityHousekeeping, "scene 1", func() error { sent = append(sent, "old"); return nil })
+	scheduler.submit(priorityHousekeeping, "scene 1", func() error { sent = append(sent, "new"); return nil })
+	release()
+	waitForRequests(scheduler)
+
+	if !reflect.DeepEqual(sent, []string{"new"}) {
+		t.Errorf("only the latest request should be sent but sent %v", sent)
+	}
+	if metrics := scheduler.Metrics(); metrics.Replaced != 1 {
+		t.Errorf("one replaced request should be counted but counted %d", metrics.Replaced)
+	}
+}
+
+func TestSchedulerDelay(t *testing.T) {
+	scheduler := &RequestScheduler{interval: 100 * time.Millisecond}
+	for _, priority := range []requestPriority{priorityAppearance, priorityUpdate} {
+		if delay := scheduler.delay(priority); delay != 100*time.Millisecond {
+			t.Errorf("%s requests should be delayed by the interval but are delayed by %v", priority, delay)
+		}
+	}
+	if delay := scheduler.delay(priorityHousekeeping); delay != 200*time.Millisecond {
+		t.Errorf("housekeeping requests should be spread by twice the interval but are delayed by %v", delay)
+	}
+}
+
+func TestSchedulerBackoff(t *testing.T) {
+	interval := 100 * time.Millisecond
+	scheduler := &RequestScheduler{interval: interval}
+	unavailable := overloadError{status: "503 Service Unavailable"}
+
+	expected := []time.Duration{interval, 2 * interval, 4 * interval}
+	for _, backoff := range expected {
+		scheduler.adjustBackoff(unavailable)
+		if scheduler.backoff != backoff {
+			t.Errorf("backoff should be %v after an error but is %v", backoff, scheduler.backoff)
+		}
+	}
+	for i := 0; i < 20; i++ {
+		scheduler.adjustBackoff(unavailable)
+	}
+	if scheduler.backoff != maximumBackoff {
+		t.Errorf("backoff should be limited to %v but is %v", maximumBackoff, scheduler.backoff)
+	}
+	if delay := scheduler.delay(priorityAppearance); delay != interval+maximumBackoff {
+		t.Errorf("backoff should delay all requests but delay is %v", delay)
+	}
+
+	// The backoff is reduced again once the bridge responds
+	for i := 0; i < 10; i++ {
+		scheduler.adjustBackoff(nil)
+	}
+	if scheduler.backoff != 0 {
+		t.Errorf("backoff should be reset after successful requests but is %v", scheduler.backoff)
+	}
+	if scheduler.errors != 23 {
+		t.Errorf("23 errors should be counted but counted %d", scheduler.errors)
+	}
+}
+
+func TestSchedulerBackoffWithoutRateLimiting(t *testing.T) {
+	scheduler := &RequestScheduler{}
+	unavailable := overloadError{status: "503 Service Unavailable"}
+
+	scheduler.adjustBackoff(unavailable)
+	if scheduler.backoff != minimumBackoff {
+		t.Errorf("backoff should start with %v without an interval but is %v", minimumBackoff, scheduler.backoff)
+	}
+	scheduler.adjustBackoff(unavailable)
+	if scheduler.backoff != 2*minimumBackoff {
+		t.Errorf("backoff should be doubled after another error but is %v", scheduler.backoff)
+	}
+	scheduler.adjustBackoff(nil)
+	scheduler.adjustBackoff(nil)
+	if scheduler.backoff != 0 {
+		t.Errorf("backoff should be reset after successful requests but is %v", scheduler.backoff)
+	}
+}
+
+func TestSchedulerBackoffOnlyWhenOverloaded(t *testing.T) {
+	scheduler := &RequestScheduler{}
+	scheduler.adjustBackoff(errors.New("invalid character '<' looking for beginning of value"))
+	if scheduler.backoff != 0 {
+		t.Errorf("other errors should not delay requests but backoff is %v", scheduler.backoff)
+	}
+	if scheduler.errors != 1 {
+		t.Errorf("other errors should be counted")
+	}
+	scheduler.adjustBackoff(fmt.Errorf("request failed: %w", context.DeadlineExceeded))
+	if scheduler.backoff != minimumBackoff {
+		t.Errorf("timeout should delay requests but backoff is %v", scheduler.backoff)
+	}
+}
+
+func TestOverloadTransport(t *testing.T) {
+	status := http.StatusOK
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if status == 0 {
+			<-release
+		}
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+	defer close(release)
+	client := http.Client{Transport: overloadTransport{next: http.DefaultTransport}, Timeout: 100 * time.Millisecond}
+
+	for _, test := range []struct {
+		status     int
+		overloaded bool
+	}{
+		{http.StatusOK, false},
+		{http.StatusNotFound, false},
+		{http.StatusTooManyRequests, true},
+		{http.StatusServiceUnavailable, true},
+		{0, true}, // no response within the timeout
+	} {
+		status = test.status
+		resp, err := client.Get(server.URL)
+		if err == nil {
+			resp.Body.Close()
+		}
+		if overloaded(err) != test.overloaded {
+			t.Errorf("status %d should be overloaded: %v (Error: %v)", test.status, test.overloaded, err)
+		}
+	}
+}
+
+func TestSchedulerWithoutBridge(t *testing.T) {
+	var scheduler *RequestScheduler
+	sent := false
+	if err := scheduler.do(priorityUpdate, func() error { sent = true; return nil }); err != nil || !sent {
+		t.Errorf("requests without scheduler should be sent directly")
+	}
+}
@@ -0,0 +1,150 @@
+// MIT License
+//
+// # Copyright (c) 2019 Stefan Wichmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// bridgeUsernameCredential is the name of the systemd credential
+// (LoadCredential=bridge-username:...) containing the bridge username.
+const bridgeUsernameCredential = "bridge-username"
+
+// defaultBridgeUsernameFile is used for new configurations.
+const defaultBridgeUsernameFile = "bridge-username.secret"
+
+const redactedSecret = "[redacted]"
+
+// redact hides a secret in logs and exports.
+func redact(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	return redactedSecret
+}
+
+// String hides the username when the bridge configuration is printed.
+func (bridge Bridge) String() string {
+	return fmt.Sprintf("{IP:%s Username:%s UsernameFile:%s}", bridge.IP, redact(bridge.Username), bridge.UsernameFile)
+}
+
+// resolvePath returns the path of a file referenced by the configuration.
+// Relative paths are resolved against the directory of the configuration
+// file.
+func (configuration *Configuration) resolvePath(filename string) string {
+	if filepath.IsAbs(filename) {
+		return filename
+	}
+	return filepath.Join(filepath.Dir(configuration.ConfigurationFile), filename)
+}
+
+// loadSecrets reads the bridge usernames from the configured secrets files.
+func (configuration *Configuration) loadSecrets() error {
+	for index, bridge := range configuration.configuredBridges() {
+		path := "bridge.username"
+		if index > 0 {
+			path = fmt.Sprintf("additionalBridges[%d].username", index-1)
+		}
+		err := configuration.loadSecret(bridge, path)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (configuration *Configuration) loadSecret(bridge *Bridge, path string) error {
+	if bridge.UsernameFile == "" {
+		return nil
+	}
+	filename := configuration.resolvePath(bridge.UsernameFile)
+	info, err := os.Stat(filename)
+	if os.IsNotExist(err) {
+		return nil // will be created on registration
+	}
+	if err != nil {
+		return err
+	}
+	if info.Mode().Perm()&0077 != 0 {
+		log.Warningf("⚙ Secrets file %s is accessible by other users. Please restrict its permissions to 0600.", filename)
+	}
+
+	raw, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	bridge.Username = strings.TrimSpace(string(raw))
+	configuration.setSource(path, sourceSecretsFile)
+	return nil
+}
+
+// saveSecrets writes the bridge usernames to the configured secrets files
+// and removes them from the given configuration, which will be written to
+// the main configuration file.
+func (configuration *Configuration) saveSecrets(persisted *Configuration) error {
+	for _, bridge := range persisted.configuredBridges() {
+		err := configuration.saveSecret(bridge)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (configuration *Configuration) saveSecret(bridge *Bridge) error {
+	if bridge.UsernameFile == "" {
+		return nil
+	}
+	filename := configuration.resolvePath(bridge.UsernameFile)
+	username := []byte(bridge.Username + "\n")
+	empty := bridge.Username == ""
+	bridge.Username = ""
+	if empty {
+		return nil
+	}
+
+	current, err := os.ReadFile(filename)
+	if err == nil && bytes.Equal(current, username) {
+		return nil
+	}
+	log.Debugf("⚙ Saving bridge username to secrets file %s", filename)
+	return writeFileAtomic(filename, username, 0600)
+}
+
+// credentialOverrides returns the overrides provided as systemd
+// credentials in the given directory.
+func credentialOverrides(directory string) []configurationOverride {
+	if directory == "" {
+		return nil
+	}
+	raw, err := os.ReadFile(filepath.Join(directory, bridgeUsernameCredential))
+	if err != nil {
+		return nil
+	}
+	return []configurationOverride{{Path: "bridge.username", Value: strings.TrimSpace(string(raw)), Source: sourceCredential}}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBridgeUsernameInSecretsFile(t *testing.T) {
+	file := copyTestFile(t, "testdata/config-example.json")
+	username := "lbCDGagZZ7JEYQX5iGxrjMIx2jIROgpXfsSjHmCv"
+
+	// Enable the secrets file for an existing configuration
+	c := Configuration{ConfigurationFile: file}
+	err := c.parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Bridge.UsernameFile = "bridge.secret"
+	err = c.Write()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	raw, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(raw), username) {
+		t.Errorf("configuration file should not contain the username")
+	}
+	secret := filepath.Join(filepath.Dir(file), "bridge.secret")
+	info, err := os.Stat(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("secrets file should have permissions 0600 but has %v", info.Mode().Perm())
+	}
+
+	read := Configuration{ConfigurationFile: file}
+	err = read.parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if read.Bridge.Username != username || read.Source("bridge.username") != sourceSecretsFile {
+		t.Errorf("username should be read from the secrets file (username: %q, source: %s)", read.Bridge.Username, read.Source("bridge.username"))
+	}
+}
+
+func TestPlaintextUsernameIsMoved(t *testing.T) {
+	file := copyTestFile(t, "testdata/config-example.json")
+	raw, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw = []byte(strings.Replace(string(raw), `"ip":`, `"usernameFile": "bridge.secret", "ip":`, 1))
+	err = os.WriteFile(file, raw, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := Configuration{ConfigurationFile: file}
+	err = c.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err = os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(raw), c.Bridge.Username) {
+		t.Errorf("username should be moved to the secrets file on startup")
+	}
+}
+
+func TestCredentialOverride(t *testing.T) {
+	directory := t.TempDir()
+	err := os.WriteFile(filepath.Join(directory, bridgeUsernameCredential), []byte("secretUser\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	overrides := credentialOverrides(directory)
+	if len(overrides) != 1 || overrides[0].Value != "secretUser" || overrides[0].Path != "bridge.username" {
+		t.Errorf("unexpected credential overrides: %+v", overrides)
+	}
+	if len(credentialOverrides("")) != 0 {
+		t.Errorf("no credentials should be read without a directory")
+	}
+}
+
+func TestRedactBridgeUsername(t *testing.T) {
+	c := Configuration{Bridge: Bridge{IP: "192.168.1.2", Username: "secretUser"}}
+	for _, output := range []string{fmt.Sprintf("%+v", c), fmt.Sprintf("%v", c.Bridge), fmt.Sprint(&c)} {
+		if strings.Contains(output, "secretUser") {
+			t.Errorf("output should not contain the username: %s", output)
+		}
+	}
+}
@@ -0,0 +1,5 @@
+module github.com/stefanwichmann/go.hue
+
+go 1.17
+
+require github.com/stefanwichmann/lanscan v0.0.0-20190324154315-2a77f896f93a
@@ -24,6 +24,10 @@ package main
 import "time"
 import "github.com/bt51/ntpclient"
 
+// Embed the time zone database so configured time zones can be resolved
+// on hosts without tzdata (e.g. Windows or minimal containers).
+import _ "time/tzdata"
+
 const timeServer = "0.pool.ntp.org"
 const maxTimeDifferenceInSeconds = 60
 
@@ -46,3 +50,120 @@ func IsLocalTimeValid() (bool, error) {
 	}
 	return false, nil
 }
+
+// wallClockResolution describes how a wall clock time was mapped to
+// an instant on a day with a daylight saving time transition.
+type wallClockResolution int
+
+const (
+	wallClockUnique   wallClockResolution = iota // wall clock exists exactly once
+	wallClockSkipped                             // wall clock was skipped and moved forward
+	wallClockRepeated                            // wall clock exists twice, first occurrence used
+)
+
+// localTime returns the instant for the given wall clock in the given
+// location. In contrast to time.Date it resolves daylight saving time
+// transitions deterministically (see resolveWallClock).
+func localTime(yr int, mth time.Month, day, hour, minute, second int, location *time.Location) time.Time {
+	t, _ := resolveWallClock(yr, mth, day, hour, minute, second, location)
+	return t
+}
+
+// resolveWallClock returns the instant for the given wall clock in the
+// given location.
+// A wall clock which falls into a skipped hour (spring forward) will be
+// moved forward by the length of the gap, e.g. 02:30 becomes 03:30.
+// A wall clock which falls into a repeated hour (fall back) resolves
+// to its first occurrence.
+func resolveWallClock(yr int, mth time.Month, day, hour, minute, second int, location *time.Location) (time.Time, wallClockResolution) {
+	wall := time.Date(yr, mth, day, hour, minute, second, 0, time.UTC)
+
+	// offsets in effect a day before and after the wall clock
+	_, offsetBefore := wall.Add(-24 * time.Hour).In(location).Zone()
+	_, offsetAfter := wall.Add(24 * time.Hour).In(location).Zone()
+	if offsetBefore == offsetAfter {
+		return wall.Add(-time.Duration(offsetBefore) * time.Second).In(location), wallClockUnique
+	}
+
+	earlier := wall.Add(-time.Duration(max(offsetBefore, offsetAfter)) * time.Second).In(location)
+	later := wall.Add(-time.Duration(min(offsetBefore, offsetAfter)) * time.Second).In(location)
+	earlierValid := hasWallClock(earlier, wall)
+	laterValid := hasWallClock(later, wall)
+
+	switch {
+	case earlierValid && laterValid:
+		return earlier, wallClockRepeated
+	case earlierValid:
+		return earlier, wallClockUnique
+	case laterValid:
+		return later, wallClockUnique
+	}
+
+	// The wall clock lays inside the gap. Interpreting it with the offset
+	// before the transition moves it forward by the length of the gap.
+	return wall.Add(-time.Duration(offsetBefore) * time.Second).In(location), wallClockSkipped
+}
+
+func hasWallClock(t time.Time, wall time.Time) bool {
+	yr, mth, day := t.Date()
+	wyr, wmth, wday := wall.Date()
+	return yr == wyr && mth == wmth && day == wday && t.Hour() == wall.Hour() && t.Minute() == wall.Minute() && t.Second() == wall.Second()
+}
+
+// startOfDay returns the first instant of the day of the given time in
+// its location.
+func startOfDay(t time.Time) time.Time {
+	yr, mth, day := t.Date()
+	return localTime(yr, mth, day, 0, 0, 0, t.Location())
+}
+
+// startOfNextDay returns the first instant of the day following the
+// given time in its location. Days with a daylight saving time
+// transition are 23 or 25 hours long.
+func startOfNextDay(t time.Time) time.Time {
+	yr, mth, day := t.Date()
+	return localTime(yr, mth, day+1, 0, 0, 0, t.Location())
+}
+
+// endOfDay returns the last instant of the day of the given time in
+// its location.
+func endOfDay(t time.Time) time.Time {
+	return startOfNextDay(t).Add(-time.Nanosecond)
+}
+
+// clockJumpThreshold defines the minimum discontinuity of the wall clock
+// which is considered a clock jump.
+const clockJumpThreshold = 10 * time.Second
+
+// clockMonitor detects discontinuities of the wall clock between two
+// cyclic events. These happen when the system resumes from suspend or
+// the clock gets stepped (e.g. by NTP).
+type clockMonitor struct {
+	started time.Time
+}
+
+// start marks the beginning of the next interval.
+func (monitor *clockMonitor) start() {
+	monitor.started = time.Now()
+}
+
+// jump returns the discontinuity of the wall clock since the last call
+// to start and whether it exceeds clockJumpThreshold.
+func (monitor *clockMonitor) jump() (time.Duration, bool) {
+	if monitor.started.IsZero() {
+		return 0, false
+	}
+	now := time.Now()
+	return clockJump(now.Round(0).Sub(monitor.started.Round(0)), now.Sub(monitor.started))
+}
+
+// clockJump compares the elapsed time of the wall clock and the monotonic
+// clock. The monotonic clock is not affected by clock steps and stops on
+// most platforms while the system is suspended. A main loop which was
+// merely blocked advances both clocks alike and is no clock jump. Where the
+// monotonic clock keeps running during suspend, timers still expire at the
+// right wall clock time, so there is nothing to correct.
+func clockJump(wallElapsed time.Duration, monotonicElapsed time.Duration) (time.Duration, bool) {
+	jump := wallElapsed - monotonicElapsed
+	return jump, jump.Abs() >= clockJumpThreshold
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func loadLocation(t *testing.T, name string) *time.Location {
+	location, err := time.LoadLocation(name)
+	if err != nil {
+		t.Fatalf("Could not load location %s: %v", name, err)
+	}
+	return location
+}
+
+func TestResolveWallClock(t *testing.T) {
+	tests := []struct {
+		zone       string
+		date       string
+		time       string
+		want       string
+		resolution wallClockResolution
+	}{
+		// regular days
+		{"Europe/Berlin", "2023-03-25", "02:30", "2023-03-25 02:30 +0100", wallClockUnique},
+		{"Europe/Berlin", "2023-07-01", "12:00", "2023-07-01 12:00 +0200", wallClockUnique},
+		// spring forward: skipped hour moves forward
+		{"Europe/Berlin", "2023-03-26", "02:30", "2023-03-26 03:30 +0200", wallClockSkipped},
+		{"Europe/Berlin", "2023-03-26", "01:59", "2023-03-26 01:59 +0100", wallClockUnique},
+		{"Europe/Berlin", "2023-03-26", "03:00", "2023-03-26 03:00 +0200", wallClockUnique},
+		{"America/New_York", "2023-03-12", "02:00", "2023-03-12 03:00 -0400", wallClockSkipped},
+		{"Australia/Sydney", "2023-10-01", "02:45", "2023-10-01 03:45 +1100", wallClockSkipped},
+		{"America/Santiago", "2023-09-03", "00:00", "2023-09-03 01:00 -0300", wallClockSkipped},
+		{"America/Santiago", "2023-09-03", "00:30", "2023-09-03 01:30 -0300", wallClockSkipped},
+		// fall back: repeated hour uses first occurrence
+		{"Europe/Berlin", "2023-10-29", "02:30", "2023-10-29 02:30 +0200", wallClockRepeated},
+		{"Europe/Berlin", "2023-10-29", "03:00", "2023-10-29 03:00 +0100", wallClockUnique},
+		{"America/New_York", "2023-11-05", "01:30", "2023-11-05 01:30 -0400", wallClockRepeated},
+		{"Australia/Sydney", "2023-04-02", "02:15", "2023-04-02 02:15 +1100", wallClockRepeated},
+		{"America/Santiago", "2023-04-01", "23:30", "2023-04-01 23:30 -0300", wallClockRepeated},
+		{"America/Santiago", "2023-04-02", "00:00", "2023-04-02 00:00 -0400", wallClockUnique},
+	}
+
+	for _, test := range tests {
+		location := loadLocation(t, test.zone)
+		date, _ := time.Parse("2006-01-02", test.date)
+		clock, _ := time.Parse("15:04", test.time)
+		result, resolution := resolveWallClock(date.Year(), date.Month(), date.Day(), clock.Hour(), clock.Minute(), 0, location)
+		if result.Format("2006-01-02 15:04 -0700") != test.want || resolution != test.resolution {
+			t.Errorf("resolveWallClock(%s %s, %s) = %s (%d); want %s (%d)", test.date, test.time, test.zone, result.Format("2006-01-02 15:04 -0700"), resolution, test.want, test.resolution)
+		}
+	}
+}
+
+func TestDayLength(t *testing.T) {
+	tests := []struct {
+		zone   string
+		date   string
+		length time.Duration
+	}{
+		{"Europe/Berlin", "2023-03-25", 24 * time.Hour},
+		{"Europe/Berlin", "2023-03-26", 23 * time.Hour},
+		{"Europe/Berlin", "2023-10-29", 25 * time.Hour},
+		{"America/New_York", "2023-03-12", 23 * time.Hour},
+		{"America/New_York", "2023-11-05", 25 * time.Hour},
+		{"Australia/Sydney", "2023-10-01", 23 * time.Hour},
+		{"Australia/Sydney", "2023-04-02", 25 * time.Hour},
+		{"America/Santiago", "2023-09-03", 23 * time.Hour},
+		{"America/Santiago", "2023-04-01", 25 * time.Hour},
+		{"UTC", "2023-03-26", 24 * time.Hour},
+	}
+
+	for _, test := range tests {
+		location := loadLocation(t, test.zone)
+		date, _ := time.ParseInLocation("2006-01-02 15:04", test.date+" 12:00", location)
+		start := startOfDay(date)
+		next := startOfNextDay(date)
+		if length := next.Sub(start); length != test.length {
+			t.Errorf("Day %s in %s lasts %v (%v - %v); want %v", test.date, test.zone, length, start, next, test.length)
+		}
+		if end := endOfDay(date); !end.Before(next) || end.Day() != date.Day() {
+			t.Errorf("endOfDay(%v) = %v; want last instant before %v", date, end, next)
+		}
+	}
+}
+
+func TestInterpolationAcrossTransition(t *testing.T) {
+	tests := []struct {
+		zone      string
+		date      string
+		latitude  float64
+		longitude float64
+	}{
+		{"Europe/Berlin", "2023-03-26", 52.52, 13.40},
+		{"Europe/Berlin", "2023-10-29", 52.52, 13.40},
+		{"America/New_York", "2023-03-12", 40.71, -74.01},
+		{"America/New_York", "2023-11-05", 40.71, -74.01},
+		{"Australia/Sydney", "2023-10-01", -33.87, 151.21},
+		{"Australia/Sydney", "2023-04-02", -33.87, 151.21},
+	}
+
+	for _, test := range tests {
+		c := Configuration{}
+		c.initializeDefaults()
+		c.Timezone = test.zone
+		c.Location = Location{test.latitude, test.longitude}
+		c.Schedules[0].AssociatedDeviceIDs = deviceIDs(1)
+		c.Schedules[0].BeforeSunrise = []TimedColorTemperature{{"1:00", 2000, 20}, {"4:30", 4000, 100}}
+
+		location := loadLocation(t, test.zone)
+		date, _ := time.ParseInLocation("2006-01-02", test.date, location)
+		schedule, err := c.lightScheduleForDay("1", date)
+		if err != nil {
+			t.Fatalf("lightScheduleForDay returned error: %v", err)
+		}
+
+		// sample every minute between both entries
+		start := localTime(date.Year(), date.Month(), date.Day(), 1, 0, 30, location)
+		end := localTime(date.Year(), date.Month(), date.Day(), 4, 29, 30, location)
+		var previous LightState
+		for timestamp := start; !timestamp.After(end); timestamp = timestamp.Add(time.Minute) {
+			interval, err := schedule.currentInterval(timestamp)
+			if err != nil {
+				t.Fatalf("currentInterval(%v) returned error: %v", timestamp, err)
+			}
+			state := interval.calculateLightStateInInterval(timestamp)
+			if timestamp.Equal(start) && (!equalsInt(state.ColorTemperature, 2000, 20) || !equalsInt(state.Brightness, 20, 1)) {
+				t.Errorf("%s: state at %v = %+v; want {2000 20}", test.zone, timestamp, state)
+			}
+			if !timestamp.Equal(start) && (abs(state.ColorTemperature-previous.ColorTemperature) > 20 || abs(state.Brightness-previous.Brightness) > 1) {
+				t.Errorf("%s: state jumped from %+v to %+v at %v", test.zone, previous, state, timestamp)
+			}
+			previous = state
+		}
+		if !equalsInt(previous.ColorTemperature, 4000, 20) || !equalsInt(previous.Brightness, 100, 1) {
+			t.Errorf("%s: state at %v = %+v; want {4000 100}", test.zone, end, previous)
+		}
+	}
+}
+
+func TestClockJump(t *testing.T) {
+	tests := []struct {
+		name      string
+		wall      time.Duration
+		monotonic time.Duration
+		jump      time.Duration
+		detected  bool
+	}{
+		{"regular interval", time.Second, time.Second, 0, false},
+		{"blocked main loop", 30 * time.Second, 30 * time.Second, 0, false},
+		{"small drift", 6 * time.Second, time.Second, 5 * time.Second, false},
+		{"suspend", time.Hour + time.Second, time.Second, time.Hour, true},
+		{"clock stepped forward", 11 * time.Second, time.Second, 10 * time.Second, true},
+		{"clock stepped backward", -19 * time.Second, time.Second, -20 * time.Second, true},
+	}
+
+	for _, test := range tests {
+		jump, detected := clockJump(test.wall, test.monotonic)
+		if jump != test.jump || detected != test.detected {
+			t.Errorf("%s: clock jump should be %v (detected: %v) but is %v (detected: %v)", test.name, test.jump, test.detected, jump, detected)
+		}
+	}
+}
+
+func TestClockMonitor(t *testing.T) {
+	var monitor clockMonitor
+	if _, detected := monitor.jump(); detected {
+		t.Errorf("clock monitor should not detect a jump before it was started")
+	}
+	monitor.start()
+	if jump, detected := monitor.jump(); detected {
+		t.Errorf("clock monitor should not detect a jump right after it was started but detected %v", jump)
+	}
+}
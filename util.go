@@ -130,17 +130,6 @@ func absolutePath(filename string) string {
 	return abs
 }
 
-func durationUntilNextDay() time.Duration {
-	now := time.Now()
-	endOfDay := time.Date(now.Year(), now.Month(), now.Day(), 23, 59, 59, 1, now.Location())
-	endOfDay = endOfDay.Add(1 * time.Second)
-	return time.Until(endOfDay)
-}
-
-func isYAMLFile(filename string) bool {
-	fileExt := filepath.Ext(filename)
-	if fileExt == ".yaml" || fileExt == ".yml" {
-		return true
-	}
-	return false
+func durationUntilNextDay(location *time.Location) time.Duration {
+	return time.Until(startOfNextDay(time.Now().In(location)))
 }
@@ -0,0 +1,201 @@
+// MIT License
+//
+// # Copyright (c) 2019 Stefan Wichmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const weatherUpdateInterval = 10 * time.Minute
+const weatherReadingMaxAge = 3 * time.Hour
+const weatherRequestTimeout = 5 * time.Second
+
+// WeatherProvider reports the current weather conditions at the configured
+// location.
+type WeatherProvider interface {
+	// CloudCover returns the current cloud cover in percent (0-100).
+	CloudCover() (int, error)
+}
+
+// WeatherReading represents the last known weather conditions.
+type WeatherReading struct {
+	CloudCover int       `json:"cloudCover"`
+	Time       time.Time `json:"time"`
+}
+
+// localWeatherProvider reads the weather from a local JSON file or a
+// local HTTP endpoint. The expected format is {"cloudCover": 85}.
+type localWeatherProvider struct {
+	source string
+	client *http.Client
+}
+
+func newLocalWeatherProvider(source string) *localWeatherProvider {
+	return &localWeatherProvider{source, &http.Client{Timeout: weatherRequestTimeout}}
+}
+
+func (provider *localWeatherProvider) CloudCover() (int, error) {
+	raw, err := provider.read()
+	if err != nil {
+		return 0, err
+	}
+
+	var data struct {
+		CloudCover *int `json:"cloudCover"`
+	}
+	err = json.Unmarshal(raw, &data)
+	if err != nil {
+		return 0, err
+	}
+	if data.CloudCover == nil {
+		return 0, errors.New("weather data does not contain a cloud cover")
+	}
+	if *data.CloudCover < 0 || *data.CloudCover > 100 {
+		return 0, fmt.Errorf("invalid cloud cover %d%%", *data.CloudCover)
+	}
+	return *data.CloudCover, nil
+}
+
+func (provider *localWeatherProvider) read() ([]byte, error) {
+	if !strings.HasPrefix(provider.source, "http://") && !strings.HasPrefix(provider.source, "https://") {
+		return os.ReadFile(provider.source)
+	}
+
+	response, err := provider.client.Get(provider.source)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("weather endpoint returned status %s", response.Status)
+	}
+	return io.ReadAll(response.Body)
+}
+
+// WeatherCache keeps the last weather reading of a provider. It is
+// refreshed in the background so an unavailable provider never blocks
+// the light updates.
+type WeatherCache struct {
+	provider WeatherProvider
+	running  bool
+	lock     sync.RWMutex
+	reading  WeatherReading
+	updates  chan struct{}
+}
+
+var weatherCache = &WeatherCache{updates: make(chan struct{}, 1)}
+
+// start polls the given provider in the background. Calling start again
+// replaces the provider, nil disables the weather updates.
+func (cache *WeatherCache) start(provider WeatherProvider, interval time.Duration) {
+	cache.lock.Lock()
+	cache.provider = provider
+	cache.reading = WeatherReading{}
+	running := cache.running
+	cache.running = true
+	cache.lock.Unlock()
+	if running {
+		go cache.refresh()
+		return
+	}
+
+	go func() {
+		for {
+			cache.refresh()
+			time.Sleep(interval)
+		}
+	}()
+}
+
+func (cache *WeatherCache) refresh() {
+	cache.lock.RLock()
+	provider := cache.provider
+	cache.lock.RUnlock()
+	if provider == nil {
+		return
+	}
+
+	cloudCover, err := provider.CloudCover()
+	if err != nil {
+		log.Warningf("☁ Could not update weather: %v", err)
+		return
+	}
+
+	cache.lock.Lock()
+	changed := cache.reading.Time.IsZero() || cache.reading.CloudCover != cloudCover
+	cache.reading = WeatherReading{cloudCover, time.Now()}
+	cache.lock.Unlock()
+
+	if changed {
+		log.Printf("☁ Current cloud cover is %d%%", cloudCover)
+		// notify without blocking
+		select {
+		case cache.updates <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// cloudCover returns the last known cloud cover if it is recent enough.
+func (cache *WeatherCache) cloudCover() (int, bool) {
+	cache.lock.RLock()
+	defer cache.lock.RUnlock()
+	if cache.reading.Time.IsZero() || time.Since(cache.reading.Time) > weatherReadingMaxAge {
+		return 0, false
+	}
+	return cache.reading.CloudCover, true
+}
+
+// adjustSchedule makes the daylight of the given schedule brighter and
+// cooler and advances the sunset proportionally to the cloud cover above
+// the configured threshold.
+func (weather *Weather) adjustSchedule(schedule *Schedule, cloudCover int) {
+	if cloudCover <= weather.CloudCoverThreshold || weather.CloudCoverThreshold >= 100 {
+		return
+	}
+	factor := float64(cloudCover-weather.CloudCoverThreshold) / float64(100-weather.CloudCoverThreshold)
+
+	for _, timestamp := range []*TimeStamp{&schedule.sunrise, &schedule.sunset} {
+		if timestamp.ColorTemperature != -1 {
+			timestamp.ColorTemperature = min(timestamp.ColorTemperature+int(factor*float64(weather.ColorTemperatureOffset)), 6500)
+		}
+		if timestamp.Brightness != -1 {
+			timestamp.Brightness = min(timestamp.Brightness+int(factor*float64(weather.BrightnessOffset)), 100)
+		}
+	}
+
+	sunset := schedule.sunset.Time.Add(-time.Duration(factor * float64(weather.SunsetOffset) * float64(time.Minute)))
+	if sunset.After(schedule.sunrise.Time) {
+		schedule.sunset.Time = sunset
+	}
+	log.Debugf("☁ Adjusted daylight for a cloud cover of %d%% to %dK at %d%% brightness until %v", cloudCover, schedule.sunrise.ColorTemperature, schedule.sunrise.Brightness, schedule.sunset.Time.Format("15:04"))
+}
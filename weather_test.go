@@ -0,0 +1,117 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLocalWeatherProviderFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "weather.json")
+	os.WriteFile(file, []byte(`{"cloudCover": 85}`), 0644)
+
+	cloudCover, err := newLocalWeatherProvider(file).CloudCover()
+	if err != nil || cloudCover != 85 {
+		t.Errorf("CloudCover() = %d, %v; want 85, nil", cloudCover, err)
+	}
+
+	for _, content := range []string{`{}`, `{"cloudCover": 101}`, `no json`} {
+		os.WriteFile(file, []byte(content), 0644)
+		_, err := newLocalWeatherProvider(file).CloudCover()
+		if err == nil {
+			t.Errorf("CloudCover() for %s should return an error", content)
+		}
+	}
+}
+
+func TestLocalWeatherProviderHTTP(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"cloudCover": 40}`))
+	}))
+	defer server.Close()
+
+	cloudCover, err := newLocalWeatherProvider(server.URL).CloudCover()
+	if err != nil || cloudCover != 40 {
+		t.Errorf("CloudCover() = %d, %v; want 40, nil", cloudCover, err)
+	}
+
+	server.Close()
+	_, err = newLocalWeatherProvider(server.URL).CloudCover()
+	if err == nil {
+		t.Errorf("CloudCover() for unreachable endpoint should return an error")
+	}
+}
+
+type stubWeatherProvider struct {
+	cloudCover int
+	err        error
+}
+
+func (provider *stubWeatherProvider) CloudCover() (int, error) {
+	return provider.cloudCover, provider.err
+}
+
+func TestWeatherCacheKeepsLastReading(t *testing.T) {
+	provider := &stubWeatherProvider{cloudCover: 90}
+	cache := &WeatherCache{provider: provider, updates: make(chan struct{}, 1)}
+
+	if _, ok := cache.cloudCover(); ok {
+		t.Errorf("cloudCover() of empty cache should not be valid")
+	}
+
+	cache.refresh()
+	select {
+	case <-cache.updates:
+	default:
+		t.Errorf("refresh() did not notify about new reading")
+	}
+
+	provider.err = errors.New("network down")
+	cache.refresh()
+	cloudCover, ok := cache.cloudCover()
+	if !ok || cloudCover != 90 {
+		t.Errorf("cloudCover() = %d, %t; want 90, true", cloudCover, ok)
+	}
+
+	cache.reading.Time = time.Now().Add(-weatherReadingMaxAge - time.Minute)
+	if _, ok := cache.cloudCover(); ok {
+		t.Errorf("cloudCover() should ignore outdated readings")
+	}
+}
+
+func TestAdjustSchedule(t *testing.T) {
+	weather := Weather{CloudCoverThreshold: 60, ColorTemperatureOffset: 1000, BrightnessOffset: 20, SunsetOffset: 60}
+	sunrise := time.Date(2023, time.November, 1, 7, 0, 0, 0, time.UTC)
+	sunset := time.Date(2023, time.November, 1, 17, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		cloudCover       int
+		colorTemperature int
+		brightness       int
+		sunset           time.Time
+	}{
+		{0, 2750, 90, sunset},
+		{60, 2750, 90, sunset},
+		{80, 3250, 100, sunset.Add(-30 * time.Minute)},
+		{100, 3750, 100, sunset.Add(-60 * time.Minute)},
+	}
+
+	for _, test := range tests {
+		schedule := Schedule{sunrise: TimeStamp{sunrise, 2750, 90}, sunset: TimeStamp{sunset, 2750, 90}}
+		weather.adjustSchedule(&schedule, test.cloudCover)
+		if schedule.sunrise.ColorTemperature != test.colorTemperature || schedule.sunset.Brightness != test.brightness || !schedule.sunset.Time.Equal(test.sunset) {
+			t.Errorf("adjustSchedule(%d%%) = %dK, %d%%, %v; want %dK, %d%%, %v", test.cloudCover, schedule.sunrise.ColorTemperature, schedule.sunset.Brightness, schedule.sunset.Time, test.colorTemperature, test.brightness, test.sunset)
+		}
+	}
+
+	// ignored values stay ignored
+	schedule := Schedule{sunrise: TimeStamp{sunrise, -1, -1}, sunset: TimeStamp{sunset, -1, -1}}
+	weather.adjustSchedule(&schedule, 100)
+	if schedule.sunrise.ColorTemperature != -1 || schedule.sunrise.Brightness != -1 {
+		t.Errorf("adjustSchedule changed ignored values to %+v", schedule.sunrise)
+	}
+}
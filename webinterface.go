@@ -22,12 +22,14 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"html/template"
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gorilla/handlers"
 	"github.com/gorilla/mux"
@@ -40,19 +42,36 @@ func startInterface() {
 	}
 
 	r := mux.NewRouter()
-	// html endpoints
+	// html endpoints. All handlers accessing the configuration or the lights
+	// run on the main loop. Handlers with a request body decode it first and
+	// only pass the change to the main loop.
 	r.HandleFunc("/", dashboardHandler).Methods("HEAD", "GET")
-	r.HandleFunc("/schedules.html", schedulesHandler).Methods("GET")
-	r.HandleFunc("/configuration.html", configurationHandler).Methods("GET")
+	r.HandleFunc("/schedules.html", onMainLoop(schedulesHandler)).Methods("GET")
+	r.HandleFunc("/configuration.html", onMainLoop(configurationHandler)).Methods("GET")
+	r.HandleFunc("/history.html", onMainLoop(historyHandler)).Methods("GET")
 
 	// REST endpoints
 	r.HandleFunc("/restart", restartHandler).Methods("PUT", "POST")
 	r.HandleFunc("/schedules", updateSchedulesHandler).Methods("PUT", "POST")
 	r.HandleFunc("/configuration", updateConfigurationHandler).Methods("PUT", "POST")
-	r.HandleFunc("/lights", lightsHandler).Methods("GET")
-	r.HandleFunc("/lights/{id}/automatic", automateLightHandler).Methods("PUT", "POST")
+	r.HandleFunc("/api/schema", schemaHandler).Methods("GET")
+	r.HandleFunc("/configuration/sources", onMainLoop(configurationSourcesHandler)).Methods("GET")
+	r.HandleFunc("/configuration/backups", onMainLoop(configurationBackupsHandler)).Methods("GET")
+	r.HandleFunc("/configuration/backups/{name}/restore", onMainLoop(restoreConfigurationBackupHandler)).Methods("PUT", "POST")
+	r.HandleFunc("/configuration/history", onMainLoop(configurationHistoryHandler)).Methods("GET")
+	r.HandleFunc("/configuration/history/{id}", onMainLoop(configurationVersionHandler)).Methods("GET")
+	r.HandleFunc("/configuration/history/{id}/revert", onMainLoop(revertConfigurationHandler)).Methods("PUT", "POST")
+	r.HandleFunc("/lights", onMainLoop(lightsHandler)).Methods("GET")
+	r.HandleFunc("/lights/{id}/automatic", onMainLoop(automateLightHandler)).Methods("PUT", "POST")
 	r.HandleFunc("/lights/{id}/activate", activateLightHandler).Methods("PUT", "POST")
+	r.HandleFunc("/away", onMainLoop(awayModeHandler)).Methods("GET")
+	r.HandleFunc("/away", updateAwayModeHandler).Methods("PUT", "POST")
 	r.HandleFunc("/health", healthHandler).Methods("HEAD", "GET")
+	r.HandleFunc("/metrics", metricsHandler).Methods("GET")
+	r.HandleFunc("/pairing", pairingHandler).Methods("GET")
+	r.HandleFunc("/pairing/discover", pairingDiscoverHandler).Methods("PUT", "POST")
+	r.HandleFunc("/pairing/start", pairingStartHandler).Methods("PUT", "POST")
+	r.HandleFunc("/pairing/cancel", pairingCancelHandler).Methods("PUT", "POST")
 
 	// static files
 	r.PathPrefix("/static/").Handler(http.StripPrefix("/static/", http.FileServer(http.Dir("gui/static"))))
@@ -63,22 +82,105 @@ func startInterface() {
 	log.Warning(http.ListenAndServe(fmt.Sprintf(":%d", port), nil))
 }
 
+// onMainLoop serves the request on the main loop, so the handler can access
+// the configuration and the lights without racing the light updates. The
+// response is buffered and sent once the main loop continued, so a slow
+// client can't delay the light updates. The handler must not read the
+// request body.
+func onMainLoop(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !mainLoopRunning.Load() {
+			http.Error(w, "Kelvin is starting up", http.StatusServiceUnavailable)
+			return
+		}
+		response := bufferedResponse{header: make(http.Header), status: http.StatusOK}
+		done := make(chan struct{})
+		select {
+		case mainLoopRequests <- func() {
+			defer close(done)
+			handler(&response, r)
+		}:
+			<-done
+		case <-r.Context().Done():
+			return
+		}
+		response.writeTo(w)
+	}
+}
+
+// bufferedResponse keeps the response of a handler in memory.
+type bufferedResponse struct {
+	header      http.Header
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (response *bufferedResponse) Header() http.Header {
+	return response.header
+}
+
+func (response *bufferedResponse) WriteHeader(status int) {
+	if !response.wroteHeader {
+		response.status = status
+		response.wroteHeader = true
+	}
+}
+
+func (response *bufferedResponse) Write(data []byte) (int, error) {
+	response.wroteHeader = true
+	return response.body.Write(data)
+}
+
+func (response *bufferedResponse) writeTo(w http.ResponseWriter) {
+	for key, values := range response.header {
+		w.Header()[key] = values
+	}
+	w.WriteHeader(response.status)
+	w.Write(response.body.Bytes())
+}
+
 func dashboardHandler(w http.ResponseWriter, r *http.Request) {
 	log.Debugf("Serving dashboard page to %s", r.RemoteAddr)
-	if configuration.Bridge.IP == "" || configuration.Bridge.Username == "" {
-		dashboardTemplate := template.Must(template.New("init.html").ParseGlob("gui/template/init.html"))
-		err := dashboardTemplate.Execute(w, bridge)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+	if !mainLoopRunning.Load() {
+		// Kelvin is still connecting to the bridges
+		status := pairing.Status()
+		if status.State == pairingIdle {
+			http.Error(w, "Kelvin is connecting to your bridge. Please reload this page in a few seconds.", http.StatusServiceUnavailable)
 			return
 		}
-	} else {
-		dashboardTemplate := template.Must(template.New("dashboard.html").ParseGlob("gui/template/dashboard.html"))
-		err := dashboardTemplate.Execute(w, lights)
+		dashboardTemplate := template.Must(template.New("init.html").ParseGlob("gui/template/init.html"))
+		err := dashboardTemplate.Execute(w, struct{ Name string }{status.Bridge})
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		return
+	}
+	onMainLoop(lightsDashboardHandler)(w, r)
+}
+
+// lightsDashboardHandler shows the lights grouped by bridge.
+func lightsDashboardHandler(w http.ResponseWriter, r *http.Request) {
+	type bridgeLights struct {
+		Name   string
+		Lights []*Light
+	}
+	var data []bridgeLights
+	for _, bridge := range bridges {
+		group := bridgeLights{Name: bridge.String()}
+		for _, light := range lights {
+			if light.backend == bridge {
+				group.Lights = append(group.Lights, light)
+			}
+		}
+		data = append(data, group)
+	}
+	dashboardTemplate := template.Must(template.New("dashboard.html").ParseGlob("gui/template/dashboard.html"))
+	err := dashboardTemplate.Execute(w, data)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
 }
 
@@ -92,10 +194,29 @@ func configurationHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func historyHandler(w http.ResponseWriter, r *http.Request) {
+	log.Debugf("Serving history page to %s", r.RemoteAddr)
+	history, err := configuration.History()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	historyTemplate := template.Must(template.New("history.html").Funcs(template.FuncMap{"historyValue": historyValue}).ParseGlob("gui/template/history.html"))
+	err = historyTemplate.Execute(w, history)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
 func schedulesHandler(w http.ResponseWriter, r *http.Request) {
 	log.Debugf("Serving schedules page to %s", r.RemoteAddr)
 	schedulesTemplate := template.Must(template.New("schedules.html").Funcs(template.FuncMap{"lightsToString": lightsToString}).ParseGlob("gui/template/schedules.html"))
-	err := schedulesTemplate.Execute(w, configuration.Schedules)
+	data := struct {
+		Schedules      []LightSchedule
+		CalendarEvents []ScheduledCalendarEvent
+	}{configuration.Schedules, calendarWatcher.upcomingEvents(time.Now(), upcomingCalendarEventsDuration)}
+	err := schedulesTemplate.Execute(w, data)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -104,22 +225,86 @@ func schedulesHandler(w http.ResponseWriter, r *http.Request) {
 
 func healthHandler(w http.ResponseWriter, r *http.Request) {
 	log.Debugf("Health request with method %s from %s", r.Method, r.RemoteAddr)
-	// Assume Kelvin is healthy when is is running for now
+	// Assume Kelvin is healthy when is is running. Lost bridges are
+	// reported, but Kelvin keeps searching for them.
+	var health struct {
+		Status  string                  `json:"status"`
+		Bridges []BridgeConnectionState `json:"bridges"`
+	}
+	health.Status = "ok"
+	health.Bridges = []BridgeConnectionState{}
+	for _, bridge := range bridges {
+		state := bridge.connectionState()
+		if !state.Connected {
+			health.Status = "degraded"
+		}
+		health.Bridges = append(health.Bridges, state)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(200)
+	if r.Method == "HEAD" {
+		return
+	}
+	json.NewEncoder(w).Encode(health)
+}
+
+// BridgeMetrics describes the traffic Kelvin sends to a bridge.
+type BridgeMetrics struct {
+	Name      string           `json:"name"`
+	Groups    GroupStatistics  `json:"groups"`
+	Scheduler SchedulerMetrics `json:"scheduler"`
+}
+
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	log.Debugf("Metrics request from %s", r.RemoteAddr)
+	var metrics struct {
+		Bridges []BridgeMetrics `json:"bridges"`
+	}
+	metrics.Bridges = []BridgeMetrics{}
+	for _, bridge := range bridges {
+		// The scheduler of a bridge is created while it connects
+		var scheduler *RequestScheduler
+		if bridge.ready.Load() {
+			scheduler = bridge.requests
+		}
+		// The address of the bridge is owned by the main loop
+		name := bridge.connectionState().String()
+		metrics.Bridges = append(metrics.Bridges, BridgeMetrics{Name: name, Groups: bridge.GroupStatistics(), Scheduler: scheduler.Metrics()})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(metrics)
 }
 
 func lightsToString(args ...interface{}) (string, error) {
 	ok := false
-	var s []int
+	var s []DeviceID
 	if len(args) == 1 {
-		s, ok = args[0].([]int)
+		s, ok = args[0].([]DeviceID)
 	} else {
 		return "", fmt.Errorf("input length != 1: %v", args)
 	}
 	if !ok {
-		return "", fmt.Errorf("not a []int: %v", args)
+		return "", fmt.Errorf("not a []DeviceID: %v", args)
+	}
+	var ids []string
+	for _, id := range s {
+		ids = append(ids, string(id))
+	}
+	return strings.Join(ids, ","), nil
+}
+
+// historyValue formats a value of a configuration change for display.
+func historyValue(value interface{}) string {
+	if value == nil {
+		return "-"
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
 	}
-	return strings.Trim(strings.Join(strings.Fields(fmt.Sprint(s)), ","), "[]"), nil
+	return string(data)
 }
 
 func updateSchedulesHandler(w http.ResponseWriter, r *http.Request) {
@@ -132,22 +317,26 @@ func updateSchedulesHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	defer r.Body.Close()
 	log.Debugf("Received schedule update from %s: %+v", r.RemoteAddr, t)
-	configuration.Schedules = t
-	err = configuration.Write()
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
 
-	// Update scenes
-	updateScenes()
+	onMainLoop(func(w http.ResponseWriter, r *http.Request) {
+		configuration.Schedules = t
+		err := configuration.Write()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		configuration.logHistory("web client " + r.RemoteAddr)
+
+		// Update scenes
+		updateScenes()
 
-	// Update lights
-	for _, light := range lights {
-		light := light
-		updateScheduleForLight(light)
-	}
-	w.Write([]byte("success"))
+		// Update lights
+		for _, light := range lights {
+			light := light
+			updateScheduleForLight(light)
+		}
+		w.Write([]byte("success"))
+	})(w, r)
 }
 
 func updateConfigurationHandler(w http.ResponseWriter, r *http.Request) {
@@ -160,22 +349,66 @@ func updateConfigurationHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	defer r.Body.Close()
 	log.Debugf("Received configuration update from %s: %+v", r.RemoteAddr, t)
+	if t.Timezone != "" {
+		if _, err := time.LoadLocation(t.Timezone); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	onMainLoop(func(w http.ResponseWriter, r *http.Request) {
+		applyConfigurationUpdate(w, r, t)
+	})(w, r)
+}
+
+// applyConfigurationUpdate saves the settings of the configuration page. It
+// runs on the main loop.
+func applyConfigurationUpdate(w http.ResponseWriter, r *http.Request, t Configuration) {
+	if t.Bridge.Username == "" {
+		// The username is never sent to the browser
+		t.Bridge.Username = configuration.Bridge.Username
+	}
+	t.Bridge.Name = configuration.Bridge.Name
+	t.Bridge.ID = configuration.Bridge.ID
+	t.Bridge.CertificateFingerprint = configuration.Bridge.CertificateFingerprint
+	t.Bridge.UsernameFile = configuration.Bridge.UsernameFile
+	t.Bridge.GroupCommands = configuration.Bridge.GroupCommands
+
+	// Overridden values are not written to the configuration file
+	updated := *configuration
+	updated.Bridge = t.Bridge
+	updated.Location = t.Location
+	updated.Timezone = t.Timezone
+	updated.WebInterface = t.WebInterface
+	if changes := configuration.overriddenChanges(&updated); len(changes) > 0 {
+		log.Warningf("⚙ Rejected configuration update from %s: %s", r.RemoteAddr, strings.Join(changes, ", "))
+		http.Error(w, strings.Join(changes, ", ")+" and can't be changed in the web interface", http.StatusConflict)
+		return
+	}
+
+	previous := *configuration
 	configuration.Bridge = t.Bridge
 	configuration.Location = t.Location
+	configuration.Timezone = t.Timezone
 	configuration.WebInterface = t.WebInterface
-	configuration.Write()
+	err := configuration.Write()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	configuration.logHistory("web client " + r.RemoteAddr)
 	log.Debugf("Updated configuration to: %+v", configuration)
+	applyConfiguration(&previous)
 	w.Write([]byte("success"))
 }
 
 func automateLightHandler(w http.ResponseWriter, r *http.Request) {
-	lightID, err := strconv.Atoi(mux.Vars(r)["id"])
-	if err != nil {
+	device := configuration.normalizeDeviceID(DeviceID(mux.Vars(r)["id"]))
+	if _, _, err := device.split(); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 	for _, l := range lights {
-		if l.ID == lightID {
+		if l.Device == device {
 			log.Printf("💡 Light %s - Enabling automatic mode as requested by %s", l.Name, r.RemoteAddr)
 			l.Tracking = false
 		}
@@ -186,14 +419,9 @@ func automateLightHandler(w http.ResponseWriter, r *http.Request) {
 func activateLightHandler(w http.ResponseWriter, r *http.Request) {
 	log.Debugf("Received new light state by %s", r.RemoteAddr)
 	defer r.Body.Close()
-	lightID, err := strconv.Atoi(mux.Vars(r)["id"])
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
-	}
 	decoder := json.NewDecoder(r.Body)
 	var t LightState
-	err = decoder.Decode(&t)
+	err := decoder.Decode(&t)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
@@ -204,14 +432,21 @@ func activateLightHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	for _, l := range lights {
-		if l.ID == lightID {
-			log.Printf("💡 Light %s - Activating light state %+v as requested by %s", l.Name, t, r.RemoteAddr)
-			l.Automatic = false
-			l.HueLight.setLightState(t.ColorTemperature, t.Brightness, 0)
+	onMainLoop(func(w http.ResponseWriter, r *http.Request) {
+		device := configuration.normalizeDeviceID(DeviceID(mux.Vars(r)["id"]))
+		if _, _, err := device.split(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
 		}
-	}
-	w.Write([]byte("success"))
+		for _, l := range lights {
+			if l.Device == device {
+				log.Printf("💡 Light %s - Activating light state %+v as requested by %s", l.Name, t, r.RemoteAddr)
+				l.Automatic = false
+				l.HueLight.initializeLightState(t.ColorTemperature, t.Brightness, 0)
+			}
+		}
+		w.Write([]byte("success"))
+	})(w, r)
 }
 
 func lightsHandler(w http.ResponseWriter, r *http.Request) {
@@ -228,9 +463,209 @@ func lightsHandler(w http.ResponseWriter, r *http.Request) {
 	w.Write(data)
 }
 
+func schemaHandler(w http.ResponseWriter, r *http.Request) {
+	log.Debugf("Serving configuration schema to %s", r.RemoteAddr)
+	data, err := json.MarshalIndent(ConfigurationSchema(), "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/schema+json")
+	w.Write(data)
+}
+
+func configurationSourcesHandler(w http.ResponseWriter, r *http.Request) {
+	log.Debugf("Serving configuration sources to %s", r.RemoteAddr)
+	sources := make(map[string]string)
+	for _, source := range configuration.SourceList() {
+		sources[source[0]] = source[1]
+	}
+	data, err := json.Marshal(sources)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write(data)
+}
+
+func configurationBackupsHandler(w http.ResponseWriter, r *http.Request) {
+	log.Debugf("Serving configuration backups to %s", r.RemoteAddr)
+	backups, err := configuration.Backups()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if backups == nil {
+		backups = []ConfigurationBackup{}
+	}
+	data, err := json.Marshal(backups)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write(data)
+}
+
+func restoreConfigurationBackupHandler(w http.ResponseWriter, r *http.Request) {
+	r.Body.Close()
+	name := mux.Vars(r)["name"]
+	log.Printf("⚙ Restore of configuration backup %s requested by %s", name, r.RemoteAddr)
+	_, err := configuration.RestoreBackup(name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	configurationWatcher.notify()
+	w.Write([]byte("success"))
+}
+
+func configurationHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	log.Debugf("Serving configuration history to %s", r.RemoteAddr)
+	history, err := configuration.History()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if history == nil {
+		history = []ConfigurationHistoryEntry{}
+	}
+	for index := range history {
+		history[index].Configuration = nil
+	}
+	data, err := json.Marshal(history)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write(data)
+}
+
+func configurationVersionHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	log.Debugf("Serving configuration version %d to %s", id, r.RemoteAddr)
+	entry, err := configuration.HistoryEntry(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write(data)
+}
+
+func revertConfigurationHandler(w http.ResponseWriter, r *http.Request) {
+	r.Body.Close()
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	log.Printf("⚙ Revert of configuration to version %d requested by %s", id, r.RemoteAddr)
+	err = configuration.RevertToVersion(id, "web client "+r.RemoteAddr)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	configurationWatcher.notify()
+	w.Write([]byte("success"))
+}
+
+func awayModeHandler(w http.ResponseWriter, r *http.Request) {
+	log.Debugf("Serving away mode to %s", r.RemoteAddr)
+	data, err := json.Marshal(configuration.AwayMode)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write(data)
+}
+
+func updateAwayModeHandler(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	decoder := json.NewDecoder(r.Body)
+	var t struct {
+		Enabled bool `json:"enabled"`
+	}
+	err := decoder.Decode(&t)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	onMainLoop(func(w http.ResponseWriter, r *http.Request) {
+		if t.Enabled == configuration.AwayMode.Enabled {
+			w.Write([]byte("success"))
+			return
+		}
+		configuration.AwayMode.Enabled = t.Enabled
+		if t.Enabled {
+			log.Printf("🏠 Away mode enabled as requested by %s", r.RemoteAddr)
+			planAwayMode(time.Now())
+		} else {
+			log.Printf("🏠 Away mode disabled as requested by %s", r.RemoteAddr)
+			clearAwayMode()
+		}
+		err := configuration.Write()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		configuration.logHistory("web client " + r.RemoteAddr)
+		w.Write([]byte("success"))
+	})(w, r)
+}
+
 func restartHandler(w http.ResponseWriter, r *http.Request) {
 	log.Printf("Restart requested by %s", r.RemoteAddr)
 	r.Body.Close()
 	w.Write([]byte("success"))
 	Restart()
 }
+
+func pairingHandler(w http.ResponseWriter, r *http.Request) {
+	data, err := json.Marshal(pairing.Status())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+func pairingDiscoverHandler(w http.ResponseWriter, r *http.Request) {
+	log.Debugf("Bridge discovery requested by %s", r.RemoteAddr)
+	pairingCommandResponse(w, pairing.Discover())
+}
+
+func pairingStartHandler(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	var t struct {
+		Address string `json:"address"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&t)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	log.Printf("⌘ Pairing with bridge at %s requested by %s", t.Address, r.RemoteAddr)
+	pairingCommandResponse(w, pairing.Start(strings.TrimSpace(t.Address)))
+}
+
+func pairingCancelHandler(w http.ResponseWriter, r *http.Request) {
+	pairingCommandResponse(w, pairing.Cancel())
+}
+
+func pairingCommandResponse(w http.ResponseWriter, err error) {
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	w.Write([]byte("success"))
+}